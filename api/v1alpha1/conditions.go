@@ -0,0 +1,92 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+// Condition types
+const (
+	// ConditionReady is true once a Folder, a FolderTemplate or a SignedURL is fully reconciled
+	ConditionReady = "Ready"
+	// ConditionFailed is true once a FileTransfer failed for good
+	ConditionFailed = "Failed"
+	// ConditionAuthorized is false while a FileTransfer is not allowed to run, e.g. outside of its Folder,
+	// with a secret of another namespace not shared with it or denied by the StoragePolicies
+	ConditionAuthorized = "Authorized"
+	// ConditionAccepted is true once the owner of a Folder accepted a FolderAccess
+	ConditionAccepted = "Accepted"
+	// ConditionQuotaExceeded is true while the usage of a Folder exceeds its quota
+	ConditionQuotaExceeded = "QuotaExceeded"
+	// ConditionUsageRefreshed is false while the listing of the managed folder of a Folder fails,
+	// the previous usage is kept
+	ConditionUsageRefreshed = "UsageRefreshed"
+)
+
+// Condition reasons, permanent GCP errors use the reasons of the retry package
+// (PermissionDenied, NotFound, InvalidRequest)
+const (
+	ReasonReconciled    = "Reconciled"
+	ReasonObjectsFailed = "ObjectsFailed"
+	ReasonAuthorized    = "Authorized"
+	ReasonAccepted      = "Accepted"
+	ReasonOutsideFolder = "OutsideFolder"
+	// ReasonJobFailed is used for FileTransfers whose worker Job failed without recording the failure itself,
+	// e.g. as its pods kept crashing
+	ReasonJobFailed = "JobFailed"
+	// ReasonNamespaceNotAllowed is used for bucket secrets of other namespaces not shared with the transfer,
+	// or referenced by a worker Job which can not mount them
+	ReasonNamespaceNotAllowed = "NamespaceNotAllowed"
+	// ReasonImpersonationNotAllowed is used for FileTransfers impersonating a service account without base credentials
+	// of their own, the credentials of the operator are never used for it
+	ReasonImpersonationNotAllowed = "ImpersonationNotAllowed"
+	// ReasonOverlappingPrefixes is used for FileTransfers copying into a destination their query lists again
+	ReasonOverlappingPrefixes = "OverlappingPrefixes"
+	// ReasonPolicyDenied is used for Folders and FileTransfers no StoragePolicy allows
+	ReasonPolicyDenied = "PolicyDenied"
+	// ReasonInvalidTemplate is used for FolderTemplates whose selector or templates can not be evaluated
+	ReasonInvalidTemplate = "InvalidTemplate"
+	// ReasonFolderConflict is used for FolderTemplates clashing with Folders they did not create
+	ReasonFolderConflict = "FolderConflict"
+	// ReasonFolderFailed is used for FolderTemplates failing to create, update or delete the Folder of a namespace,
+	// e.g. as the webhook denied it
+	ReasonFolderFailed = "FolderFailed"
+	// ReasonFolderNotReady is used for FolderAccesses and SignedURLs whose Folder is missing or not reconciled yet
+	ReasonFolderNotReady = "FolderNotReady"
+	// ReasonNotShared is used for FolderAccesses the Folder is not shared with
+	ReasonNotShared = "NotShared"
+	// ReasonExecutorUnsupported is used for inline FileTransfers running as a Folder with the Direct identity mode
+	ReasonExecutorUnsupported = "ExecutorUnsupported"
+	// ReasonInvalidCredentialConfig is used for Folders with a credential config but no workload identity provider
+	ReasonInvalidCredentialConfig = "InvalidCredentialConfig"
+	// ReasonHMACKeyUnsupported is used for Folders requesting an HMAC key without a service account
+	ReasonHMACKeyUnsupported = "HMACKeyUnsupported"
+	// ReasonSigningUnsupported is used for SignedURLs of Folders with the Direct identity mode, without
+	// a service account signing the URLs
+	ReasonSigningUnsupported = "SigningUnsupported"
+	// ReasonQuotaExceeded and ReasonWithinQuota are used for the QuotaExceeded condition of Folders
+	ReasonQuotaExceeded = "QuotaExceeded"
+	ReasonWithinQuota   = "WithinQuota"
+	// ReasonListingFailed is used for the UsageRefreshed condition of Folders whose listing failed transiently
+	ReasonListingFailed = "ListingFailed"
+	// ReasonAdoptionRefused is used for Folders whose managed folder or service account already exists,
+	// but may not be adopted
+	ReasonAdoptionRefused = "AdoptionRefused"
+	// ReasonNotOwned is used for FileTransfers whose manifest ConfigMap, for Folders whose credential ConfigMap
+	// or HMAC key Secret and for SignedURLs whose target already exists and was not created for them,
+	// it is never overwritten
+	ReasonNotOwned = "NotOwned"
+	// ReasonExpired is used for Folders and FolderAccesses whose role expired
+	ReasonExpired = "Expired"
+)
@@ -22,6 +22,8 @@ import (
 )
 
 // FileTransferSpec defines the desired state of FileTransfer
+// +kubebuilder:validation:XValidation:rule="!has(self.serviceAccountName) || !has(self.credentials) || !has(self.credentials.folderRef)",message="serviceAccountName and credentials.folderRef are mutually exclusive"
+// +kubebuilder:validation:XValidation:rule="!has(self.credentials) || !has(self.credentials.impersonateServiceAccount) || has(self.bucketSecret) || (has(self.executor) && self.executor == 'Job')",message="credentials.impersonateServiceAccount needs a bucketSecret or the Job executor"
 type FileTransferSpec struct {
 	// BucketName is the source bucket
 	BucketName string `json:"bucketName"`
@@ -34,10 +36,139 @@ type FileTransferSpec struct {
 
 	// Secret
 	BucketSecret *v1.SecretReference `json:"bucketSecret,omitempty"`
+
+	// Credentials tune how the transfer authenticates. By default the key in bucketSecret
+	// or the credentials of the operator are used.
+	// +optional
+	Credentials *Credentials `json:"credentials,omitempty"`
+
+	// Executor defines where the copy runs. Inline copies inside the controller,
+	// Job launches a worker Job and the controller only orchestrates it.
+	// +kubebuilder:validation:Enum=Inline;Job
+	// +kubebuilder:default=Inline
+	// +optional
+	Executor Executor `json:"executor,omitempty"`
+
+	// Suspend stops a running copy after the current batch. The progress is recorded
+	// and the copy resumes from where it stopped once suspend is unset.
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// TTLSecondsAfterFinished deletes the FileTransfer the given amount of seconds after it finished:
+	// once it is done or failed, a dry run is planned or a listing-only transfer recorded its listing.
+	// Like for Jobs, the FileTransfer is kept if unset.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TTLSecondsAfterFinished *int32 `json:"ttlSecondsAfterFinished,omitempty"`
+
+	// TTLSecondsAfterFailure overrides TTLSecondsAfterFinished for failed transfers,
+	// e.g. to keep them around longer for debugging.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TTLSecondsAfterFailure *int32 `json:"ttlSecondsAfterFailure,omitempty"`
+
+	// DryRun computes the plan of the copy without writing any object.
+	// The plan is reported in the status and, per object, in the manifest.
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// Verify compares every copied object against the CRC32C and MD5 checksums of its source.
+	// Mismatches are copied again, up to 3 times, before the object is reported as failed.
+	// +optional
+	Verify bool `json:"verify,omitempty"`
+
+	// Manifest writes every listed object with the result of its copy to a ConfigMap or an object
+	// +optional
+	Manifest *Manifest `json:"manifest,omitempty"`
+
+	// ServiceAccountName the worker Job runs as, e.g. the workload identity ServiceAccount of a Folder.
+	// Only used with the Job executor, if empty the ServiceAccount of credentials.folderRef
+	// or the namespace default ServiceAccount is used.
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+}
+
+// Credentials of a FileTransfer
+// +kubebuilder:validation:XValidation:rule="!has(self.impersonateServiceAccount) || !has(self.folderRef)",message="impersonateServiceAccount and folderRef are mutually exclusive"
+type Credentials struct {
+	// SecretKey is the key of bucketSecret holding the credentials, either a service account key or an
+	// external account configuration (workload identity federation). Defaults to service_account_private_key.
+	// +optional
+	SecretKey string `json:"secretKey,omitempty"`
+
+	// ImpersonateServiceAccount is the email of a service account impersonated with the base credentials.
+	// The base credentials need roles/iam.serviceAccountTokenCreator on it. They come from bucketSecret or,
+	// for worker Jobs, the workload identity of the pod. The credentials of the operator never impersonate it.
+	// +optional
+	ImpersonateServiceAccount string `json:"impersonateServiceAccount,omitempty"`
+
+	// FolderRef runs the transfer as the service account of a Folder in the namespace of the transfer.
+	// Inline copies and worker Jobs with bucketSecret impersonate it, other worker Jobs run as the
+	// Kubernetes ServiceAccount of the Folder.
+	// +optional
+	FolderRef *v1.LocalObjectReference `json:"folderRef,omitempty"`
 }
 
+// Manifest is the output of the transferred objects. Each row holds the key, size, generation,
+// CRC32C checksum and copy result of an object.
+// +kubebuilder:validation:XValidation:rule="has(self.configMap) != has(self.object)",message="exactly one of configMap or object must be set"
+type Manifest struct {
+	// ConfigMap in the namespace of the FileTransfer receiving the manifest.
+	// Only suited for small listings as ConfigMaps are limited to 1MiB.
+	// +optional
+	ConfigMap string `json:"configMap,omitempty"`
+
+	// Object receiving the manifest, suited for large listings
+	// +optional
+	Object *ManifestObject `json:"object,omitempty"`
+
+	// Format of the manifest
+	// +kubebuilder:validation:Enum=JSONL;CSV
+	// +kubebuilder:default=JSONL
+	// +optional
+	Format ManifestFormat `json:"format,omitempty"`
+}
+
+type ManifestObject struct {
+	// Bucket of the manifest object, defaults to the source bucket
+	// +optional
+	Bucket string `json:"bucket,omitempty"`
+
+	// Name of the manifest object
+	Name string `json:"name"`
+}
+
+type ManifestFormat string
+
+const (
+	ManifestFormatJSONL ManifestFormat = "JSONL"
+	ManifestFormatCSV   ManifestFormat = "CSV"
+)
+
+// CancelAnnotation set to "true" stops a running copy like spec.suspend.
+// The copy resumes once the annotation is removed.
+const CancelAnnotation = "csfo.sijoma.dev/cancel"
+
+// Executor runs the copy of a FileTransfer
+type Executor string
+
+const (
+	ExecutorInline Executor = "Inline"
+	ExecutorJob    Executor = "Job"
+)
+
 type Query struct {
 	Prefix string `json:"prefix,omitempty"`
+
+	// Delimiter lists hierarchically, only objects directly below the prefix are listed and copied, e.g. "/".
+	// Listing-only transfers with summarize list all objects, the delimiter then only groups the summary.
+	// +optional
+	Delimiter string `json:"delimiter,omitempty"`
+
+	// Summarize reports the object count and bytes per immediate sub-prefix in status.summary.
+	// Sub-prefixes are separated by the delimiter, "/" if unset. Copies summarize the objects they copied.
+	// +optional
+	Summarize bool `json:"summarize,omitempty"`
 }
 
 type CopyDestination struct {
@@ -48,10 +179,101 @@ type CopyDestination struct {
 // FileTransferStatus defines the observed state of FileTransfer
 type FileTransferStatus struct {
 	FoundObjects int `json:"foundObjects"`
-	// Todo: Implement Conditions
+	// CopyStatus is the phase of the transfer, failures are detailed by the Failed condition
 	CopyStatus string `json:"copyStatus"`
+
+	// CopiedObjects is the amount of objects copied (or skipped as identical) so far, failed objects are not included
+	// +optional
+	CopiedObjects int `json:"copiedObjects,omitempty"`
+
+	// FailedObjects is the amount of objects of which the copy or its verification failed.
+	// A transfer with failed objects ends as Failed.
+	// +optional
+	FailedObjects int `json:"failedObjects,omitempty"`
+
+	// Failures lists the first failed objects, all of them are part of the manifest
+	// +optional
+	Failures []ObjectFailure `json:"failures,omitempty"`
+
+	// CompletionTime is the time the transfer was observed as done or failed
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// ResumeFrom is the key of the first object not yet copied by a stopped copy
+	// +optional
+	ResumeFrom string `json:"resumeFrom,omitempty"`
+
+	// Plan of the copy computed by a dry run
+	// +optional
+	Plan *TransferPlan `json:"plan,omitempty"`
+
+	// Manifest is the location the manifest was written to
+	// +optional
+	Manifest string `json:"manifest,omitempty"`
+
+	// Summary is the object count and bytes per immediate sub-prefix, set when query.summarize is enabled.
+	// Objects directly below the query prefix are reported under the query prefix itself.
+	// +optional
+	Summary []PrefixSummary `json:"summary,omitempty"`
+
+	// SummaryTruncated is set when there are more sub-prefixes than fit into the summary
+	// +optional
+	SummaryTruncated bool `json:"summaryTruncated,omitempty"`
+
+	// CredentialsVersion is the version (UID and resourceVersion) of the bucket secret of the latest attempt.
+	// A transfer that failed for missing permissions is retried once the secret changes.
+	// +optional
+	CredentialsVersion string `json:"credentialsVersion,omitempty"`
+
+	// JobName is the worker Job running the copy when using the Job executor
+	// +optional
+	JobName string `json:"jobName,omitempty"`
+
+	// Conditions of the latest reconcile
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// TransferPlan is the outcome of a dry run
+type TransferPlan struct {
+	// Copy is the amount of objects not present at the destination
+	Copy int `json:"copy"`
+	// Overwrite is the amount of objects replacing a different destination object
+	Overwrite int `json:"overwrite"`
+	// Skip is the amount of objects with an identical destination object
+	Skip int `json:"skip"`
+	// TotalBytes is the amount of bytes that would be written
+	TotalBytes int64 `json:"totalBytes"`
 }
 
+// ObjectFailure is an object that could not be copied
+type ObjectFailure struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+// PrefixSummary is the object count and size below a prefix
+type PrefixSummary struct {
+	Prefix  string `json:"prefix"`
+	Objects int    `json:"objects"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// Values of FileTransferStatus.CopyStatus
+const (
+	// CopyStatusPlanned is set once a dry run computed the plan
+	CopyStatusPlanned = "Planned"
+	CopyStatusRunning = "Running"
+	// CopyStatusSuspended is set while spec.suspend stops the copy
+	CopyStatusSuspended = "Suspended"
+	// CopyStatusCancelled is set while the CancelAnnotation stops the copy
+	CopyStatusCancelled = "Cancelled"
+	CopyStatusDone      = "Done"
+	CopyStatusFailed    = "Failed"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 
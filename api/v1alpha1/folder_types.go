@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -27,13 +28,232 @@ type FolderSpec struct {
 
 	// The name of the managed folder, expressed as a path. For example, example-dir or example-dir/example-dir1.
 	Name string `json:"name"`
+
+	// Role granted to the service account of the Folder on the managed folder
+	// +kubebuilder:default="roles/storage.folderAdmin"
+	// +optional
+	Role string `json:"role,omitempty"`
+
+	// ExpiresAt limits the role on the managed folder, it is bound with an IAM condition
+	// and the binding is removed once expired
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	// IdentityMode is how the Kubernetes ServiceAccount of the Folder gets its permissions.
+	// ServiceAccount creates a service account used through workload identity,
+	// Direct grants the role straight to the principal of the Kubernetes ServiceAccount without a service account.
+	// +kubebuilder:validation:Enum=ServiceAccount;Direct
+	// +kubebuilder:default=ServiceAccount
+	// +optional
+	IdentityMode IdentityMode `json:"identityMode,omitempty"`
+
+	// AdoptionPolicy is how a managed folder or service account is handled which already exists, but was not created
+	// for this Folder. Fail reports it on the Ready condition without touching it, Adopt uses it.
+	// +kubebuilder:validation:Enum=Adopt;Fail
+	// +kubebuilder:default=Fail
+	// +optional
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+
+	// CredentialConfig emits a ConfigMap with an external_account credential configuration,
+	// for workloads on clusters outside of GKE using workload identity federation
+	// +optional
+	CredentialConfig *CredentialConfig `json:"credentialConfig,omitempty"`
+
+	// HMACKey creates an HMAC key for the service account of the Folder, for tools using the S3
+	// interoperability API. Needs the ServiceAccount identity mode.
+	// +optional
+	HMACKey *HMACKeySpec `json:"hmacKey,omitempty"`
+
+	// Quota is a soft quota on the usage of the managed folder, checked whenever the usage is refreshed
+	// +optional
+	Quota *FolderQuota `json:"quota,omitempty"`
+
+	// SharedWith accepts FolderAccesses from other namespaces requesting one of the listed roles
+	// +optional
+	SharedWith []FolderShare `json:"sharedWith,omitempty"`
+}
+
+// FolderShare accepts FolderAccesses of a namespace
+type FolderShare struct {
+	// Namespace of the accepted FolderAccesses
+	Namespace string `json:"namespace"`
+
+	// Roles the FolderAccesses may request
+	// +kubebuilder:validation:MinItems=1
+	Roles []string `json:"roles"`
+
+	// ServiceAccounts the FolderAccesses may bind through serviceAccountEmail. Without, only the service accounts
+	// the operator creates for them are bound.
+	// +optional
+	ServiceAccounts []string `json:"serviceAccounts,omitempty"`
+}
+
+// CredentialConfig of the ConfigMap holding the credential configuration of a Folder
+type CredentialConfig struct {
+	// WorkloadIdentityProvider exchanging the ServiceAccount tokens, e.g.
+	// projects/123/locations/global/workloadIdentityPools/my-pool/providers/my-cluster.
+	// Defaults to the provider configured for the operator.
+	// +optional
+	WorkloadIdentityProvider string `json:"workloadIdentityProvider,omitempty"`
+
+	// TokenPath is where workloads mount the projected ServiceAccount token.
+	// The token has to be issued for the audience of the provider.
+	// +kubebuilder:default="/var/run/secrets/tokens/gcp-token"
+	// +optional
+	TokenPath string `json:"tokenPath,omitempty"`
+
+	// ConfigMapName defaults to <folder>-credentials
+	// +optional
+	ConfigMapName string `json:"configMapName,omitempty"`
+}
+
+// FolderQuota limits the usage of a Folder. Writes are not blocked by GCS, exceeding the quota sets the
+// QuotaExceeded condition, emits an Event and optionally makes the Folder read-only.
+type FolderQuota struct {
+	// MaxBytes is the total size of the objects in the managed folder, e.g. 10Gi
+	// +optional
+	MaxBytes *resource.Quantity `json:"maxBytes,omitempty"`
+
+	// MaxObjects is the amount of objects in the managed folder
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxObjects *int64 `json:"maxObjects,omitempty"`
+
+	// ReadOnly grants roles/storage.objectViewer instead of the role of the Folder while the quota is exceeded
+	// +optional
+	ReadOnly bool `json:"readOnly,omitempty"`
+}
+
+// ReadOnlyFolderRole is granted instead of the role of a read-only Folder exceeding its quota
+const ReadOnlyFolderRole = "roles/storage.objectViewer"
+
+// HMACKeySpec configures the HMAC key of a Folder and its rotation
+type HMACKeySpec struct {
+	// SecretName of the Secret holding the key, defaults to <folder>-hmac
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// RotationInterval replaces the key once it is older, the key is not rotated if empty
+	// +optional
+	RotationInterval *metav1.Duration `json:"rotationInterval,omitempty"`
+
+	// GracePeriod keeps a replaced key active, it is deactivated and deleted afterwards
+	// +kubebuilder:default="24h"
+	// +optional
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
 }
 
+// IdentityMode of a Folder
+type IdentityMode string
+
+const (
+	IdentityModeServiceAccount IdentityMode = "ServiceAccount"
+	IdentityModeDirect         IdentityMode = "Direct"
+)
+
+// AdoptionPolicy of existing GCP resources
+type AdoptionPolicy string
+
+const (
+	AdoptionPolicyAdopt AdoptionPolicy = "Adopt"
+	AdoptionPolicyFail  AdoptionPolicy = "Fail"
+)
+
+// Ownership of a GCP resource, whether the operator created it or adopted an existing one
+type Ownership string
+
+const (
+	OwnershipCreated Ownership = "Created"
+	OwnershipAdopted Ownership = "Adopted"
+)
+
+// DefaultFolderRole is granted on the managed folder unless the Folder sets a role
+const DefaultFolderRole = "roles/storage.folderAdmin"
+
 // FolderStatus defines the observed state of Folder
 type FolderStatus struct {
 	ServiceAccountName string `json:"serviceAccountName"`
-	Email              string `json:"email"`
-	Folder             string `json:"folder"`
+	// Email of the service account, empty with the Direct identity mode
+	Email string `json:"email"`
+	// Principal the role on the managed folder is granted to, empty while none is granted
+	// +optional
+	Principal string `json:"principal,omitempty"`
+	// Role granted to the principal on the managed folder, empty while none is granted.
+	// It is revoked once the Folder grants another role or is denied by the StoragePolicies.
+	// +optional
+	Role   string `json:"role,omitempty"`
+	Folder string `json:"folder"`
+	// Bucket of status.folder. A Folder moved to another bucket or managed folder releases the previous one.
+	// +optional
+	Bucket string `json:"bucket,omitempty"`
+	// CredentialConfigMap holds the credential configuration under credential-configuration.json
+	// +optional
+	CredentialConfigMap string `json:"credentialConfigMap,omitempty"`
+
+	// HMACKey is the key in use and the replaced keys awaiting deletion
+	// +optional
+	HMACKey *HMACKeyStatus `json:"hmacKey,omitempty"`
+
+	// Usage of the managed folder, refreshed on a schedule
+	// +optional
+	Usage *FolderUsage `json:"usage,omitempty"`
+	// ReadOnly is true while the role is swapped for roles/storage.objectViewer as the quota is exceeded
+	// +optional
+	ReadOnly bool `json:"readOnly,omitempty"`
+
+	// Ownership records whether the GCP resources of the Folder were created or adopted
+	// +optional
+	Ownership *FolderOwnership `json:"ownership,omitempty"`
+
+	// Conditions of the latest reconcile
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// FolderOwnership is the ownership of the GCP resources of a Folder. Only created resources are owned by the Folder,
+// adopted ones existed before and are expected to outlive it.
+type FolderOwnership struct {
+	// ManagedFolder is the ownership of the managed folder in status.folder
+	// +optional
+	ManagedFolder Ownership `json:"managedFolder,omitempty"`
+	// ServiceAccount is the ownership of the service account in status.email, empty with the Direct identity mode
+	// +optional
+	ServiceAccount Ownership `json:"serviceAccount,omitempty"`
+}
+
+// FolderUsage is the object count and total size below a managed folder
+type FolderUsage struct {
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+	// LastUpdated is when the managed folder was listed
+	LastUpdated metav1.Time `json:"lastUpdated"`
+}
+
+// HMACKeyStatus is the state of the HMAC keys of a Folder
+type HMACKeyStatus struct {
+	// SecretName of the Secret holding the current key
+	SecretName string `json:"secretName"`
+	// AccessID of the current key
+	AccessID string `json:"accessID"`
+	// CreatedAt is when the current key was created
+	CreatedAt metav1.Time `json:"createdAt"`
+
+	// RetiredKeys were replaced, they are deleted once the grace period passed
+	// +optional
+	RetiredKeys []RetiredHMACKey `json:"retiredKeys,omitempty"`
+
+	// PendingSince is set while a new key is created whose access ID is not recorded yet.
+	// Unknown keys of the service account created since then are deleted on the next reconcile.
+	// +optional
+	PendingSince *metav1.Time `json:"pendingSince,omitempty"`
+}
+
+// RetiredHMACKey is a replaced HMAC key
+type RetiredHMACKey struct {
+	AccessID  string      `json:"accessID"`
+	RetiredAt metav1.Time `json:"retiredAt"`
 }
 
 //+kubebuilder:object:root=true
@@ -0,0 +1,103 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FolderAccessSpec requests a role on the managed folder of a Folder in another namespace.
+// The access is only granted once the Folder shares itself with the namespace of the FolderAccess.
+type FolderAccessSpec struct {
+	// FolderRef is the Folder to access
+	FolderRef FolderReference `json:"folderRef"`
+
+	// Role requested on the managed folder
+	// +kubebuilder:default="roles/storage.objectViewer"
+	// +optional
+	Role string `json:"role,omitempty"`
+
+	// ExpiresAt limits the access, the role is bound with an IAM condition and the binding is removed once expired
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	// ServiceAccountEmail binds an existing service account, the Folder has to share itself with it.
+	// If empty, a service account is created along with a Kubernetes ServiceAccount in the namespace
+	// of the FolderAccess using it through workload identity.
+	// +optional
+	ServiceAccountEmail string `json:"serviceAccountEmail,omitempty"`
+}
+
+// FolderReference references a Folder in any namespace
+type FolderReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// FolderAccessBinding is a role binding on a managed folder
+type FolderAccessBinding struct {
+	Bucket string `json:"bucket"`
+	Folder string `json:"folder"`
+	Role   string `json:"role"`
+	Member string `json:"member"`
+}
+
+// FolderAccessStatus defines the observed state of FolderAccess
+type FolderAccessStatus struct {
+	// ServiceAccountName is the Kubernetes ServiceAccount using the access, empty for an existing service account
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+	// Email of the bound service account. Unless it is spec.serviceAccountEmail the service account was created
+	// for the FolderAccess, it is deleted along with it.
+	// +optional
+	Email string `json:"email,omitempty"`
+
+	// Binding is the granted role, it is revoked once the access is deleted or no longer accepted
+	// +optional
+	Binding *FolderAccessBinding `json:"binding,omitempty"`
+
+	// Conditions of the latest reconcile
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// FolderAccess is the Schema for the folderaccesses API
+type FolderAccess struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FolderAccessSpec   `json:"spec,omitempty"`
+	Status FolderAccessStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// FolderAccessList contains a list of FolderAccess
+type FolderAccessList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FolderAccess `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&FolderAccess{}, &FolderAccessList{})
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FolderTemplateSpec defines the Folders created in every selected namespace.
+// BucketName and Name of the folder spec are Go templates, e.g. "tenants/{{.Namespace}}",
+// with the fields Namespace and Labels (of the namespace).
+type FolderTemplateSpec struct {
+	// NamespaceSelector selects the namespaces receiving a Folder, an empty selector selects all namespaces
+	// +optional
+	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// FolderName is the name of the Folder created in each namespace
+	// +kubebuilder:default=storage
+	// +optional
+	FolderName string `json:"folderName,omitempty"`
+
+	// Folder is the templated spec of the created Folders
+	Folder FolderSpec `json:"folder"`
+}
+
+// FolderTemplateStatus defines the observed state of FolderTemplate
+type FolderTemplateStatus struct {
+	// Folders is the amount of Folders created from the template
+	// +optional
+	Folders int `json:"folders,omitempty"`
+
+	// Conditions of the latest reconcile
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+
+// FolderTemplate is the Schema for the foldertemplates API
+type FolderTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FolderTemplateSpec   `json:"spec,omitempty"`
+	Status FolderTemplateStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// FolderTemplateList contains a list of FolderTemplate
+type FolderTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FolderTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&FolderTemplate{}, &FolderTemplateList{})
+}
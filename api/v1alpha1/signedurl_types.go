@@ -0,0 +1,137 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SignedURLSpec issues V4 signed URLs for the objects of a Folder, e.g. to share them with external partners.
+// The URLs are signed as the service account of the Folder and regenerated before they expire.
+// +kubebuilder:validation:XValidation:rule="has(self.object) != has(self.query)",message="exactly one of object or query must be set"
+// +kubebuilder:validation:XValidation:rule="!has(self.method) || self.method != 'PUT' || has(self.object)",message="PUT URLs need an object"
+type SignedURLSpec struct {
+	// FolderRef is the Folder in the namespace of the SignedURL signing the URLs.
+	// The objects have to be inside its managed folder.
+	FolderRef v1.LocalObjectReference `json:"folderRef"`
+
+	// Object is the name of a single object, it does not need to exist for PUT URLs
+	// +optional
+	Object string `json:"object,omitempty"`
+
+	// Query signs a URL for every listed object, summarize is ignored
+	// +optional
+	Query *Query `json:"query,omitempty"`
+
+	// Method allowed by the URLs
+	// +kubebuilder:validation:Enum=GET;PUT
+	// +kubebuilder:default=GET
+	// +optional
+	Method SignedURLMethod `json:"method,omitempty"`
+
+	// Expiry is how long the URLs are valid, at most 7 days (168h)
+	// +kubebuilder:default="24h"
+	// +optional
+	Expiry *metav1.Duration `json:"expiry,omitempty"`
+
+	// RenewBefore regenerates the URLs once they expire within the given duration, defaults to a third of the expiry
+	// +optional
+	RenewBefore *metav1.Duration `json:"renewBefore,omitempty"`
+
+	// MaxObjects limits the URLs of a query, Secrets and ConfigMaps are limited to 1MiB
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=1000
+	// +kubebuilder:default=100
+	// +optional
+	MaxObjects int `json:"maxObjects,omitempty"`
+
+	// Target receives the URLs
+	Target SignedURLTarget `json:"target"`
+}
+
+// SignedURLMethod is the HTTP method allowed by a signed URL
+type SignedURLMethod string
+
+const (
+	SignedURLMethodGet SignedURLMethod = "GET"
+	SignedURLMethodPut SignedURLMethod = "PUT"
+)
+
+// SignedURLTarget is the Secret or ConfigMap in the namespace of the SignedURL receiving the URLs.
+// The key urls.json maps the object names to their URLs, a single object is also written to the key url.
+// +kubebuilder:validation:XValidation:rule="has(self.secretName) != has(self.configMapName)",message="exactly one of secretName or configMapName must be set"
+type SignedURLTarget struct {
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+	// +optional
+	ConfigMapName string `json:"configMapName,omitempty"`
+}
+
+// SignedURLStatus defines the observed state of SignedURL
+type SignedURLStatus struct {
+	// URLs is the amount of signed URLs
+	// +optional
+	URLs int `json:"urls,omitempty"`
+
+	// Truncated is true if the query listed more than maxObjects objects
+	// +optional
+	Truncated bool `json:"truncated,omitempty"`
+
+	// ExpirationTime of the current URLs
+	// +optional
+	ExpirationTime *metav1.Time `json:"expirationTime,omitempty"`
+
+	// RenewTime is when the URLs are regenerated
+	// +optional
+	RenewTime *metav1.Time `json:"renewTime,omitempty"`
+
+	// ObservedGeneration is the generation the current URLs were signed for
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions of the latest reconcile
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// SignedURL is the Schema for the signedurls API
+type SignedURL struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SignedURLSpec   `json:"spec,omitempty"`
+	Status SignedURLStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// SignedURLList contains a list of SignedURL
+type SignedURLList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SignedURL `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SignedURL{}, &SignedURLList{})
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TransferMode is what a FileTransfer does with the listed objects
+// +kubebuilder:validation:Enum=List;DryRun;Copy
+type TransferMode string
+
+const (
+	// TransferModeList only lists objects, the transfer has no copy destination
+	TransferModeList TransferMode = "List"
+	// TransferModeDryRun plans a copy without writing objects
+	TransferModeDryRun TransferMode = "DryRun"
+	// TransferModeCopy copies objects
+	TransferModeCopy TransferMode = "Copy"
+)
+
+// StoragePolicySpec defines what the selected namespaces may access.
+// Without any StoragePolicy nothing is restricted. Once a StoragePolicy exists, Folders and FileTransfers
+// are only allowed what at least one of the policies selecting their namespace allows.
+type StoragePolicySpec struct {
+	// NamespaceSelector selects the namespaces the policy applies to, an empty selector selects all namespaces
+	// +optional
+	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Buckets the selected namespaces may use
+	// +kubebuilder:validation:MinItems=1
+	Buckets []string `json:"buckets"`
+
+	// Prefixes limit the object names and managed folders inside the buckets. "{namespace}" is replaced
+	// by the namespace, e.g. "teams/{namespace}/". Empty allows the whole buckets.
+	// +optional
+	Prefixes []string `json:"prefixes,omitempty"`
+
+	// Roles Folders may grant on their managed folder. Empty allows all roles.
+	// +optional
+	Roles []string `json:"roles,omitempty"`
+
+	// TransferModes FileTransfers may run in. Empty allows all modes.
+	// +optional
+	TransferModes []TransferMode `json:"transferModes,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+
+// StoragePolicy is the Schema for the storagepolicies API
+type StoragePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec StoragePolicySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// StoragePolicyList contains a list of StoragePolicy
+type StoragePolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []StoragePolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&StoragePolicy{}, &StoragePolicyList{})
+}
@@ -17,19 +17,26 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"flag"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
@@ -37,6 +44,10 @@ import (
 
 	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
 	"github.com/sijoma/cloud-storage-file-operator/internal/controller"
+	webhookcsfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/internal/webhook/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/gcs"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/resources"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/tracing"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -53,12 +64,23 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == resources.TransferCommand {
+		os.Exit(runTransfer(os.Args[2:]))
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
 	var gcpProjectID string
+	var tracingOpts tracing.Options
+	var workerImage string
+	var storageClientIdleTimeout time.Duration
+	var folderUsageInterval time.Duration
+	var operatorServiceAccount string
+	var gcpProjectNumber string
+	var workloadIdentityProvider string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
@@ -69,6 +91,26 @@ func main() {
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
 	flag.StringVar(&gcpProjectID, "gcp-project-id", "", "The gcp project id to use")
+	flag.StringVar(&workloadIdentityProvider, "workload-identity-provider", "",
+		"The workload identity provider of the credential configurations of Folders, "+
+			"e.g. projects/123/locations/global/workloadIdentityPools/my-pool/providers/my-cluster.")
+	flag.StringVar(&gcpProjectNumber, "gcp-project-number", "",
+		"The number of the gcp project, used by Folders with the Direct identity mode. Looked up if empty.")
+	flag.StringVar(&workerImage, "worker-image", "",
+		"The image of the Jobs running transfers with the Job executor. Defaults to the image of the manager pod.")
+	flag.StringVar(&operatorServiceAccount, "operator-service-account", "",
+		"The email of the operator's GCP service account. If set, it may impersonate the service accounts of Folders "+
+			"to run FileTransfers and SignedURLs of their namespace referencing them.")
+	flag.DurationVar(&storageClientIdleTimeout, "storage-client-idle-timeout", 10*time.Minute,
+		"Cached storage clients unused for this long are closed")
+	flag.DurationVar(&folderUsageInterval, "folder-usage-interval", time.Hour,
+		"How often the object count and size of the managed folders is refreshed, 0 disables the usage accounting.")
+	flag.StringVar(&tracingOpts.Endpoint, "otlp-endpoint", "",
+		"The OTLP gRPC endpoint traces are exported to, e.g. localhost:4317. Tracing is disabled if empty.")
+	flag.BoolVar(&tracingOpts.Insecure, "otlp-insecure", false,
+		"If set, traces are exported to the OTLP endpoint without TLS")
+	flag.Float64Var(&tracingOpts.SampleRatio, "trace-sample-ratio", 1.0,
+		"The fraction of reconciles that are traced")
 
 	opts := zap.Options{
 		Development: true,
@@ -82,6 +124,17 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	shutdownTracing, err := tracing.Setup(context.Background(), tracingOpts)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			setupLog.Error(err, "problem shutting down tracing")
+		}
+	}()
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancelation and
@@ -109,6 +162,17 @@ func main() {
 			SecureServing: secureMetrics,
 			TLSOpts:       tlsOpts,
 		},
+		// Secrets are only watched by their metadata and read from the API server: FileTransfers watch their
+		// bucket secrets, Folders and SignedURLs the Secrets they own. The annotations are dropped from the cache,
+		// the last applied configuration of kubectl holds the data of a Secret.
+		Cache: cache.Options{
+			ByObject: map[client.Object]cache.ByObject{
+				&corev1.Secret{}: {Transform: stripSecretMetadata},
+			},
+		},
+		Client: client.Options{
+			Cache: &client.CacheOptions{DisableFor: []client.Object{&corev1.Secret{}}},
+		},
 		WebhookServer:          webhookServer,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
@@ -130,21 +194,74 @@ func main() {
 		os.Exit(1)
 	}
 
+	if workerImage == "" {
+		workerImage, err = managerImage(context.Background(), mgr.GetAPIReader())
+		if err != nil {
+			setupLog.Error(err, "unable to detect worker image, executor Job is unavailable")
+		}
+	}
+
+	gcsClients := gcs.NewClientCache(storageClientIdleTimeout)
+	if err := mgr.Add(gcsClients); err != nil {
+		setupLog.Error(err, "unable to add storage client cache")
+		os.Exit(1)
+	}
 	if err = (&controller.FileTransferReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		Clients:     gcsClients,
+		WorkerImage: workerImage,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "FileTransfer")
 		os.Exit(1)
 	}
 
 	if err = (&controller.FolderReconciler{
+		Client:                   mgr.GetClient(),
+		Scheme:                   mgr.GetScheme(),
+		OperatorServiceAccount:   operatorServiceAccount,
+		ProjectNumber:            gcpProjectNumber,
+		WorkloadIdentityProvider: workloadIdentityProvider,
+		Clients:                  gcsClients,
+		UsageInterval:            folderUsageInterval,
+		Recorder:                 mgr.GetEventRecorderFor("folder-controller"),
+	}).SetupWithManager(mgr, gcpProjectID); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Folder")
+		os.Exit(1)
+	}
+	if err = (&controller.FolderTemplateReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr, "camunda-operator-test"); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Folder")
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "FolderTemplate")
 		os.Exit(1)
 	}
+	if err = (&controller.FolderAccessReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr, gcpProjectID); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "FolderAccess")
+		os.Exit(1)
+	}
+	if err = (&controller.SignedURLReconciler{
+		Client:  mgr.GetClient(),
+		Scheme:  mgr.GetScheme(),
+		Clients: gcsClients,
+	}).SetupWithManager(mgr, gcpProjectID); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "SignedURL")
+		os.Exit(1)
+	}
+	// nolint:goconst
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = webhookcsfov1alpha1.SetupFileTransferWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "FileTransfer")
+			os.Exit(1)
+		}
+		if err = webhookcsfov1alpha1.SetupFolderWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Folder")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -162,3 +279,30 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// managerImage looks up the image of the running manager pod, which is identified by the downward API
+func managerImage(ctx context.Context, reader client.Reader) (string, error) {
+	key := types.NamespacedName{Name: os.Getenv("POD_NAME"), Namespace: os.Getenv("POD_NAMESPACE")}
+	if key.Name == "" || key.Namespace == "" {
+		return "", errors.New("env POD_NAME and POD_NAMESPACE are not set")
+	}
+	var pod corev1.Pod
+	if err := reader.Get(ctx, key, &pod); err != nil {
+		return "", err
+	}
+	for _, container := range pod.Spec.Containers {
+		if container.Name == "manager" {
+			return container.Image, nil
+		}
+	}
+	return "", errors.New("manager container not found")
+}
+
+// stripSecretMetadata keeps only the identity, labels and owners of the cached Secret metadata
+func stripSecretMetadata(obj interface{}) (interface{}, error) {
+	if object, ok := obj.(metav1.Object); ok {
+		object.SetAnnotations(nil)
+		object.SetManagedFields(nil)
+	}
+	return obj, nil
+}
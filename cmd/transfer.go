@@ -0,0 +1,93 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/internal/transfer"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/gcs"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/resources"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/retrievers"
+)
+
+// runTransfer is the entrypoint of the worker Job running a single FileTransfer.
+// Credentials are taken from the environment (workload identity or a mounted key), optionally impersonating
+// the service account of the spec or the Folder.
+func runTransfer(args []string) int {
+	var key types.NamespacedName
+	var folderServiceAccount string
+	flags := flag.NewFlagSet("transfer", flag.ExitOnError)
+	flags.StringVar(&key.Name, "name", "", "The name of the FileTransfer to run")
+	flags.StringVar(&key.Namespace, "namespace", "", "The namespace of the FileTransfer to run")
+	flags.StringVar(&folderServiceAccount, "impersonate", "",
+		"The service account of the Folder impersonated with the mounted key")
+	opts := zap.Options{
+		Development: true,
+	}
+	opts.BindFlags(flags)
+	_ = flags.Parse(args)
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	logger := ctrl.Log.WithName("transfer").WithValues("filetransfer", key.Name, "namespace", key.Namespace)
+
+	if key.Name == "" || key.Namespace == "" {
+		logger.Error(errors.New("flags name and namespace are required"), "invalid arguments")
+		return 1
+	}
+
+	ctx := log.IntoContext(ctrl.SetupSignalHandler(), logger)
+
+	k8sClient, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		logger.Error(err, "unable to create kubernetes client")
+		return 1
+	}
+
+	fileTransferCR := new(csfov1alpha1.FileTransfer)
+	if err := k8sClient.Get(ctx, key, fileTransferCR); err != nil {
+		logger.Error(err, "unable to get filetransfer")
+		return 1
+	}
+
+	gcsClient, err := gcs.NewGcsClient(ctx, retrievers.WorkerOptions(fileTransferCR, folderServiceAccount)...)
+	if err != nil {
+		logger.Error(err, "failed to create gcs client")
+		return 1
+	}
+	defer gcsClient.Close()
+
+	if err := transfer.Run(ctx, k8sClient, gcsClient, fileTransferCR); err != nil {
+		logger.Error(err, "failed to transfer files")
+		if transfer.IsPermanent(err) {
+			if err := transfer.MarkFailed(ctx, k8sClient, fileTransferCR, err); err != nil {
+				logger.Error(err, "failed to update status")
+			}
+			return resources.PermanentFailureExitCode
+		}
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/internal/policy"
+	"github.com/sijoma/cloud-storage-file-operator/internal/transfer"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/retrievers"
+)
+
+// authorize checks whether the transfer may run and records the outcome on the Authorized condition.
+// Unauthorized transfers wait for a change of their spec, their bucket secret or the StoragePolicies.
+func (r *FileTransferReconciler) authorize(ctx context.Context, fileTransferCR *csfov1alpha1.FileTransfer) (bool, error) {
+	condition := metav1.Condition{
+		Type:               csfov1alpha1.ConditionAuthorized,
+		Status:             metav1.ConditionTrue,
+		Reason:             csfov1alpha1.ReasonAuthorized,
+		ObservedGeneration: fileTransferCR.Generation,
+	}
+
+	err := retrievers.AuthorizeSecret(ctx, r.Client, fileTransferCR)
+	if errors.Is(err, retrievers.ErrNamespaceNotAllowed) {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = csfov1alpha1.ReasonNamespaceNotAllowed
+		condition.Message = err.Error()
+	} else if err != nil {
+		return false, err
+	} else if key := retrievers.BucketSecret(fileTransferCR); key != nil && key.Namespace != fileTransferCR.Namespace &&
+		fileTransferCR.Spec.Executor == csfov1alpha1.ExecutorJob {
+		// Pods can only mount Secrets of their own namespace, only inline copies read shared Secrets
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = csfov1alpha1.ReasonNamespaceNotAllowed
+		condition.Message = "bucketSecret " + key.String() + " can not be mounted into the worker Job of namespace " +
+			fileTransferCR.Namespace + ", it needs the Inline executor"
+	}
+
+	if err := retrievers.AuthorizeImpersonation(fileTransferCR); err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = csfov1alpha1.ReasonImpersonationNotAllowed
+		condition.Message = err.Error()
+	} else if err := transfer.CheckPrefixes(fileTransferCR); err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = csfov1alpha1.ReasonOverlappingPrefixes
+		condition.Message = err.Error()
+	}
+
+	if condition.Status == metav1.ConditionTrue {
+		err := policy.Check(ctx, r.Client, fileTransferCR.Namespace, policy.TransferAccesses(fileTransferCR))
+		if errors.Is(err, policy.ErrDenied) {
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = csfov1alpha1.ReasonPolicyDenied
+			condition.Message = err.Error()
+		} else if err != nil {
+			return false, err
+		}
+	}
+
+	folder, err := retrievers.Folder(ctx, r.Client, fileTransferCR)
+	if err != nil {
+		return false, err
+	}
+	if folder != nil && condition.Status == metav1.ConditionTrue {
+		if err := transfer.CheckFolderScope(fileTransferCR, folder); err != nil {
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = csfov1alpha1.ReasonOutsideFolder
+			condition.Message = err.Error()
+		} else if folder.Status.Email == "" && fileTransferCR.Spec.Executor != csfov1alpha1.ExecutorJob {
+			// Inline copies impersonate the service account, a Direct Folder has none
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = csfov1alpha1.ReasonExecutorUnsupported
+			condition.Message = "folder " + folder.Name + " uses the Direct identity mode, it needs the Job executor"
+		} else if folder.Status.Email == "" && fileTransferCR.Spec.BucketSecret != nil {
+			// A mounted key replaces the workload identity of the worker, there is no service account to impersonate
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = csfov1alpha1.ReasonImpersonationNotAllowed
+			condition.Message = "folder " + folder.Name + " uses the Direct identity mode, it can not be combined with bucketSecret"
+		}
+	}
+
+	authorized := condition.Status == metav1.ConditionTrue
+	if !authorized {
+		log.FromContext(ctx).Info("transfer not authorized", "reason", condition.Reason, "message", condition.Message)
+	}
+	existing := meta.FindStatusCondition(fileTransferCR.Status.Conditions, condition.Type)
+	if existing != nil && existing.Status == condition.Status && existing.Reason == condition.Reason &&
+		existing.Message == condition.Message && existing.ObservedGeneration == condition.ObservedGeneration {
+		return authorized, nil
+	}
+	err = transfer.UpdateStatus(ctx, r.Client, fileTransferCR, func(status *csfov1alpha1.FileTransferStatus) {
+		meta.SetStatusCondition(&status.Conditions, condition)
+	})
+	return authorized, err
+}
+
+// transfersForPolicy requeues the transfers which did not finish yet whenever a StoragePolicy changes
+func (r *FileTransferReconciler) transfersForPolicy(ctx context.Context, _ client.Object) []reconcile.Request {
+	var transfers csfov1alpha1.FileTransferList
+	if err := r.List(ctx, &transfers); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list transfers of storage policy")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, fileTransferCR := range transfers.Items {
+		if fileTransferCR.Status.CopyStatus == csfov1alpha1.CopyStatusDone ||
+			fileTransferCR.Status.CopyStatus == csfov1alpha1.CopyStatusFailed {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&fileTransferCR)})
+	}
+	return requests
+}
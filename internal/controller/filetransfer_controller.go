@@ -18,30 +18,50 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"time"
 
-	"cloud.google.com/go/storage"
-	"google.golang.org/api/option"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/internal/transfer"
 	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/gcs"
 	"github.com/sijoma/cloud-storage-file-operator/pkg/retrievers"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/tracing"
 )
 
 // FileTransferReconciler reconciles a FileTransfer object
 type FileTransferReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
-	gcs    *gcs.StorageClient
+	// Clients caches the storage clients per credential source
+	Clients *gcs.ClientCache
+	// WorkerImage is the image of the Jobs running transfers with the Job executor
+	WorkerImage string
 }
 
 //+kubebuilder:rbac:groups=csfo.sijoma.dev,resources=filetransfers,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=csfo.sijoma.dev,resources=filetransfers/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=csfo.sijoma.dev,resources=filetransfers/finalizers,verbs=update
+//+kubebuilder:rbac:groups=csfo.sijoma.dev,resources=storagepolicies,verbs=get;list;watch
+
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
 
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.17.0/pkg/reconcile
@@ -53,6 +73,12 @@ func (r *FileTransferReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	)
 	logger.Info("reconciling started")
 
+	ctx, span := tracing.Tracer().Start(ctx, "FileTransfer.Reconcile", trace.WithAttributes(
+		attribute.String("namespace", req.Namespace),
+		attribute.String("name", req.Name),
+	))
+	defer span.End()
+
 	// populate this CRD
 	fileTransferCR := new(csfov1alpha1.FileTransfer)
 	if err := r.Get(ctx, req.NamespacedName, fileTransferCR); err != nil {
@@ -60,68 +86,193 @@ func (r *FileTransferReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	var extraOpts []option.ClientOption
-
-	if fileTransferCR.Spec.BucketSecret != nil {
-		namespace := fileTransferCR.Namespace
-		if fileTransferCR.Spec.BucketSecret.Namespace != "" {
-			namespace = fileTransferCR.Spec.BucketSecret.Namespace
-		}
-		credentials, err := retrievers.Credentials(r.Client, ctx, types.NamespacedName{Name: fileTransferCR.Spec.BucketSecret.Name, Namespace: namespace})
+	switch {
+	case fileTransferCR.Status.CopyStatus == csfov1alpha1.CopyStatusDone ||
+		fileTransferCR.Status.CopyStatus == csfov1alpha1.CopyStatusFailed:
+		reset, err := r.retryWithNewCredentials(ctx, fileTransferCR)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
-		extraOpts = append(extraOpts, credentials)
+		if reset {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return r.reconcileTTL(ctx, fileTransferCR)
+	case isFinished(fileTransferCR):
+		// A planned dry run, turning off dryRun runs the copy
+		return r.reconcileTTL(ctx, fileTransferCR)
+	}
+
+	authorized, err := r.authorize(ctx, fileTransferCR)
+	if err != nil || !authorized {
+		return ctrl.Result{}, err
 	}
 
-	// Gcs client takes a context... lets see whether we can put it on the reconciler later
-	gcsClient, err := gcs.NewGcsClient(ctx, extraOpts...)
+	if fileTransferCR.Spec.Executor == csfov1alpha1.ExecutorJob {
+		return r.reconcileJob(ctx, fileTransferCR)
+	}
+
+	if fileTransferCR.Spec.CopyDestination != nil && stopStatus(fileTransferCR) != "" {
+		return r.markStopped(ctx, fileTransferCR)
+	}
+
+	credentials, err := retrievers.ForTransfer(ctx, r.Client, fileTransferCR)
 	if err != nil {
-		logger.Error(err, "failed to create gcs client")
 		return ctrl.Result{}, err
 	}
-	gcsQuery := storage.Query{Prefix: fileTransferCR.Spec.Query.Prefix}
+	if err := r.recordCredentialsVersion(ctx, fileTransferCR, credentials.Version); err != nil {
+		return ctrl.Result{}, err
+	}
 
-	// FindObjects
-	objects, err := gcsClient.FindObjects(ctx, fileTransferCR.Spec.BucketName, gcsQuery)
+	// Clients are shared between reconciles using the same credentials
+	gcsClient, release, err := r.Clients.Get(ctx, credentials.Source, credentials.Version, credentials.Options...)
 	if err != nil {
+		logger.Error(err, "failed to create gcs client")
 		return ctrl.Result{}, err
 	}
+	defer release()
 
-	// Copy files if there is a destination
-	if fileTransferCR.Spec.CopyDestination != nil && fileTransferCR.Status.CopyStatus != "Done" {
-		err := gcsClient.CopyFiles(ctx, fileTransferCR.Spec.BucketName, gcsQuery, fileTransferCR.Spec.CopyDestination.Prefix)
-		if err != nil {
-			logger.Error(err, "failed to copy files")
-			return ctrl.Result{}, err
+	// The copy runs on its own context, so suspending or deleting the transfer stops it
+	copyCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go r.watchStop(copyCtx, cancel, req.NamespacedName)
+
+	err = transfer.Run(copyCtx, r.Client, gcsClient, fileTransferCR)
+	if errors.Is(context.Cause(copyCtx), errStopRequested) {
+		return r.markStopped(ctx, fileTransferCR)
+	}
+	if err != nil {
+		logger.Error(err, "failed to transfer files")
+		// Permanent errors are not requeued, they need a change of the spec or the permissions
+		if transfer.IsPermanent(err) {
+			return ctrl.Result{}, transfer.MarkFailed(ctx, r.Client, fileTransferCR, err)
 		}
-		fileTransferCR.Status.CopyStatus = "Done"
-		logger.Info("successfully copied files")
-		err = r.Status().Update(ctx, fileTransferCR)
+		return ctrl.Result{}, err
+	}
+
+	// Listing-only transfers are finished once the listing is recorded
+	if fileTransferCR.Spec.CopyDestination == nil {
+		return r.reconcileTTL(ctx, fileTransferCR)
+	}
+	return ctrl.Result{}, nil
+}
+
+// reconcileTTL records when a transfer finished and deletes it once its TTL expired
+func (r *FileTransferReconciler) reconcileTTL(ctx context.Context, fileTransferCR *csfov1alpha1.FileTransfer) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	if fileTransferCR.Status.CompletionTime == nil {
+		now := metav1.Now()
+		err := transfer.UpdateStatus(ctx, r.Client, fileTransferCR, func(status *csfov1alpha1.FileTransferStatus) {
+			status.CompletionTime = &now
+		})
 		if err != nil {
 			logger.Error(err, "failed to update status")
-			return ctrl.Result{}, err
+			return ctrl.Result{}, client.IgnoreNotFound(err)
 		}
 	}
 
-	if fileTransferCR.Status.FoundObjects != len(objects) {
-		fileTransferCR.Status.FoundObjects = len(objects)
-		logger.Info("found objects", "objectsFound", len(objects))
-		// This will list all files including folders
-		logger.Info("objects found", "objectsFound", objects)
-		err = r.Status().Update(ctx, fileTransferCR)
-		if err != nil {
-			logger.Error(err, "failed to update status")
-			return ctrl.Result{}, err
+	ttl := fileTransferCR.Spec.TTLSecondsAfterFinished
+	if fileTransferCR.Status.CopyStatus == csfov1alpha1.CopyStatusFailed && fileTransferCR.Spec.TTLSecondsAfterFailure != nil {
+		ttl = fileTransferCR.Spec.TTLSecondsAfterFailure
+	}
+	if ttl == nil {
+		return ctrl.Result{}, nil
+	}
+
+	expiresIn := time.Until(fileTransferCR.Status.CompletionTime.Add(time.Duration(*ttl) * time.Second))
+	if expiresIn > 0 {
+		return ctrl.Result{RequeueAfter: expiresIn}, nil
+	}
+
+	logger.Info("deleting finished transfer, ttl expired", "copyStatus", fileTransferCR.Status.CopyStatus)
+	err := r.Delete(ctx, fileTransferCR, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	return ctrl.Result{}, client.IgnoreNotFound(err)
+}
+
+// errStopRequested cancels a running inline copy
+var errStopRequested = errors.New("transfer suspended, cancelled or deleted")
+
+// stopPollInterval is how often a running inline copy checks whether it should stop
+const stopPollInterval = 5 * time.Second
+
+// stopStatus returns the CopyStatus of a transfer asked to stop, empty if it should run
+func stopStatus(fileTransferCR *csfov1alpha1.FileTransfer) string {
+	if fileTransferCR.Annotations[csfov1alpha1.CancelAnnotation] == "true" {
+		return csfov1alpha1.CopyStatusCancelled
+	}
+	if fileTransferCR.Spec.Suspend {
+		return csfov1alpha1.CopyStatusSuspended
+	}
+	return ""
+}
+
+// watchStop cancels the copy once the transfer is suspended, cancelled or deleted.
+// Reconciles of the same transfer never run concurrently, so the running copy has to look for changes itself.
+func (r *FileTransferReconciler) watchStop(ctx context.Context, cancel context.CancelCauseFunc, key types.NamespacedName) {
+	ticker := time.NewTicker(stopPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fileTransferCR := new(csfov1alpha1.FileTransfer)
+			err := r.Get(ctx, key, fileTransferCR)
+			if apierrors.IsNotFound(err) || (err == nil && stopStatus(fileTransferCR) != "") {
+				cancel(errStopRequested)
+				return
+			}
 		}
 	}
+}
+
+// markStopped records that the transfer was stopped, the progress is recorded by the copy itself
+func (r *FileTransferReconciler) markStopped(ctx context.Context, fileTransferCR *csfov1alpha1.FileTransfer) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
 
+	copyStatus := stopStatus(fileTransferCR)
+	if copyStatus == "" || fileTransferCR.Status.CopyStatus == copyStatus {
+		return ctrl.Result{}, nil
+	}
+	logger.Info("transfer stopped", "copyStatus", copyStatus, "resumeFrom", fileTransferCR.Status.ResumeFrom)
+	err := transfer.UpdateStatus(ctx, r.Client, fileTransferCR, func(status *csfov1alpha1.FileTransferStatus) {
+		// Checked again on the latest version, the stop might have been revoked meanwhile
+		if copyStatus := stopStatus(fileTransferCR); copyStatus != "" {
+			status.CopyStatus = copyStatus
+		}
+	})
+	if err != nil {
+		logger.Error(err, "failed to update status")
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
 	return ctrl.Result{}, nil
 }
 
+// isFinished reports whether the transfer reached a final state for its current spec
+func isFinished(fileTransferCR *csfov1alpha1.FileTransfer) bool {
+	switch fileTransferCR.Status.CopyStatus {
+	case csfov1alpha1.CopyStatusDone, csfov1alpha1.CopyStatusFailed:
+		return true
+	case csfov1alpha1.CopyStatusPlanned:
+		return fileTransferCR.Spec.DryRun
+	}
+	return false
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *FileTransferReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &csfov1alpha1.FileTransfer{},
+		bucketSecretIndex, indexBucketSecret)
+	if err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&csfov1alpha1.FileTransfer{}).
+		Owns(&batchv1.Job{}).
+		// Transfers waiting for their credentials start once the secret appears or changes.
+		// Only the metadata is watched, the data of the secrets is not cached.
+		WatchesMetadata(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.transfersForSecret)).
+		Watches(&csfov1alpha1.StoragePolicy{}, handler.EnqueueRequestsFromMapFunc(r.transfersForPolicy)).
 		Complete(r)
 }
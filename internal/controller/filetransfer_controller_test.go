@@ -18,16 +18,25 @@ package controller
 
 import (
 	"context"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/gcs"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/retrievers"
 )
 
 var _ = Describe("FileTransfer Controller", func() {
@@ -69,8 +78,9 @@ var _ = Describe("FileTransfer Controller", func() {
 		It("should successfully reconcile the resource", func() {
 			By("Reconciling the created resource")
 			controllerReconciler := &FileTransferReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
+				Client:  k8sClient,
+				Scheme:  k8sClient.Scheme(),
+				Clients: gcs.NewClientCache(time.Minute),
 			}
 
 			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
@@ -81,4 +91,281 @@ var _ = Describe("FileTransfer Controller", func() {
 			// Example: If you expect a certain status condition after reconciliation, verify it here.
 		})
 	})
+
+	Context("When reconciling a resource with the Job executor", func() {
+		const resourceName = "test-job-resource"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			By("creating the custom resource for the Kind FileTransfer")
+			resource := &csfov1alpha1.FileTransfer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: csfov1alpha1.FileTransferSpec{
+					BucketName:      "bucket",
+					Query:           csfov1alpha1.Query{Prefix: "src/"},
+					CopyDestination: &csfov1alpha1.CopyDestination{Prefix: "dst/"},
+					Executor:        csfov1alpha1.ExecutorJob,
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			resource := &csfov1alpha1.FileTransfer{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+
+			By("Cleanup the specific resource instance FileTransfer")
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+		})
+
+		It("should launch a worker Job", func() {
+			By("Reconciling the created resource")
+			controllerReconciler := &FileTransferReconciler{
+				Client:      k8sClient,
+				Scheme:      k8sClient.Scheme(),
+				WorkerImage: "controller:latest",
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			job := &batchv1.Job{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-transfer", Namespace: "default"}, job)).To(Succeed())
+			Expect(job.Spec.Template.Spec.Containers[0].Args).To(ContainElements("transfer", resourceName))
+
+			resource := &csfov1alpha1.FileTransfer{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.CopyStatus).To(Equal(csfov1alpha1.CopyStatusRunning))
+			Expect(resource.Status.JobName).To(Equal(job.Name))
+		})
+	})
+
+	Context("When reconciling a suspended resource", func() {
+		const resourceName = "test-suspended-resource"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			By("creating the custom resource for the Kind FileTransfer")
+			resource := &csfov1alpha1.FileTransfer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: csfov1alpha1.FileTransferSpec{
+					BucketName:      "bucket",
+					Query:           csfov1alpha1.Query{Prefix: "src/"},
+					CopyDestination: &csfov1alpha1.CopyDestination{Prefix: "dst/"},
+					Executor:        csfov1alpha1.ExecutorJob,
+					Suspend:         true,
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			resource := &csfov1alpha1.FileTransfer{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+
+			By("Cleanup the specific resource instance FileTransfer")
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+		})
+
+		It("should not launch a worker Job", func() {
+			By("Reconciling the created resource")
+			controllerReconciler := &FileTransferReconciler{
+				Client:      k8sClient,
+				Scheme:      k8sClient.Scheme(),
+				WorkerImage: "controller:latest",
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			job := &batchv1.Job{}
+			err = k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-transfer", Namespace: "default"}, job)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+
+			resource := &csfov1alpha1.FileTransfer{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.CopyStatus).To(Equal(csfov1alpha1.CopyStatusSuspended))
+		})
+	})
+
+	Context("When reconciling a finished resource with a TTL", func() {
+		const resourceName = "test-ttl-resource"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			By("creating a finished FileTransfer")
+			resource := &csfov1alpha1.FileTransfer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: csfov1alpha1.FileTransferSpec{
+					BucketName:              "bucket",
+					TTLSecondsAfterFinished: ptr.To[int32](3600),
+					TTLSecondsAfterFailure:  ptr.To[int32](0),
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			resource := &csfov1alpha1.FileTransfer{}
+			err := k8sClient.Get(ctx, typeNamespacedName, resource)
+			if err == nil {
+				Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+			}
+		})
+
+		It("should keep a successful transfer until its TTL expires", func() {
+			resource := &csfov1alpha1.FileTransfer{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Status.CopyStatus = csfov1alpha1.CopyStatusDone
+			Expect(k8sClient.Status().Update(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &FileTransferReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.CompletionTime).NotTo(BeNil())
+		})
+
+		It("should delete a failed transfer once its TTL expired", func() {
+			resource := &csfov1alpha1.FileTransfer{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Status.CopyStatus = csfov1alpha1.CopyStatusFailed
+			Expect(k8sClient.Status().Update(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &FileTransferReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = k8sClient.Get(ctx, typeNamespacedName, resource)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("should keep a planned dry run until its TTL expires", func() {
+			resource := &csfov1alpha1.FileTransfer{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.DryRun = true
+			resource.Spec.CopyDestination = &csfov1alpha1.CopyDestination{Prefix: "copy/"}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			resource.Status.CopyStatus = csfov1alpha1.CopyStatusPlanned
+			Expect(k8sClient.Status().Update(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &FileTransferReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.CompletionTime).NotTo(BeNil())
+		})
+	})
+})
+
+var _ = Describe("FileTransfer worker Job", func() {
+	jobWith := func(conditionType batchv1.JobConditionType) *batchv1.Job {
+		job := &batchv1.Job{}
+		if conditionType != "" {
+			job.Status.Conditions = []batchv1.JobCondition{{
+				Type: conditionType, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded", Message: "too many retries",
+			}}
+		}
+		return job
+	}
+
+	It("should mirror a running, completed or failed Job", func() {
+		Expect(jobCopyStatus("", jobWith(""), false)).To(Equal(csfov1alpha1.CopyStatusRunning))
+		Expect(jobCopyStatus(csfov1alpha1.CopyStatusSuspended, jobWith(""), false)).To(Equal(csfov1alpha1.CopyStatusRunning))
+		Expect(jobCopyStatus(csfov1alpha1.CopyStatusRunning, jobWith(batchv1.JobComplete), false)).
+			To(Equal(csfov1alpha1.CopyStatusDone))
+		Expect(jobCopyStatus("", jobWith(batchv1.JobComplete), true)).To(Equal(csfov1alpha1.CopyStatusPlanned))
+		Expect(jobCopyStatus(csfov1alpha1.CopyStatusRunning, jobWith(batchv1.JobFailed), false)).
+			To(Equal(csfov1alpha1.CopyStatusFailed))
+		Expect(jobConditionMessage(jobWith(batchv1.JobFailed), batchv1.JobFailed)).
+			To(Equal("BackoffLimitExceeded: too many retries"))
+	})
+
+	It("should keep the outcome recorded by the worker", func() {
+		Expect(jobCopyStatus(csfov1alpha1.CopyStatusFailed, jobWith(batchv1.JobComplete), false)).
+			To(Equal(csfov1alpha1.CopyStatusFailed))
+		Expect(jobCopyStatus(csfov1alpha1.CopyStatusFailed, jobWith(batchv1.JobComplete), true)).
+			To(Equal(csfov1alpha1.CopyStatusFailed))
+	})
+
+	It("should not authorize a Job mounting a bucket secret of another namespace", func() {
+		ctx := context.Background()
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name: "bucket-credentials", Namespace: "shared",
+			Annotations: map[string]string{retrievers.AllowedNamespacesAnnotation: "team-a"},
+		}}
+		fileTransferCR := &csfov1alpha1.FileTransfer{
+			ObjectMeta: metav1.ObjectMeta{Name: "copy", Namespace: "team-a"},
+			Spec: csfov1alpha1.FileTransferSpec{
+				BucketName:   "bucket",
+				BucketSecret: &corev1.SecretReference{Name: "bucket-credentials", Namespace: "shared"},
+				Executor:     csfov1alpha1.ExecutorJob,
+			},
+		}
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme.Scheme).
+			WithStatusSubresource(&csfov1alpha1.FileTransfer{}).WithObjects(secret, fileTransferCR).Build()
+		controllerReconciler := &FileTransferReconciler{Client: fakeClient, Scheme: scheme.Scheme}
+
+		authorized, err := controllerReconciler.authorize(ctx, fileTransferCR)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(authorized).To(BeFalse())
+		condition := meta.FindStatusCondition(fileTransferCR.Status.Conditions, csfov1alpha1.ConditionAuthorized)
+		Expect(condition.Reason).To(Equal(csfov1alpha1.ReasonNamespaceNotAllowed))
+
+		// Inline copies read the shared Secret themselves
+		fileTransferCR.Spec.Executor = csfov1alpha1.ExecutorInline
+		authorized, err = controllerReconciler.authorize(ctx, fileTransferCR)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(authorized).To(BeTrue())
+	})
 })
@@ -0,0 +1,196 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/internal/transfer"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/resources"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/retrievers"
+)
+
+// reconcileJob launches the worker Job for the FileTransfer and mirrors its outcome on the status.
+// Progress is reported by the worker itself.
+func (r *FileTransferReconciler) reconcileJob(ctx context.Context, fileTransferCR *csfov1alpha1.FileTransfer) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	if isFinished(fileTransferCR) {
+		return ctrl.Result{}, nil
+	}
+
+	if copyStatus := stopStatus(fileTransferCR); copyStatus != "" {
+		return r.suspendJob(ctx, fileTransferCR, copyStatus)
+	}
+
+	if r.WorkerImage == "" {
+		return ctrl.Result{}, errors.New("executor Job requires the worker image to be configured")
+	}
+
+	// Workers of a Folder run as its workload identity ServiceAccount
+	folder, err := retrievers.Folder(ctx, r.Client, fileTransferCR)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	serviceAccount := resources.TransferServiceAccount(fileTransferCR, folder)
+
+	if manifest := fileTransferCR.Spec.Manifest; manifest != nil && manifest.ConfigMap != "" {
+		err := resources.ReserveManifestConfigMap(ctx, r.Client, fileTransferCR, manifest.ConfigMap)
+		if errors.Is(err, resources.ErrNotOwned) {
+			// Another ConfigMap of the same name is never overwritten, the spec has to change
+			return ctrl.Result{}, transfer.MarkFailed(ctx, r.Client, fileTransferCR, err)
+		}
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	err = resources.TransferWorkerRBAC(ctx, r.Client, fileTransferCR, serviceAccount)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	version, err := r.secretVersion(ctx, fileTransferCR)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.recordCredentialsVersion(ctx, fileTransferCR, version); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	job, err := resources.TransferJob(ctx, r.Client, fileTransferCR, folder, r.WorkerImage, serviceAccount)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// The Job of a previous attempt is still being deleted
+	if job.DeletionTimestamp != nil {
+		return ctrl.Result{RequeueAfter: time.Second}, nil
+	}
+
+	if ptr.Deref(job.Spec.Suspend, false) {
+		logger.Info("resuming job", "job", job.Name)
+		job.Spec.Suspend = ptr.To(false)
+		if err := r.Update(ctx, job); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// The Job of a dry run is replaced once the dry run is turned off
+	if fileTransferCR.Status.CopyStatus == csfov1alpha1.CopyStatusPlanned && jobHasCondition(job, batchv1.JobComplete) {
+		logger.Info("replacing job of dry run", "job", job.Name)
+		err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		return ctrl.Result{Requeue: true}, client.IgnoreNotFound(err)
+	}
+
+	copyStatus := jobCopyStatus(fileTransferCR.Status.CopyStatus, job, fileTransferCR.Spec.DryRun)
+	if fileTransferCR.Status.JobName == job.Name && fileTransferCR.Status.CopyStatus == copyStatus {
+		return ctrl.Result{}, nil
+	}
+	logger.Info("transfer job progressed", "job", job.Name, "copyStatus", copyStatus)
+	err = transfer.UpdateStatus(ctx, r.Client, fileTransferCR, func(status *csfov1alpha1.FileTransferStatus) {
+		status.JobName = job.Name
+		// Applied on the latest status, the worker might have recorded the outcome meanwhile
+		copyStatus := jobCopyStatus(status.CopyStatus, job, fileTransferCR.Spec.DryRun)
+		if copyStatus == csfov1alpha1.CopyStatusFailed && status.CopyStatus != csfov1alpha1.CopyStatusFailed {
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type:    csfov1alpha1.ConditionFailed,
+				Status:  metav1.ConditionTrue,
+				Reason:  csfov1alpha1.ReasonJobFailed,
+				Message: jobConditionMessage(job, batchv1.JobFailed),
+			})
+		}
+		status.CopyStatus = copyStatus
+	})
+	if err != nil {
+		logger.Error(err, "failed to update status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// suspendJob suspends a running worker Job. Its pod is terminated and the worker records how far it got,
+// unsuspending the Job starts a new worker resuming from there.
+func (r *FileTransferReconciler) suspendJob(ctx context.Context, fileTransferCR *csfov1alpha1.FileTransfer, copyStatus string) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	job := new(batchv1.Job)
+	err := r.Get(ctx, types.NamespacedName{Name: resources.TransferJobName(fileTransferCR), Namespace: fileTransferCR.Namespace}, job)
+	switch {
+	case apierrors.IsNotFound(err):
+		// Nothing started yet
+	case err != nil:
+		return ctrl.Result{}, err
+	case !ptr.Deref(job.Spec.Suspend, false):
+		logger.Info("suspending job", "job", job.Name, "copyStatus", copyStatus)
+		job.Spec.Suspend = ptr.To(true)
+		if err := r.Update(ctx, job); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	return r.markStopped(ctx, fileTransferCR)
+}
+
+// jobCopyStatus is the copy status mirroring the Job on top of the current one. The worker records the outcome of the
+// transfer itself, e.g. it marks a transfer Failed for good and exits successfully: a completed Job only moves a new
+// or Running transfer to Done, or Planned for a dry run.
+func jobCopyStatus(current string, job *batchv1.Job, dryRun bool) string {
+	switch {
+	case jobHasCondition(job, batchv1.JobFailed):
+		return csfov1alpha1.CopyStatusFailed
+	case !jobHasCondition(job, batchv1.JobComplete):
+		return csfov1alpha1.CopyStatusRunning
+	case current != "" && current != csfov1alpha1.CopyStatusRunning:
+		return current
+	case dryRun:
+		return csfov1alpha1.CopyStatusPlanned
+	}
+	return csfov1alpha1.CopyStatusDone
+}
+
+// jobConditionMessage returns the message of the true condition of the Job, e.g. why it failed
+func jobConditionMessage(job *batchv1.Job, conditionType batchv1.JobConditionType) string {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == conditionType && condition.Status == corev1.ConditionTrue {
+			return condition.Reason + ": " + condition.Message
+		}
+	}
+	return ""
+}
+
+func jobHasCondition(job *batchv1.Job, conditionType batchv1.JobConditionType) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == conditionType && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/internal/transfer"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/retry"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/resources"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/retrievers"
+)
+
+// bucketSecretIndex indexes FileTransfers by the namespace/name of their bucket secret
+const bucketSecretIndex = ".spec.bucketSecret"
+
+func indexBucketSecret(obj client.Object) []string {
+	key := retrievers.BucketSecret(obj.(*csfov1alpha1.FileTransfer))
+	if key == nil {
+		return nil
+	}
+	return []string{key.String()}
+}
+
+// transfersForSecret requeues the transfers referencing the secret, except the ones already done
+func (r *FileTransferReconciler) transfersForSecret(ctx context.Context, secret client.Object) []reconcile.Request {
+	var transfers csfov1alpha1.FileTransferList
+	err := r.List(ctx, &transfers, client.MatchingFields{bucketSecretIndex: client.ObjectKeyFromObject(secret).String()})
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to list transfers of secret", "secret", secret.GetName())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, fileTransferCR := range transfers.Items {
+		if fileTransferCR.Status.CopyStatus == csfov1alpha1.CopyStatusDone {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&fileTransferCR)})
+	}
+	return requests
+}
+
+// secretVersion identifies the content of the referenced secret, empty if there is none (yet)
+func (r *FileTransferReconciler) secretVersion(ctx context.Context, fileTransferCR *csfov1alpha1.FileTransfer) (string, error) {
+	key := retrievers.BucketSecret(fileTransferCR)
+	if key == nil {
+		return "", nil
+	}
+	// Secrets of the users are not cached, this reads from the API server
+	var secret corev1.Secret
+	err := r.Get(ctx, *key, &secret)
+	if err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+	return string(secret.UID) + "/" + secret.ResourceVersion, nil
+}
+
+// recordCredentialsVersion remembers the version of the secret the transfer runs with
+func (r *FileTransferReconciler) recordCredentialsVersion(ctx context.Context, fileTransferCR *csfov1alpha1.FileTransfer, version string) error {
+	if fileTransferCR.Status.CredentialsVersion == version {
+		return nil
+	}
+	return transfer.UpdateStatus(ctx, r.Client, fileTransferCR, func(status *csfov1alpha1.FileTransferStatus) {
+		status.CredentialsVersion = version
+	})
+}
+
+// retryWithNewCredentials resets a transfer that failed for missing permissions once its secret changed.
+// Returns whether the transfer was reset.
+func (r *FileTransferReconciler) retryWithNewCredentials(ctx context.Context, fileTransferCR *csfov1alpha1.FileTransfer) (bool, error) {
+	condition := meta.FindStatusCondition(fileTransferCR.Status.Conditions, csfov1alpha1.ConditionFailed)
+	if fileTransferCR.Status.CopyStatus != csfov1alpha1.CopyStatusFailed || condition == nil ||
+		condition.Reason != retry.ReasonPermissionDenied {
+		return false, nil
+	}
+	version, err := r.secretVersion(ctx, fileTransferCR)
+	if err != nil || version == "" || version == fileTransferCR.Status.CredentialsVersion {
+		return false, err
+	}
+
+	log.FromContext(ctx).Info("credentials changed, retrying failed transfer")
+	if fileTransferCR.Spec.Executor == csfov1alpha1.ExecutorJob {
+		job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+			Name:      resources.TransferJobName(fileTransferCR),
+			Namespace: fileTransferCR.Namespace,
+		}}
+		err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !apierrors.IsNotFound(err) {
+			return false, err
+		}
+	}
+	err = transfer.UpdateStatus(ctx, r.Client, fileTransferCR, func(status *csfov1alpha1.FileTransferStatus) {
+		status.CopyStatus = ""
+		status.CompletionTime = nil
+		status.JobName = ""
+		meta.RemoveStatusCondition(&status.Conditions, csfov1alpha1.ConditionFailed)
+	})
+	return err == nil, err
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/retry"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/resources"
+)
+
+var _ = Describe("FileTransfer secrets", func() {
+	ctx := context.Background()
+
+	// The bucket secret index is not available with the API server, these specs use a fake client
+	var fakeClient client.Client
+	var controllerReconciler *FileTransferReconciler
+
+	transfer := func(name, secret string, copyStatus string) *csfov1alpha1.FileTransfer {
+		return &csfov1alpha1.FileTransfer{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a"},
+			Spec: csfov1alpha1.FileTransferSpec{
+				BucketName:   "bucket",
+				BucketSecret: &corev1.SecretReference{Name: secret},
+			},
+			Status: csfov1alpha1.FileTransferStatus{CopyStatus: copyStatus},
+		}
+	}
+
+	BeforeEach(func() {
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme.Scheme).
+			WithStatusSubresource(&csfov1alpha1.FileTransfer{}).
+			WithIndex(&csfov1alpha1.FileTransfer{}, bucketSecretIndex, indexBucketSecret).
+			WithObjects(
+				&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "team-a", UID: "creds-uid"}},
+				transfer("pending", "creds", ""),
+				transfer("done", "creds", csfov1alpha1.CopyStatusDone),
+				transfer("other", "other-creds", ""),
+			).Build()
+		controllerReconciler = &FileTransferReconciler{Client: fakeClient, Scheme: scheme.Scheme}
+	})
+
+	It("should index transfers by the namespace and name of their secret", func() {
+		Expect(indexBucketSecret(transfer("copy", "creds", ""))).To(ConsistOf("team-a/creds"))
+
+		shared := transfer("copy", "creds", "")
+		shared.Spec.BucketSecret.Namespace = "shared"
+		Expect(indexBucketSecret(shared)).To(ConsistOf("shared/creds"))
+
+		withoutSecret := transfer("copy", "", "")
+		withoutSecret.Spec.BucketSecret = nil
+		Expect(indexBucketSecret(withoutSecret)).To(BeEmpty())
+	})
+
+	It("should requeue the unfinished transfers of a secret", func() {
+		// The secrets are only watched by their metadata
+		secret := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "team-a"}}
+		Expect(controllerReconciler.transfersForSecret(ctx, secret)).To(ConsistOf(
+			reconcile.Request{NamespacedName: client.ObjectKey{Name: "pending", Namespace: "team-a"}},
+		))
+	})
+
+	It("should retry a transfer denied permissions once its secret changed", func() {
+		denied := transfer("denied", "creds", csfov1alpha1.CopyStatusFailed)
+		denied.Spec.Executor = csfov1alpha1.ExecutorJob
+		Expect(fakeClient.Create(ctx, denied)).To(Succeed())
+		job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: resources.TransferJobName(denied), Namespace: "team-a"}}
+		Expect(fakeClient.Create(ctx, job)).To(Succeed())
+		denied.Status = csfov1alpha1.FileTransferStatus{
+			CopyStatus:         csfov1alpha1.CopyStatusFailed,
+			CredentialsVersion: "creds-uid/previous",
+			JobName:            job.Name,
+			Conditions: []metav1.Condition{{
+				Type:               csfov1alpha1.ConditionFailed,
+				Status:             metav1.ConditionTrue,
+				Reason:             retry.ReasonPermissionDenied,
+				LastTransitionTime: metav1.Now(),
+			}},
+		}
+		Expect(fakeClient.Status().Update(ctx, denied)).To(Succeed())
+
+		reset, err := controllerReconciler.retryWithNewCredentials(ctx, denied)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reset).To(BeTrue())
+		Expect(denied.Status.CopyStatus).To(BeEmpty())
+		Expect(denied.Status.JobName).To(BeEmpty())
+		Expect(meta.FindStatusCondition(denied.Status.Conditions, csfov1alpha1.ConditionFailed)).To(BeNil())
+		err = fakeClient.Get(ctx, client.ObjectKeyFromObject(job), job)
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should not retry a transfer with unchanged credentials or another failure", func() {
+		version, err := controllerReconciler.secretVersion(ctx, transfer("copy", "creds", ""))
+		Expect(err).NotTo(HaveOccurred())
+
+		failed := transfer("failed", "creds", csfov1alpha1.CopyStatusFailed)
+		failed.Status.CredentialsVersion = version
+		failed.Status.Conditions = []metav1.Condition{{
+			Type:   csfov1alpha1.ConditionFailed,
+			Status: metav1.ConditionTrue,
+			Reason: retry.ReasonPermissionDenied,
+		}}
+		reset, err := controllerReconciler.retryWithNewCredentials(ctx, failed)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reset).To(BeFalse())
+
+		failed.Status.CredentialsVersion = "creds-uid/previous"
+		failed.Status.Conditions[0].Reason = csfov1alpha1.ReasonObjectsFailed
+		reset, err = controllerReconciler.retryWithNewCredentials(ctx, failed)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reset).To(BeFalse())
+	})
+})
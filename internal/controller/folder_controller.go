@@ -18,17 +18,40 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/iam/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/internal/policy"
 	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/gcs"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/retry"
 	"github.com/sijoma/cloud-storage-file-operator/pkg/resources"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/tracing"
 )
 
 // FolderReconciler reconciles a Folder object
@@ -36,13 +59,35 @@ type FolderReconciler struct {
 	client.Client
 	Scheme    *runtime.Scheme
 	gcpClient *gcp.Client
+	// OperatorServiceAccount is the email of the operator's service account. It may impersonate
+	// the service accounts of Folders to run FileTransfers and SignedURLs of the Folder's namespace referencing them.
+	OperatorServiceAccount string
+	// ProjectNumber of the GCP project, needed for the Direct identity mode. Looked up if empty.
+	ProjectNumber string
+	// WorkloadIdentityProvider is the default provider of the credential configurations of Folders
+	WorkloadIdentityProvider string
+	// Clients list the managed folders to refresh their usage
+	Clients *gcs.ClientCache
+	// UsageInterval is how often the usage of the Folders is refreshed, never if zero
+	UsageInterval time.Duration
+	// Recorder emits the Events of crossed quotas
+	Recorder record.EventRecorder
+
+	usage usageListings
+
+	projectNumberMu sync.Mutex
 }
 
 //+kubebuilder:rbac:groups=csfo.sijoma.dev,resources=folders,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=csfo.sijoma.dev,resources=folders/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=csfo.sijoma.dev,resources=folders/finalizers,verbs=update
+//+kubebuilder:rbac:groups=csfo.sijoma.dev,resources=storagepolicies,verbs=get;list;watch
 
 //+kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.17.0/pkg/reconcile
@@ -54,64 +99,445 @@ func (r *FolderReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 	)
 	logger.Info("reconciling started")
 
+	ctx, span := tracing.Tracer().Start(ctx, "Folder.Reconcile", trace.WithAttributes(
+		attribute.String("namespace", req.Namespace),
+		attribute.String("name", req.Name),
+	))
+	defer span.End()
+
 	// populate this CRD
 	folderCR := new(csfov1alpha1.Folder)
 	if err := r.Get(ctx, req.NamespacedName, folderCR); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.forgetUsage(req.NamespacedName)
+		}
 		// do not requeue "not found" errors
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	folder, err := r.gcpClient.CreateManagedFolder(
+	if !folderCR.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(folderCR, folderFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if err := r.finalize(ctx, folderCR); err != nil {
+			return r.reconcileError(ctx, folderCR, err)
+		}
+		controllerutil.RemoveFinalizer(folderCR, folderFinalizer)
+		return ctrl.Result{}, client.IgnoreNotFound(r.Update(ctx, folderCR))
+	}
+	if controllerutil.AddFinalizer(folderCR, folderFinalizer) {
+		if err := r.Update(ctx, folderCR); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	err := policy.Check(ctx, r.Client, folderCR.Namespace, policy.FolderAccesses(folderCR))
+	if errors.Is(err, policy.ErrDenied) {
+		logger.Info("folder denied by storage policies", "reason", err.Error())
+		if err := r.revokeRole(ctx, folderCR); err != nil {
+			return r.reconcileError(ctx, folderCR, err)
+		}
+		return ctrl.Result{}, r.setReady(ctx, folderCR, metav1.ConditionFalse, csfov1alpha1.ReasonPolicyDenied, err.Error())
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Resources which were created or adopted before are kept, whatever the adoption policy
+	if folderCR.Status.Ownership == nil {
+		folderCR.Status.Ownership = &csfov1alpha1.FolderOwnership{}
+	}
+	ownership := folderCR.Status.Ownership
+	if movedFolder(folderCR) {
+		if err := r.releaseFolder(ctx, folderCR); err != nil {
+			return r.reconcileError(ctx, folderCR, err)
+		}
+		if err := r.Status().Update(ctx, folderCR); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	adopt := folderCR.Spec.AdoptionPolicy == csfov1alpha1.AdoptionPolicyAdopt
+
+	folder, created, err := r.gcpClient.CreateManagedFolder(
 		ctx,
 		folderCR.Spec.Name,
 		folderCR.Spec.BucketName,
+		adopt || ownership.ManagedFolder != "",
 	)
+	if errors.Is(err, gcp.ErrNotOwned) {
+		return ctrl.Result{}, r.setReady(ctx, folderCR, metav1.ConditionFalse, csfov1alpha1.ReasonAdoptionRefused, err.Error())
+	}
 	if err != nil {
-		return ctrl.Result{}, err
+		return r.reconcileError(ctx, folderCR, err)
+	}
+	logger.Info("folder created/found", "name", folder, "created", created)
+	if created {
+		ownership.ManagedFolder = csfov1alpha1.OwnershipCreated
+		folderCR.Status.Folder, folderCR.Status.Bucket = folder, folderCR.Spec.BucketName
+		// Only the status knows the folder was created by the operator, the managed folder can not be labeled
+		if err := r.Status().Update(ctx, folderCR); err != nil {
+			return ctrl.Result{}, err
+		}
+	} else if ownership.ManagedFolder == "" {
+		ownership.ManagedFolder = csfov1alpha1.OwnershipAdopted
 	}
-	logger.Info("folder created/found", "name", folder)
 
 	kubernetesSAName := folderCR.Name + "-owner"
 	kubernetesNamespace := folderCR.Namespace
 	gcpSAName := folderCR.Name + "-" + folderCR.Namespace
 
-	// Create Service Account with workload identity
-	account, err := r.gcpClient.CreateServiceAccount(ctx, gcpSAName,
-		kubernetesSAName, kubernetesNamespace)
+	// Workloads outside of GKE federate the tokens of the Kubernetes SA through the provider
+	var provider string
+	if folderCR.Spec.CredentialConfig != nil {
+		provider = folderCR.Spec.CredentialConfig.WorkloadIdentityProvider
+		if provider == "" {
+			provider = r.WorkloadIdentityProvider
+		}
+		if provider == "" {
+			return ctrl.Result{}, r.setReady(ctx, folderCR, metav1.ConditionFalse, csfov1alpha1.ReasonInvalidCredentialConfig,
+				"credentialConfig needs a workloadIdentityProvider, neither the Folder nor the operator configure one")
+		}
+	}
+
+	if folderCR.Spec.HMACKey != nil && folderCR.Spec.IdentityMode == csfov1alpha1.IdentityModeDirect {
+		return ctrl.Result{}, r.setReady(ctx, folderCR, metav1.ConditionFalse, csfov1alpha1.ReasonHMACKeyUnsupported,
+			"hmacKey needs a service account, it is not supported with the Direct identity mode")
+	}
+
+	var account *iam.ServiceAccount
+	var principal string
+	switch {
+	case folderCR.Spec.IdentityMode == csfov1alpha1.IdentityModeDirect && provider != "":
+		principal = gcp.ExternalPrincipal(provider, kubernetesNamespace, kubernetesSAName)
+	case folderCR.Spec.IdentityMode == csfov1alpha1.IdentityModeDirect:
+		// The role is granted to the Kubernetes ServiceAccount itself, no service account needed
+		projectNumber, err := r.projectNumber(ctx)
+		if err != nil {
+			return r.reconcileError(ctx, folderCR, err)
+		}
+		principal = r.gcpClient.WorkloadIdentityPrincipal(projectNumber, kubernetesNamespace, kubernetesSAName)
+	default:
+		// Create Service Account with workload identity
+		var owned bool
+		account, owned, err = r.gcpClient.CreateServiceAccount(ctx, gcpSAName,
+			kubernetesSAName, kubernetesNamespace, folderOwner(folderCR),
+			adopt || ownership.ServiceAccount != "")
+		if errors.Is(err, gcp.ErrNotOwned) {
+			return ctrl.Result{}, r.setReady(ctx, folderCR, metav1.ConditionFalse, csfov1alpha1.ReasonAdoptionRefused, err.Error())
+		}
+		if err != nil {
+			return r.reconcileError(ctx, folderCR, err)
+		}
+		logger.Info("created service account", "name", account.Name, "owned", owned)
+		ownership.ServiceAccount = csfov1alpha1.OwnershipAdopted
+		if owned {
+			ownership.ServiceAccount = csfov1alpha1.OwnershipCreated
+		}
+
+		if r.OperatorServiceAccount != "" {
+			err = r.gcpClient.AllowImpersonation(ctx, account, "serviceAccount:"+r.OperatorServiceAccount)
+			if err != nil {
+				return r.reconcileError(ctx, folderCR, err)
+			}
+		}
+		if provider != "" {
+			err = r.gcpClient.AllowWorkloadIdentity(ctx, account, gcp.ExternalPrincipal(provider, kubernetesNamespace, kubernetesSAName))
+			if err != nil {
+				return r.reconcileError(ctx, folderCR, err)
+			}
+		}
+		principal = fmt.Sprintf("serviceAccount:%s", account.Email)
+	}
+
+	role := folderCR.Spec.Role
+	if role == "" {
+		role = csfov1alpha1.DefaultFolderRole
+	}
+	// A read-only Folder exceeding its quota keeps read access only, the role is granted again once within the quota.
+	// The FolderAccesses of its consumers follow status.readOnly.
+	r.collectUsage(ctx, folderCR)
+	readOnly := r.recordQuota(folderCR) && folderCR.Spec.Quota.ReadOnly
+	if readOnly && !folderCR.Status.ReadOnly {
+		logger.Info("quota exceeded, making folder read-only")
+	}
+	folderCR.Status.ReadOnly = readOnly
+	role = quotaRole(role, readOnly)
+	if staleRole(folderCR, role, principal) {
+		if err := r.revokeRole(ctx, folderCR); err != nil {
+			return r.reconcileError(ctx, folderCR, err)
+		}
+	}
+	var expiry time.Time
+	if folderCR.Spec.ExpiresAt != nil {
+		expiry = folderCR.Spec.ExpiresAt.Time
+	}
+	expired := !expiry.IsZero() && !time.Now().Before(expiry)
+	if expired {
+		err = r.gcpClient.RevokeRoleOnFolder(ctx, folder, folderCR.Spec.BucketName, role, principal)
+	} else {
+		err = r.gcpClient.GrantRoleOnFolder(ctx, folder, folderCR.Spec.BucketName, role, principal, expiry)
+	}
 	if err != nil {
-		return ctrl.Result{}, err
+		return r.reconcileError(ctx, folderCR, err)
+	}
+	folderCR.Status.Principal, folderCR.Status.Role = principal, role
+	if expired {
+		folderCR.Status.Principal, folderCR.Status.Role = "", ""
+	}
+
+	// A Folder switched to the Direct identity mode no longer needs its service account, its role was revoked above
+	if account == nil {
+		if err := r.deleteServiceAccount(ctx, folderCR); err != nil {
+			return r.reconcileError(ctx, folderCR, err)
+		}
 	}
-	logger.Info("created service account", "name", account.Name)
 
-	principal := fmt.Sprintf("serviceAccount:%s", account.Email)
-	const role = "roles/storage.folderAdmin"
-	err = r.gcpClient.GrantRoleOnFolder(ctx, folder, folderCR.Spec.BucketName, role, principal)
+	// Expired bindings of the Folder and its FolderAccesses are cleaned up on every resync
+	removed, err := r.gcpClient.RemoveExpiredRolesOnFolder(ctx, folder, folderCR.Spec.BucketName)
+	if err != nil {
+		return r.reconcileError(ctx, folderCR, err)
+	}
+	if removed > 0 {
+		logger.Info("removed expired bindings", "folder", folder, "bindings", removed)
+	}
+
+	var k8sSA *corev1.ServiceAccount
+	if account != nil {
+		k8sSA, err = resources.ServiceAccountWIFEnabled(ctx, r.Client,
+			folderCR, kubernetesSAName, kubernetesNamespace, gcpSAName, account.ProjectId)
+	} else {
+		k8sSA, err = resources.ServiceAccountDirect(ctx, r.Client, folderCR, kubernetesSAName, kubernetesNamespace)
+	}
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	k8sSA, err := resources.ServiceAccountWIFEnabled(ctx, r.Client,
-		folderCR, kubernetesSAName, kubernetesNamespace, gcpSAName, account.ProjectId)
+	err = r.reconcileCredentialConfig(ctx, folderCR, provider, account)
+	if errors.Is(err, resources.ErrNotOwned) {
+		return ctrl.Result{}, r.setReady(ctx, folderCR, metav1.ConditionFalse, csfov1alpha1.ReasonNotOwned, err.Error())
+	}
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
+	hmacRequeue, err := r.reconcileHMACKey(ctx, folderCR, account)
+	if errors.Is(err, resources.ErrNotOwned) {
+		return ctrl.Result{}, r.setReady(ctx, folderCR, metav1.ConditionFalse, csfov1alpha1.ReasonNotOwned, err.Error())
+	}
+	if err != nil {
+		return r.reconcileError(ctx, folderCR, err)
+	}
+
+	// Listed in the background once everything is provisioned, a failed listing never fails the Folder
+	usageRequeue := r.refreshUsage(ctx, folderCR)
+
 	// We now have:
-	// - IAM Service account
+	// - IAM Service account (not with the Direct identity mode)
 	// - IAM workload identity user
-	// - Binding of Service account to ManagedFolder with "roles/storage.folderAdmin"
+	// - Binding of Service account (or the Kubernetes SA principal) to ManagedFolder with the role of the Folder
+	//   (roles/storage.folderAdmin by default)
 	// - Kubernetes SA with annotation
+	// - ConfigMap with the credential configuration, if requested
+	// - Secret with the HMAC key, if requested
 
 	folderCR.Status.ServiceAccountName = k8sSA.Name
-	folderCR.Status.Email = account.Email
-	folderCR.Status.Folder = folder
+	folderCR.Status.Email = ""
+	if account != nil {
+		folderCR.Status.Email = account.Email
+	}
+	folderCR.Status.Folder, folderCR.Status.Bucket = folder, folderCR.Spec.BucketName
+	ready := metav1.Condition{
+		Type:   csfov1alpha1.ConditionReady,
+		Status: metav1.ConditionTrue,
+		Reason: csfov1alpha1.ReasonReconciled,
+	}
+	if expired {
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = csfov1alpha1.ReasonExpired
+		ready.Message = fmt.Sprintf("role %s expired at %s", role, expiry.UTC().Format(time.RFC3339))
+	}
+	meta.SetStatusCondition(&folderCR.Status.Conditions, ready)
 	err = r.Status().Update(ctx, folderCR)
 	if err != nil {
 		logger.Error(err, "failed to update folder status")
 		return ctrl.Result{}, err
 	}
 
-	return ctrl.Result{}, nil
+	var requeueAfter time.Duration
+	if !expiry.IsZero() && !expired {
+		// Reconcile again to clean up the binding once it expired
+		requeueAfter = time.Until(expiry)
+	}
+	return ctrl.Result{RequeueAfter: earliest(earliest(requeueAfter, hmacRequeue), usageRequeue)}, nil
+}
+
+// reconcileCredentialConfig creates the ConfigMap with the credential configuration of the Folder. An existing
+// ConfigMap of another owner is never overwritten. The previous ConfigMap is deleted once the Folder renames it
+// or no longer requests it, unless the Folder does not own it.
+func (r *FolderReconciler) reconcileCredentialConfig(ctx context.Context, folderCR *csfov1alpha1.Folder,
+	provider string, account *iam.ServiceAccount,
+) error {
+	config := folderCR.Spec.CredentialConfig
+	if config == nil {
+		return r.deleteCredentialConfig(ctx, folderCR)
+	}
+
+	var email string
+	if account != nil {
+		email = account.Email
+	}
+	data, err := gcp.ExternalAccountConfig(provider, config.TokenPath, email)
+	if err != nil {
+		return err
+	}
+	name := config.ConfigMapName
+	if name == "" {
+		name = folderCR.Name + "-credentials"
+	}
+	if _, err := resources.CredentialConfigMap(ctx, r.Client, folderCR, name, folderCR.Namespace, data); err != nil {
+		return err
+	}
+	if folderCR.Status.CredentialConfigMap != name {
+		if err := r.deleteCredentialConfig(ctx, folderCR); err != nil {
+			return err
+		}
+	}
+	folderCR.Status.CredentialConfigMap = name
+	return nil
+}
+
+// deleteCredentialConfig deletes the ConfigMap of status.credentialConfigMap if the Folder owns it
+func (r *FolderReconciler) deleteCredentialConfig(ctx context.Context, folderCR *csfov1alpha1.Folder) error {
+	if folderCR.Status.CredentialConfigMap == "" {
+		return nil
+	}
+	key := types.NamespacedName{Name: folderCR.Status.CredentialConfigMap, Namespace: folderCR.Namespace}
+	if err := resources.DeleteOwned(ctx, r.Client, key, &corev1.ConfigMap{}, folderCR); err != nil {
+		return err
+	}
+	folderCR.Status.CredentialConfigMap = ""
+	return nil
+}
+
+// earliest returns the shorter of two positive durations, zero durations are ignored
+func earliest(a, b time.Duration) time.Duration {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// projectNumber returns the configured project number, looking it up once if not set
+func (r *FolderReconciler) projectNumber(ctx context.Context) (string, error) {
+	r.projectNumberMu.Lock()
+	defer r.projectNumberMu.Unlock()
+	if r.ProjectNumber == "" {
+		number, err := r.gcpClient.LookupProjectNumber(ctx)
+		if err != nil {
+			return "", err
+		}
+		r.ProjectNumber = number
+	}
+	return r.ProjectNumber, nil
+}
+
+// permanentErrorRetryInterval is how long Folders and FolderAccesses wait after a permanent error. They are retried
+// nevertheless, as IAM is eventually consistent: a service account created moments ago may not be found yet.
+const permanentErrorRetryInterval = 15 * time.Minute
+
+// reconcileError requeues transient errors. Permanent errors, e.g. missing permissions or a missing bucket,
+// are recorded on the Ready condition and only retried after permanentErrorRetryInterval.
+func (r *FolderReconciler) reconcileError(ctx context.Context, folderCR *csfov1alpha1.Folder, err error) (ctrl.Result, error) {
+	if !retry.IsPermanent(err) {
+		return ctrl.Result{}, err
+	}
+	log.FromContext(ctx).Error(err, "failed to reconcile folder")
+	if err := r.setReady(ctx, folderCR, metav1.ConditionFalse, retry.Reason(err), err.Error()); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: permanentErrorRetryInterval}, nil
+}
+
+// grantedRole is the role an earlier reconcile granted to status.principal, empty if none is granted.
+// Folders reconciled before the role was recorded granted the role of their spec or the read-only role.
+func grantedRole(folderCR *csfov1alpha1.Folder) string {
+	status := folderCR.Status
+	switch {
+	case status.Role != "" || status.Principal == "":
+		return status.Role
+	case status.ReadOnly:
+		return csfov1alpha1.ReadOnlyFolderRole
+	case folderCR.Spec.Role != "":
+		return folderCR.Spec.Role
+	}
+	return csfov1alpha1.DefaultFolderRole
+}
+
+// staleRole reports whether the role granted before has to be revoked as the Folder grants another role
+// or grants it to another principal
+func staleRole(folderCR *csfov1alpha1.Folder, role, principal string) bool {
+	granted := grantedRole(folderCR)
+	return granted != "" && (granted != role || folderCR.Status.Principal != principal)
+}
+
+// grantedBucket is the bucket of status.folder.
+// Folders reconciled before the bucket was recorded are in the bucket of their spec.
+func grantedBucket(folderCR *csfov1alpha1.Folder) string {
+	if folderCR.Status.Bucket != "" {
+		return folderCR.Status.Bucket
+	}
+	return folderCR.Spec.BucketName
+}
+
+// movedFolder reports whether the spec names another managed folder or bucket than status.folder
+func movedFolder(folderCR *csfov1alpha1.Folder) bool {
+	if folderCR.Status.Folder == "" {
+		return false
+	}
+	return strings.TrimSuffix(folderCR.Status.Folder, "/") != strings.TrimSuffix(folderCR.Spec.Name, "/") ||
+		grantedBucket(folderCR) != folderCR.Spec.BucketName
+}
+
+// revokeRole revokes the role granted before from status.principal on status.folder
+func (r *FolderReconciler) revokeRole(ctx context.Context, folderCR *csfov1alpha1.Folder) error {
+	granted := grantedRole(folderCR)
+	if granted == "" || folderCR.Status.Folder == "" {
+		return nil
+	}
+	err := r.gcpClient.RevokeRoleOnFolder(ctx, folderCR.Status.Folder, grantedBucket(folderCR),
+		granted, folderCR.Status.Principal)
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("revoked role", "role", granted, "principal", folderCR.Status.Principal)
+	folderCR.Status.Role = ""
+	folderCR.Status.Principal = ""
+	return nil
+}
+
+// setReady records the Ready condition, a Folder deleted in the meantime is ignored
+func (r *FolderReconciler) setReady(ctx context.Context, folderCR *csfov1alpha1.Folder, status metav1.ConditionStatus, reason, message string) error {
+	meta.SetStatusCondition(&folderCR.Status.Conditions, metav1.Condition{
+		Type:    csfov1alpha1.ConditionReady,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+	return client.IgnoreNotFound(r.Status().Update(ctx, folderCR))
+}
+
+// foldersForPolicy requeues all Folders whenever a StoragePolicy changes
+func (r *FolderReconciler) foldersForPolicy(ctx context.Context, _ client.Object) []reconcile.Request {
+	var folders csfov1alpha1.FolderList
+	if err := r.List(ctx, &folders); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list folders of storage policy")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(folders.Items))
+	for _, folderCR := range folders.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&folderCR)})
+	}
+	return requests
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -122,9 +548,26 @@ func (r *FolderReconciler) SetupWithManager(mgr ctrl.Manager, gcpProjectID strin
 		return fmt.Errorf("could not create GCP client: %w", err)
 	}
 	r.gcpClient = gcpClient
+	r.usage.events = make(chan event.GenericEvent)
+	usageCtx, stopListings := context.WithCancel(context.Background())
+	r.usage.ctx = usageCtx
+	err = mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		stopListings()
+		return nil
+	}))
+	if err != nil {
+		return fmt.Errorf("could not stop the usage listings with the manager: %w", err)
+	}
 
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&csfov1alpha1.Folder{}).
 		Owns(&corev1.ServiceAccount{}).
+		Owns(&corev1.ConfigMap{}).
+		// Secrets are only watched by their metadata, see cmd/main.go
+		Owns(&corev1.Secret{}, builder.OnlyMetadata).
+		Watches(&csfov1alpha1.StoragePolicy{}, handler.EnqueueRequestsFromMapFunc(r.foldersForPolicy)).
+		// Folders whose usage listing finished
+		WatchesRawSource(&source.Channel{Source: r.usage.events}, &handler.EnqueueRequestForObject{}).
 		Complete(r)
 }
@@ -18,16 +18,20 @@ package controller
 
 import (
 	"context"
+	"net/http"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"google.golang.org/api/googleapi"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/retry"
 )
 
 var _ = Describe("Folder Controller", func() {
@@ -80,5 +84,78 @@ var _ = Describe("Folder Controller", func() {
 			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic.
 			// Example: If you expect a certain status condition after reconciliation, verify it here.
 		})
+
+		It("should record permanent errors and retry them late", func() {
+			controllerReconciler := &FolderReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, folder)).To(Succeed())
+
+			// A service account IAM does not know about yet
+			notFound := &googleapi.Error{Code: http.StatusNotFound, Message: "service account does not exist"}
+			result, err := controllerReconciler.reconcileError(ctx, folder, notFound)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(reconcile.Result{RequeueAfter: permanentErrorRetryInterval}))
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, folder)).To(Succeed())
+			ready := meta.FindStatusCondition(folder.Status.Conditions, csfov1alpha1.ConditionReady)
+			Expect(ready).NotTo(BeNil())
+			Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+			Expect(ready.Reason).To(Equal(retry.ReasonNotFound))
+
+			// Transient errors are returned to the rate limiter as is
+			unavailable := &googleapi.Error{Code: http.StatusServiceUnavailable}
+			_, err = controllerReconciler.reconcileError(ctx, folder, unavailable)
+			Expect(err).To(MatchError(unavailable))
+		})
+	})
+})
+
+var _ = Describe("Folder role", func() {
+	const principal = "serviceAccount:data-team-a@project.iam.gserviceaccount.com"
+	granted := func(role string) *csfov1alpha1.Folder {
+		return &csfov1alpha1.Folder{
+			Spec:   csfov1alpha1.FolderSpec{Role: "roles/storage.objectUser"},
+			Status: csfov1alpha1.FolderStatus{Principal: principal, Role: role},
+		}
+	}
+
+	It("should keep the role granted before", func() {
+		Expect(staleRole(granted("roles/storage.objectUser"), "roles/storage.objectUser", principal)).To(BeFalse())
+	})
+
+	It("should revoke the role once the spec or the principal changes", func() {
+		Expect(staleRole(granted("roles/storage.folderAdmin"), "roles/storage.objectUser", principal)).To(BeTrue())
+		Expect(staleRole(granted("roles/storage.objectUser"), "roles/storage.objectUser", "principal://other")).To(BeTrue())
+	})
+
+	It("should revoke nothing without a granted role", func() {
+		folderCR := granted("")
+		folderCR.Status.Principal = ""
+		Expect(grantedRole(folderCR)).To(BeEmpty())
+		Expect(staleRole(folderCR, "roles/storage.objectUser", principal)).To(BeFalse())
+	})
+
+	It("should infer the role of Folders reconciled before it was recorded", func() {
+		folderCR := granted("")
+		Expect(grantedRole(folderCR)).To(Equal("roles/storage.objectUser"))
+		folderCR.Status.ReadOnly = true
+		Expect(grantedRole(folderCR)).To(Equal(csfov1alpha1.ReadOnlyFolderRole))
+		Expect(staleRole(folderCR, "roles/storage.objectUser", principal)).To(BeTrue())
+	})
+	It("should release the managed folder once the Folder moves", func() {
+		folderCR := granted("roles/storage.objectUser")
+		folderCR.Spec.Name, folderCR.Spec.BucketName = "data", "bucket-b"
+		folderCR.Status.Folder = "data/"
+		// Folders reconciled before the bucket was recorded are in the bucket of their spec
+		Expect(grantedBucket(folderCR)).To(Equal("bucket-b"))
+		Expect(movedFolder(folderCR)).To(BeFalse())
+
+		folderCR.Status.Bucket = "bucket-a"
+		Expect(movedFolder(folderCR)).To(BeTrue())
+		folderCR.Status.Bucket = "bucket-b"
+		folderCR.Spec.Name = "other"
+		Expect(movedFolder(folderCR)).To(BeTrue())
 	})
 })
@@ -0,0 +1,95 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp"
+)
+
+// folderFinalizer cleans up the GCP resources of a deleted Folder
+const folderFinalizer = "csfo.sijoma.dev/folder"
+
+// folderOwner is the owner recorded in the description of the service account of the Folder
+func folderOwner(folderCR *csfov1alpha1.Folder) string {
+	return "Folder " + folderCR.Namespace + "/" + folderCR.Name
+}
+
+// finalize cleans up the GCP resources of a deleted Folder. Only the resources status.ownership records as Created
+// are deleted, adopted ones existed before and outlive the Folder: only the role granted on them is revoked.
+// HMAC keys are always deleted, the Secret holding them is garbage collected with the Folder.
+func (r *FolderReconciler) finalize(ctx context.Context, folderCR *csfov1alpha1.Folder) error {
+	if err := r.deleteHMACKeys(ctx, folderCR, folderCR.Status.Email); err != nil {
+		return err
+	}
+
+	if err := r.releaseFolder(ctx, folderCR); err != nil {
+		return err
+	}
+
+	return r.deleteServiceAccount(ctx, folderCR)
+}
+
+// releaseFolder gives up the managed folder of status.folder, on deletion or once the Folder moved to another
+// managed folder or bucket. A created managed folder is deleted, only the role granted on an adopted one is revoked.
+func (r *FolderReconciler) releaseFolder(ctx context.Context, folderCR *csfov1alpha1.Folder) error {
+	ownership := folderCR.Status.Ownership
+	if ownership == nil {
+		ownership = &csfov1alpha1.FolderOwnership{}
+	}
+
+	// Deleting the managed folder drops its bindings, the objects below it are kept
+	if ownership.ManagedFolder == csfov1alpha1.OwnershipCreated && folderCR.Status.Folder != "" {
+		err := r.gcpClient.DeleteManagedFolder(ctx, folderCR.Status.Folder, grantedBucket(folderCR))
+		if err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("deleted managed folder", "folder", folderCR.Status.Folder, "bucket", grantedBucket(folderCR))
+		folderCR.Status.Role, folderCR.Status.Principal = "", ""
+	} else if err := r.revokeRole(ctx, folderCR); err != nil {
+		return err
+	}
+	ownership.ManagedFolder = ""
+	folderCR.Status.Folder, folderCR.Status.Bucket = "", ""
+	return nil
+}
+
+// deleteServiceAccount deletes the service account of status.email if the Folder created it, an adopted one is kept.
+// The role granted to it has to be revoked before, the bindings of a deleted service account can not be revoked.
+func (r *FolderReconciler) deleteServiceAccount(ctx context.Context, folderCR *csfov1alpha1.Folder) error {
+	ownership := folderCR.Status.Ownership
+	if ownership == nil || folderCR.Status.Email == "" {
+		return nil
+	}
+	if ownership.ServiceAccount == csfov1alpha1.OwnershipCreated {
+		err := r.gcpClient.DeleteServiceAccount(ctx, folderCR.Status.Email, folderOwner(folderCR))
+		if errors.Is(err, gcp.ErrNotOwned) {
+			// The description no longer names the Folder, someone else took the service account over
+			log.FromContext(ctx).Info("keeping service account", "reason", err.Error())
+		} else if err != nil {
+			return err
+		}
+	}
+	ownership.ServiceAccount = ""
+	folderCR.Status.Email = ""
+	return nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+)
+
+var _ = Describe("Folder finalizer", func() {
+	ctx := context.Background()
+
+	It("should keep adopted resources of a deleted Folder", func() {
+		// Without a GCP client any call to GCP fails the spec: adopted resources are left untouched
+		folderCR := &csfov1alpha1.Folder{
+			ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "team-a", Finalizers: []string{folderFinalizer}},
+			Spec:       csfov1alpha1.FolderSpec{BucketName: "bucket", Name: "teams/team-a"},
+			Status: csfov1alpha1.FolderStatus{
+				Folder: "teams/team-a/",
+				Email:  "data-team-a@project.iam.gserviceaccount.com",
+				Ownership: &csfov1alpha1.FolderOwnership{
+					ManagedFolder:  csfov1alpha1.OwnershipAdopted,
+					ServiceAccount: csfov1alpha1.OwnershipAdopted,
+				},
+			},
+		}
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme.Scheme).
+			WithStatusSubresource(&csfov1alpha1.Folder{}).WithObjects(folderCR).Build()
+		Expect(fakeClient.Delete(ctx, folderCR)).To(Succeed())
+
+		controllerReconciler := &FolderReconciler{Client: fakeClient, Scheme: scheme.Scheme}
+		_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(folderCR)})
+		Expect(err).NotTo(HaveOccurred())
+		err = fakeClient.Get(ctx, client.ObjectKeyFromObject(folderCR), folderCR)
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should forget an adopted service account once it is no longer used", func() {
+		folderCR := &csfov1alpha1.Folder{Status: csfov1alpha1.FolderStatus{
+			Email:     "shared@project.iam.gserviceaccount.com",
+			Ownership: &csfov1alpha1.FolderOwnership{ServiceAccount: csfov1alpha1.OwnershipAdopted},
+		}}
+		controllerReconciler := &FolderReconciler{}
+		Expect(controllerReconciler.deleteServiceAccount(ctx, folderCR)).To(Succeed())
+		Expect(folderCR.Status.Email).To(BeEmpty())
+		Expect(folderCR.Status.Ownership.ServiceAccount).To(BeEmpty())
+	})
+
+	It("should record the owner in the description of the service account", func() {
+		folderCR := &csfov1alpha1.Folder{ObjectMeta: metav1.ObjectMeta{Name: "a-b", Namespace: "c"}}
+		Expect(folderOwner(folderCR)).To(Equal("Folder c/a-b"))
+	})
+})
@@ -0,0 +1,258 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iam/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/resources"
+)
+
+// defaultHMACGracePeriod keeps replaced HMAC keys active unless the Folder configures a grace period
+const defaultHMACGracePeriod = 24 * time.Hour
+
+// hmacKeyClockSkew widens the window in which keys of an interrupted creation are searched,
+// the creation time of a key is taken from the clock of GCP
+const hmacKeyClockSkew = time.Minute
+
+// reconcileHMACKey creates the HMAC key of the Folder and rotates it once it is older than the rotation interval.
+// Replaced keys are deactivated and deleted after the grace period, all keys are deleted once the Folder
+// no longer requests one. Returns when the keys need to be looked at again, zero if never.
+func (r *FolderReconciler) reconcileHMACKey(ctx context.Context, folderCR *csfov1alpha1.Folder,
+	account *iam.ServiceAccount,
+) (time.Duration, error) {
+	logger := log.FromContext(ctx)
+	spec := folderCR.Spec.HMACKey
+	status := folderCR.Status.HMACKey
+	email := ""
+	if account != nil {
+		email = account.Email
+	}
+	if spec == nil {
+		if status == nil {
+			return 0, nil
+		}
+		secretName := status.SecretName
+		if err := r.deleteHMACKeys(ctx, folderCR, email); err != nil {
+			return 0, err
+		}
+		if secretName == "" {
+			return 0, nil
+		}
+		key := types.NamespacedName{Name: secretName, Namespace: folderCR.Namespace}
+		return 0, resources.DeleteOwned(ctx, r.Client, key, &corev1.Secret{}, folderCR)
+	}
+
+	if status == nil {
+		status = &csfov1alpha1.HMACKeyStatus{}
+		folderCR.Status.HMACKey = status
+	}
+	if err := r.deleteOrphanedHMACKeys(ctx, status, email); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	name := spec.SecretName
+	if name == "" {
+		name = folderCR.Name + "-hmac"
+	}
+	if hmacKeyDue(spec, status, name, now) {
+		// Checked before the key is created, a Secret of the user would otherwise cost a key on every attempt
+		key := types.NamespacedName{Name: name, Namespace: folderCR.Namespace}
+		if err := resources.CheckOwned(ctx, r.Client, key, &corev1.Secret{}, folderCR); err != nil {
+			return 0, err
+		}
+		if err := r.createHMACKey(ctx, folderCR, email, name, now); err != nil {
+			return 0, err
+		}
+	}
+
+	gracePeriod := defaultHMACGracePeriod
+	if spec.GracePeriod != nil {
+		gracePeriod = spec.GracePeriod.Duration
+	}
+	expired, remaining, requeueAfter := expireHMACKeys(status.RetiredKeys, gracePeriod, now)
+	for i, retired := range expired {
+		if err := r.gcpClient.DeleteHMACKey(ctx, retired.AccessID); err != nil {
+			status.RetiredKeys = append(remaining, expired[i:]...)
+			return 0, err
+		}
+		logger.Info("deleted retired hmac key", "accessID", retired.AccessID)
+	}
+	status.RetiredKeys = remaining
+
+	if spec.RotationInterval != nil {
+		requeueAfter = earliest(requeueAfter, status.CreatedAt.Add(spec.RotationInterval.Duration).Sub(now))
+	}
+	return requeueAfter, nil
+}
+
+// createHMACKey creates a new key for the service account, writes it to the Secret name and retires the current key.
+// The key is known only to GCP until the status recording its access ID is updated: status.pendingSince is persisted
+// before, so that a key left behind by a failed update is found and deleted by deleteOrphanedHMACKeys.
+func (r *FolderReconciler) createHMACKey(ctx context.Context, folderCR *csfov1alpha1.Folder,
+	email, name string, now time.Time,
+) error {
+	logger := log.FromContext(ctx)
+	status := folderCR.Status.HMACKey
+	pendingSince := metav1.NewTime(now)
+	status.PendingSince = &pendingSince
+	if err := r.Status().Update(ctx, folderCR); err != nil {
+		return err
+	}
+
+	key, err := r.gcpClient.CreateHMACKey(ctx, email)
+	if err != nil {
+		return err
+	}
+	_, err = resources.HMACSecret(ctx, r.Client, folderCR, name, folderCR.Namespace, key.AccessID, key.Secret)
+	if err != nil {
+		// The secret of the key is lost, a new key is created on the next attempt
+		if deleteErr := r.gcpClient.DeleteHMACKey(ctx, key.AccessID); deleteErr != nil {
+			logger.Error(deleteErr, "failed to delete unused hmac key", "accessID", key.AccessID)
+		}
+		return err
+	}
+	if status.SecretName != "" && status.SecretName != name {
+		old := types.NamespacedName{Name: status.SecretName, Namespace: folderCR.Namespace}
+		if err := resources.DeleteOwned(ctx, r.Client, old, &corev1.Secret{}, folderCR); err != nil {
+			return err
+		}
+	}
+	if status.AccessID != "" {
+		status.RetiredKeys = append(status.RetiredKeys, csfov1alpha1.RetiredHMACKey{
+			AccessID:  status.AccessID,
+			RetiredAt: metav1.NewTime(now),
+		})
+	}
+	status.SecretName = name
+	status.AccessID = key.AccessID
+	status.CreatedAt = metav1.NewTime(now)
+	status.PendingSince = nil
+	if err := r.Status().Update(ctx, folderCR); err != nil {
+		return err
+	}
+	logger.Info("created hmac key", "accessID", key.AccessID, "secret", name)
+	return nil
+}
+
+// deleteHMACKeys deactivates and deletes all keys of the Folder, including those of an interrupted creation
+func (r *FolderReconciler) deleteHMACKeys(ctx context.Context, folderCR *csfov1alpha1.Folder, email string) error {
+	status := folderCR.Status.HMACKey
+	if status == nil {
+		return nil
+	}
+	if err := r.deleteOrphanedHMACKeys(ctx, status, email); err != nil {
+		return err
+	}
+	for _, accessID := range hmacKeyIDs(status) {
+		if err := r.gcpClient.DeleteHMACKey(ctx, accessID); err != nil {
+			return err
+		}
+	}
+	folderCR.Status.HMACKey = nil
+	return nil
+}
+
+// deleteOrphanedHMACKeys deletes the keys of the service account whose creation was interrupted before their
+// access ID was recorded. Without a service account there is nothing left to search.
+func (r *FolderReconciler) deleteOrphanedHMACKeys(ctx context.Context, status *csfov1alpha1.HMACKeyStatus,
+	email string,
+) error {
+	if status.PendingSince == nil {
+		return nil
+	}
+	if email != "" {
+		keys, err := r.gcpClient.ListHMACKeys(ctx, email)
+		if err != nil {
+			return err
+		}
+		for _, accessID := range orphanedHMACKeys(keys, status) {
+			if err := r.gcpClient.DeleteHMACKey(ctx, accessID); err != nil {
+				return err
+			}
+			log.FromContext(ctx).Info("deleted orphaned hmac key", "accessID", accessID)
+		}
+	}
+	status.PendingSince = nil
+	return nil
+}
+
+// hmacKeyDue reports whether a new key has to replace the current one: none was created yet,
+// the Secret was renamed or the rotation interval passed
+func hmacKeyDue(spec *csfov1alpha1.HMACKeySpec, status *csfov1alpha1.HMACKeyStatus, name string, now time.Time) bool {
+	if status.AccessID == "" || status.SecretName != name {
+		return true
+	}
+	return spec.RotationInterval != nil && !now.Before(status.CreatedAt.Add(spec.RotationInterval.Duration))
+}
+
+// expireHMACKeys splits the retired keys into those past the grace period and those still active.
+// requeueAfter is when the next of the remaining keys expires, zero if none remain.
+func expireHMACKeys(retired []csfov1alpha1.RetiredHMACKey, gracePeriod time.Duration, now time.Time,
+) (expired, remaining []csfov1alpha1.RetiredHMACKey, requeueAfter time.Duration) {
+	for _, key := range retired {
+		deleteAt := key.RetiredAt.Add(gracePeriod)
+		if now.Before(deleteAt) {
+			remaining = append(remaining, key)
+			requeueAfter = earliest(requeueAfter, deleteAt.Sub(now))
+			continue
+		}
+		expired = append(expired, key)
+	}
+	return expired, remaining, requeueAfter
+}
+
+// orphanedHMACKeys returns the access IDs of the keys created since status.pendingSince which the status
+// does not know, keys created before belong to someone else sharing the service account
+func orphanedHMACKeys(keys []*storage.HMACKey, status *csfov1alpha1.HMACKeyStatus) []string {
+	if status.PendingSince == nil {
+		return nil
+	}
+	known := hmacKeyIDs(status)
+	since := status.PendingSince.Add(-hmacKeyClockSkew)
+	var orphaned []string
+	for _, key := range keys {
+		if key.State == storage.Deleted || key.CreatedTime.Before(since) || slices.Contains(known, key.AccessID) {
+			continue
+		}
+		orphaned = append(orphaned, key.AccessID)
+	}
+	return orphaned
+}
+
+// hmacKeyIDs returns the access IDs of the current and the retired keys
+func hmacKeyIDs(status *csfov1alpha1.HMACKeyStatus) []string {
+	var ids []string
+	if status.AccessID != "" {
+		ids = append(ids, status.AccessID)
+	}
+	for _, retired := range status.RetiredKeys {
+		ids = append(ids, retired.AccessID)
+	}
+	return ids
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/storage"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+)
+
+var _ = Describe("Folder HMAC keys", func() {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) metav1.Time { return metav1.NewTime(now.Add(d)) }
+
+	It("should create a key when none exists or the Secret is renamed", func() {
+		spec := &csfov1alpha1.HMACKeySpec{}
+		Expect(hmacKeyDue(spec, &csfov1alpha1.HMACKeyStatus{}, "data-hmac", now)).To(BeTrue())
+
+		status := &csfov1alpha1.HMACKeyStatus{SecretName: "data-hmac", AccessID: "key-1", CreatedAt: at(-time.Hour)}
+		Expect(hmacKeyDue(spec, status, "data-hmac", now)).To(BeFalse())
+		Expect(hmacKeyDue(spec, status, "s3-credentials", now)).To(BeTrue())
+	})
+
+	It("should rotate the key once the rotation interval passed", func() {
+		spec := &csfov1alpha1.HMACKeySpec{RotationInterval: &metav1.Duration{Duration: 2 * time.Hour}}
+		status := &csfov1alpha1.HMACKeyStatus{SecretName: "data-hmac", AccessID: "key-1", CreatedAt: at(-time.Hour)}
+		Expect(hmacKeyDue(spec, status, "data-hmac", now)).To(BeFalse())
+		status.CreatedAt = at(-2 * time.Hour)
+		Expect(hmacKeyDue(spec, status, "data-hmac", now)).To(BeTrue())
+	})
+
+	It("should keep retired keys for the grace period", func() {
+		retired := []csfov1alpha1.RetiredHMACKey{
+			{AccessID: "key-1", RetiredAt: at(-25 * time.Hour)},
+			{AccessID: "key-2", RetiredAt: at(-24 * time.Hour)},
+			{AccessID: "key-3", RetiredAt: at(-20 * time.Hour)},
+			{AccessID: "key-4", RetiredAt: at(-time.Hour)},
+		}
+		expired, remaining, requeueAfter := expireHMACKeys(retired, 24*time.Hour, now)
+		Expect(expired).To(Equal(retired[:2]))
+		Expect(remaining).To(Equal(retired[2:]))
+		Expect(requeueAfter).To(Equal(4 * time.Hour))
+
+		expired, remaining, requeueAfter = expireHMACKeys(retired[:1], 24*time.Hour, now)
+		Expect(expired).To(HaveLen(1))
+		Expect(remaining).To(BeEmpty())
+		Expect(requeueAfter).To(BeZero())
+	})
+
+	It("should only delete unknown keys created since the pending creation", func() {
+		status := &csfov1alpha1.HMACKeyStatus{
+			AccessID:    "current",
+			RetiredKeys: []csfov1alpha1.RetiredHMACKey{{AccessID: "retired", RetiredAt: at(-time.Hour)}},
+		}
+		keys := []*storage.HMACKey{
+			{AccessID: "current", CreatedTime: now.Add(-2 * time.Hour), State: storage.Active},
+			{AccessID: "retired", CreatedTime: now.Add(-3 * time.Hour), State: storage.Active},
+			{AccessID: "foreign", CreatedTime: now.Add(-time.Hour), State: storage.Active},
+			{AccessID: "skewed", CreatedTime: now.Add(-30 * time.Second), State: storage.Active},
+			{AccessID: "orphan", CreatedTime: now.Add(time.Second), State: storage.Active},
+			{AccessID: "deleted", CreatedTime: now.Add(time.Second), State: storage.Deleted},
+		}
+		Expect(orphanedHMACKeys(keys, status)).To(BeEmpty())
+
+		pendingSince := metav1.NewTime(now)
+		status.PendingSince = &pendingSince
+		Expect(orphanedHMACKeys(keys, status)).To(Equal([]string{"skewed", "orphan"}))
+	})
+
+	It("should forget the pending creation without a service account to search", func() {
+		pendingSince := metav1.NewTime(now)
+		status := &csfov1alpha1.HMACKeyStatus{PendingSince: &pendingSince}
+		controllerReconciler := &FolderReconciler{}
+		Expect(controllerReconciler.deleteOrphanedHMACKeys(context.Background(), status, "")).To(Succeed())
+		Expect(status.PendingSince).To(BeNil())
+	})
+
+	It("should delete the current and the retired keys", func() {
+		status := &csfov1alpha1.HMACKeyStatus{
+			AccessID:    "current",
+			RetiredKeys: []csfov1alpha1.RetiredHMACKey{{AccessID: "retired-1"}, {AccessID: "retired-2"}},
+		}
+		Expect(hmacKeyIDs(status)).To(Equal([]string{"current", "retired-1", "retired-2"}))
+		Expect(hmacKeyIDs(&csfov1alpha1.HMACKeyStatus{})).To(BeEmpty())
+	})
+})
@@ -0,0 +1,253 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/gcs"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/retry"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/retrievers"
+)
+
+// usageRetryInterval is how soon a failed listing of a managed folder is retried
+const usageRetryInterval = time.Minute
+
+// usageListings runs the listings of the managed folders in the background, so a large managed folder does not
+// block a reconcile worker. The Folder is requeued through events once its listing finished.
+type usageListings struct {
+	mu       sync.Mutex
+	running  map[types.NamespacedName]bool
+	finished map[types.NamespacedName]usageListing
+	// retryAt delays the next listing of a Folder whose listing failed
+	retryAt map[types.NamespacedName]time.Time
+	events  chan event.GenericEvent
+	// ctx outlives the reconciles starting the listings, it is done once the manager stops
+	ctx context.Context
+}
+
+// usageListing is the outcome of a finished listing below prefix
+type usageListing struct {
+	prefix string
+	usage  *csfov1alpha1.FolderUsage
+	err    error
+}
+
+// usagePrefix is the prefix listed for the usage of a Folder
+func usagePrefix(folderCR *csfov1alpha1.Folder) string {
+	return strings.TrimSuffix(folderCR.Spec.Name, "/") + "/"
+}
+
+// collectUsage records the outcome of a finished listing of the managed folder: the usage, or the error on the
+// UsageRefreshed condition. A failed listing keeps the previous usage, a listing of a renamed folder is dropped.
+func (r *FolderReconciler) collectUsage(ctx context.Context, folderCR *csfov1alpha1.Folder) {
+	key := client.ObjectKeyFromObject(folderCR)
+	r.usage.mu.Lock()
+	listing, ok := r.usage.finished[key]
+	delete(r.usage.finished, key)
+	r.usage.mu.Unlock()
+	if !ok || listing.prefix != usagePrefix(folderCR) {
+		return
+	}
+
+	condition := metav1.Condition{
+		Type:               csfov1alpha1.ConditionUsageRefreshed,
+		Status:             metav1.ConditionTrue,
+		Reason:             csfov1alpha1.ReasonReconciled,
+		ObservedGeneration: folderCR.Generation,
+	}
+	if listing.err != nil {
+		log.FromContext(ctx).Error(listing.err, "failed to refresh folder usage")
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = retry.Reason(listing.err)
+		if condition.Reason == "" {
+			condition.Reason = csfov1alpha1.ReasonListingFailed
+		}
+		condition.Message = listing.err.Error()
+	} else {
+		log.FromContext(ctx).Info("refreshed folder usage", "objects", listing.usage.Objects, "bytes", listing.usage.Bytes)
+		folderCR.Status.Usage = listing.usage
+	}
+	meta.SetStatusCondition(&folderCR.Status.Conditions, condition)
+}
+
+// refreshUsage starts a listing of the managed folder once the usage is older than the usage interval, the next
+// reconcile after it finished collects the usage. Returns when the usage needs to be refreshed next, zero if never
+// or while the listing runs.
+func (r *FolderReconciler) refreshUsage(ctx context.Context, folderCR *csfov1alpha1.Folder) time.Duration {
+	if r.UsageInterval <= 0 {
+		return 0
+	}
+	now := time.Now()
+	if usage := folderCR.Status.Usage; usage != nil && now.Before(usage.LastUpdated.Add(r.UsageInterval)) {
+		return usage.LastUpdated.Add(r.UsageInterval).Sub(now)
+	}
+
+	key := client.ObjectKeyFromObject(folderCR)
+	r.usage.mu.Lock()
+	defer r.usage.mu.Unlock()
+	if r.usage.running[key] {
+		return 0
+	}
+	if retryAt, ok := r.usage.retryAt[key]; ok && now.Before(retryAt) {
+		return retryAt.Sub(now)
+	}
+	if r.usage.running == nil {
+		r.usage.running = map[types.NamespacedName]bool{}
+	}
+	r.usage.running[key] = true
+	listCtx := r.usage.ctx
+	if listCtx == nil {
+		listCtx = context.Background()
+	}
+	go r.listUsage(log.IntoContext(listCtx, log.FromContext(ctx)), key, folderCR.Spec.BucketName, usagePrefix(folderCR))
+	return 0
+}
+
+// listUsage lists the objects below prefix and records their count and total size for collectUsage.
+// The listing is streamed, only the sums are kept. The Folder is not requeued once the manager stopped.
+func (r *FolderReconciler) listUsage(ctx context.Context, key types.NamespacedName, bucket, prefix string) {
+	listing := usageListing{prefix: prefix}
+	listing.usage, listing.err = r.countObjects(ctx, bucket, prefix)
+
+	r.usage.mu.Lock()
+	delete(r.usage.running, key)
+	if r.usage.finished == nil {
+		r.usage.finished = map[types.NamespacedName]usageListing{}
+	}
+	r.usage.finished[key] = listing
+	delete(r.usage.retryAt, key)
+	if listing.err != nil {
+		if r.usage.retryAt == nil {
+			r.usage.retryAt = map[types.NamespacedName]time.Time{}
+		}
+		r.usage.retryAt[key] = time.Now().Add(min(usageRetryInterval, r.UsageInterval))
+	}
+	r.usage.mu.Unlock()
+
+	if r.usage.events != nil {
+		folderCR := &csfov1alpha1.Folder{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+		select {
+		case r.usage.events <- event.GenericEvent{Object: folderCR}:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// countObjects sums up the objects below prefix, listed with the credentials of the operator
+func (r *FolderReconciler) countObjects(ctx context.Context, bucket, prefix string) (*csfov1alpha1.FolderUsage, error) {
+	gcsClient, release, err := r.Clients.Get(ctx, retrievers.AmbientCredentials, "")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	usage := &csfov1alpha1.FolderUsage{LastUpdated: metav1.Now()}
+	_, err = gcsClient.ListObjects(ctx, bucket, storage.Query{Prefix: prefix}, func(objects []gcs.Object) error {
+		usage.Objects += int64(len(objects))
+		for _, object := range objects {
+			usage.Bytes += object.Size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// forgetUsage drops the finished listing of a Folder which no longer exists
+func (r *FolderReconciler) forgetUsage(key types.NamespacedName) {
+	r.usage.mu.Lock()
+	defer r.usage.mu.Unlock()
+	delete(r.usage.finished, key)
+	delete(r.usage.retryAt, key)
+}
+
+// quotaRole returns the role granted on the managed folder of a Folder: read access only while the Folder is
+// read-only as its quota is exceeded. It applies to the Folder and to the FolderAccesses of its consumers.
+func quotaRole(role string, readOnly bool) string {
+	if readOnly {
+		return csfov1alpha1.ReadOnlyFolderRole
+	}
+	return role
+}
+
+// quotaExceeded reports whether the usage of the Folder exceeds its quota, along with the exceeded limits
+func quotaExceeded(folderCR *csfov1alpha1.Folder) (bool, string) {
+	quota, usage := folderCR.Spec.Quota, folderCR.Status.Usage
+	if quota == nil || usage == nil {
+		return false, ""
+	}
+	var exceeded []string
+	if quota.MaxBytes != nil && usage.Bytes > quota.MaxBytes.Value() {
+		exceeded = append(exceeded, fmt.Sprintf("%d bytes exceed maxBytes %s", usage.Bytes, quota.MaxBytes.String()))
+	}
+	if quota.MaxObjects != nil && usage.Objects > *quota.MaxObjects {
+		exceeded = append(exceeded, fmt.Sprintf("%d objects exceed maxObjects %d", usage.Objects, *quota.MaxObjects))
+	}
+	return len(exceeded) > 0, strings.Join(exceeded, ", ")
+}
+
+// recordQuota sets the QuotaExceeded condition of a Folder with a quota and emits an Event whenever it is crossed.
+// Returns whether the quota is exceeded.
+func (r *FolderReconciler) recordQuota(folderCR *csfov1alpha1.Folder) bool {
+	if folderCR.Spec.Quota == nil {
+		meta.RemoveStatusCondition(&folderCR.Status.Conditions, csfov1alpha1.ConditionQuotaExceeded)
+		return false
+	}
+	if folderCR.Status.Usage == nil {
+		return false
+	}
+
+	exceeded, message := quotaExceeded(folderCR)
+	condition := metav1.Condition{
+		Type:    csfov1alpha1.ConditionQuotaExceeded,
+		Status:  metav1.ConditionFalse,
+		Reason:  csfov1alpha1.ReasonWithinQuota,
+		Message: fmt.Sprintf("%d objects, %d bytes", folderCR.Status.Usage.Objects, folderCR.Status.Usage.Bytes),
+	}
+	if exceeded {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = csfov1alpha1.ReasonQuotaExceeded
+		condition.Message = message
+	}
+
+	wasExceeded := meta.IsStatusConditionTrue(folderCR.Status.Conditions, csfov1alpha1.ConditionQuotaExceeded)
+	switch {
+	case exceeded && !wasExceeded:
+		r.Recorder.Event(folderCR, corev1.EventTypeWarning, csfov1alpha1.ReasonQuotaExceeded, message)
+	case !exceeded && wasExceeded:
+		r.Recorder.Event(folderCR, corev1.EventTypeNormal, csfov1alpha1.ReasonWithinQuota, "usage is back within the quota")
+	}
+	meta.SetStatusCondition(&folderCR.Status.Conditions, condition)
+	return exceeded
+}
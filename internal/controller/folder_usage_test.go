@@ -0,0 +1,199 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+)
+
+var _ = Describe("Folder quota", func() {
+	maxBytes := resource.MustParse("1Ki")
+	maxObjects := int64(10)
+	folderWithUsage := func(objects, bytes int64) *csfov1alpha1.Folder {
+		return &csfov1alpha1.Folder{
+			Spec: csfov1alpha1.FolderSpec{
+				Quota: &csfov1alpha1.FolderQuota{MaxBytes: &maxBytes, MaxObjects: &maxObjects},
+			},
+			Status: csfov1alpha1.FolderStatus{
+				Usage: &csfov1alpha1.FolderUsage{Objects: objects, Bytes: bytes},
+			},
+		}
+	}
+
+	It("should not be exceeded at the limits", func() {
+		exceeded, message := quotaExceeded(folderWithUsage(10, 1024))
+		Expect(exceeded).To(BeFalse())
+		Expect(message).To(BeEmpty())
+	})
+
+	It("should report every exceeded limit", func() {
+		exceeded, message := quotaExceeded(folderWithUsage(11, 1025))
+		Expect(exceeded).To(BeTrue())
+		Expect(message).To(Equal("1025 bytes exceed maxBytes 1Ki, 11 objects exceed maxObjects 10"))
+	})
+
+	It("should not be exceeded before the usage is known", func() {
+		folderCR := folderWithUsage(0, 0)
+		folderCR.Status.Usage = nil
+		exceeded, _ := quotaExceeded(folderCR)
+		Expect(exceeded).To(BeFalse())
+	})
+})
+
+var _ = Describe("Folder quota conditions", func() {
+	maxObjects := int64(10)
+	newFolder := func(objects int64) *csfov1alpha1.Folder {
+		return &csfov1alpha1.Folder{
+			Spec: csfov1alpha1.FolderSpec{
+				Quota: &csfov1alpha1.FolderQuota{MaxObjects: &maxObjects, ReadOnly: true},
+			},
+			Status: csfov1alpha1.FolderStatus{Usage: &csfov1alpha1.FolderUsage{Objects: objects}},
+		}
+	}
+
+	It("should emit an Event only when the quota is crossed", func() {
+		recorder := record.NewFakeRecorder(10)
+		controllerReconciler := &FolderReconciler{Recorder: recorder}
+		folderCR := newFolder(5)
+
+		Expect(controllerReconciler.recordQuota(folderCR)).To(BeFalse())
+		condition := meta.FindStatusCondition(folderCR.Status.Conditions, csfov1alpha1.ConditionQuotaExceeded)
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal(csfov1alpha1.ReasonWithinQuota))
+		Expect(recorder.Events).To(BeEmpty())
+
+		folderCR.Status.Usage.Objects = 11
+		Expect(controllerReconciler.recordQuota(folderCR)).To(BeTrue())
+		Expect(controllerReconciler.recordQuota(folderCR)).To(BeTrue())
+		Expect(meta.IsStatusConditionTrue(folderCR.Status.Conditions, csfov1alpha1.ConditionQuotaExceeded)).To(BeTrue())
+		Expect(recorder.Events).To(HaveLen(1))
+		Expect(<-recorder.Events).To(ContainSubstring(csfov1alpha1.ReasonQuotaExceeded))
+
+		folderCR.Status.Usage.Objects = 10
+		Expect(controllerReconciler.recordQuota(folderCR)).To(BeFalse())
+		Expect(<-recorder.Events).To(ContainSubstring(csfov1alpha1.ReasonWithinQuota))
+	})
+
+	It("should drop the condition once the quota is removed", func() {
+		controllerReconciler := &FolderReconciler{Recorder: record.NewFakeRecorder(10)}
+		folderCR := newFolder(11)
+		Expect(controllerReconciler.recordQuota(folderCR)).To(BeTrue())
+
+		folderCR.Spec.Quota = nil
+		Expect(controllerReconciler.recordQuota(folderCR)).To(BeFalse())
+		Expect(meta.FindStatusCondition(folderCR.Status.Conditions, csfov1alpha1.ConditionQuotaExceeded)).To(BeNil())
+	})
+
+	It("should swap the roles of the Folder and its consumers while read-only", func() {
+		Expect(quotaRole(csfov1alpha1.DefaultFolderRole, false)).To(Equal(csfov1alpha1.DefaultFolderRole))
+		Expect(quotaRole(csfov1alpha1.DefaultFolderRole, true)).To(Equal(csfov1alpha1.ReadOnlyFolderRole))
+
+		folderCR := newFolder(11)
+		folderCR.Spec.BucketName = "bucket"
+		folderCR.Status.Folder = "teams/team-a/"
+		access := &csfov1alpha1.FolderAccess{Spec: csfov1alpha1.FolderAccessSpec{Role: "roles/storage.objectUser"}}
+		binding := accessBinding(folderCR, access, "consumer@project.iam.gserviceaccount.com")
+		Expect(binding.Role).To(Equal("roles/storage.objectUser"))
+
+		folderCR.Status.ReadOnly = true
+		readOnly := accessBinding(folderCR, access, "consumer@project.iam.gserviceaccount.com")
+		Expect(readOnly.Role).To(Equal(csfov1alpha1.ReadOnlyFolderRole))
+		Expect(readOnly.Member).To(Equal("serviceAccount:consumer@project.iam.gserviceaccount.com"))
+		Expect(*readOnly).NotTo(Equal(*binding), "a changed binding revokes the previous one")
+	})
+})
+
+var _ = Describe("Folder usage", func() {
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "data", Namespace: "team-a"}
+	newFolder := func() *csfov1alpha1.Folder {
+		return &csfov1alpha1.Folder{
+			ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+			Spec:       csfov1alpha1.FolderSpec{BucketName: "bucket", Name: "teams/team-a"},
+		}
+	}
+
+	It("should not list a fresh usage, a running listing or before the retry", func() {
+		controllerReconciler := &FolderReconciler{UsageInterval: time.Hour}
+		folderCR := newFolder()
+		folderCR.Status.Usage = &csfov1alpha1.FolderUsage{LastUpdated: metav1.NewTime(time.Now().Add(-10 * time.Minute))}
+		Expect(controllerReconciler.refreshUsage(ctx, folderCR)).To(BeNumerically("~", 50*time.Minute, time.Second))
+
+		// Without a cache of storage clients a started listing would panic
+		folderCR.Status.Usage = nil
+		controllerReconciler.usage.running = map[types.NamespacedName]bool{key: true}
+		Expect(controllerReconciler.refreshUsage(ctx, folderCR)).To(BeZero())
+
+		controllerReconciler.usage.running = nil
+		controllerReconciler.usage.retryAt = map[types.NamespacedName]time.Time{key: time.Now().Add(time.Minute)}
+		Expect(controllerReconciler.refreshUsage(ctx, folderCR)).To(BeNumerically("~", time.Minute, time.Second))
+
+		Expect((&FolderReconciler{}).refreshUsage(ctx, folderCR)).To(BeZero())
+	})
+
+	It("should record the usage of a finished listing", func() {
+		controllerReconciler := &FolderReconciler{}
+		usage := &csfov1alpha1.FolderUsage{Objects: 3, Bytes: 42}
+		controllerReconciler.usage.finished = map[types.NamespacedName]usageListing{
+			key: {prefix: "teams/team-a/", usage: usage},
+		}
+		folderCR := newFolder()
+		controllerReconciler.collectUsage(ctx, folderCR)
+		Expect(folderCR.Status.Usage).To(Equal(usage))
+		Expect(meta.IsStatusConditionTrue(folderCR.Status.Conditions, csfov1alpha1.ConditionUsageRefreshed)).To(BeTrue())
+		Expect(controllerReconciler.usage.finished).To(BeEmpty())
+	})
+
+	It("should keep the usage if the listing failed", func() {
+		controllerReconciler := &FolderReconciler{}
+		controllerReconciler.usage.finished = map[types.NamespacedName]usageListing{
+			key: {prefix: "teams/team-a/", err: errors.New("connection reset")},
+		}
+		folderCR := newFolder()
+		usage := &csfov1alpha1.FolderUsage{Objects: 3}
+		folderCR.Status.Usage = usage
+		controllerReconciler.collectUsage(ctx, folderCR)
+		Expect(folderCR.Status.Usage).To(Equal(usage))
+		condition := meta.FindStatusCondition(folderCR.Status.Conditions, csfov1alpha1.ConditionUsageRefreshed)
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal(csfov1alpha1.ReasonListingFailed))
+		Expect(condition.Message).To(Equal("connection reset"))
+	})
+
+	It("should drop the listing of a renamed folder", func() {
+		controllerReconciler := &FolderReconciler{}
+		controllerReconciler.usage.finished = map[types.NamespacedName]usageListing{
+			key: {prefix: "teams/team-b/", usage: &csfov1alpha1.FolderUsage{Objects: 3}},
+		}
+		folderCR := newFolder()
+		controllerReconciler.collectUsage(ctx, folderCR)
+		Expect(folderCR.Status.Usage).To(BeNil())
+		Expect(folderCR.Status.Conditions).To(BeEmpty())
+	})
+})
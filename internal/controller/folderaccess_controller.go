@@ -0,0 +1,399 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/internal/policy"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/retry"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/resources"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/tracing"
+)
+
+const (
+	// folderAccessFinalizer revokes the binding of a deleted FolderAccess
+	folderAccessFinalizer = "csfo.sijoma.dev/folder-access"
+	// folderRefIndex indexes FolderAccesses by the namespace/name of their Folder
+	folderRefIndex = ".spec.folderRef"
+)
+
+// FolderAccessReconciler grants the role of a FolderAccess on the managed folder once the Folder accepted it
+type FolderAccessReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	gcpClient *gcp.Client
+}
+
+//+kubebuilder:rbac:groups=csfo.sijoma.dev,resources=folderaccesses,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=csfo.sijoma.dev,resources=folderaccesses/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=csfo.sijoma.dev,resources=folderaccesses/finalizers,verbs=update
+
+// Reconcile binds the requested role while the access is accepted and revokes it otherwise
+func (r *FolderAccessReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues(
+		"folderaccess", req.Name,
+		"namespace", req.Namespace,
+	)
+	logger.Info("reconciling started")
+
+	ctx, span := tracing.Tracer().Start(ctx, "FolderAccess.Reconcile", trace.WithAttributes(
+		attribute.String("namespace", req.Namespace),
+		attribute.String("name", req.Name),
+	))
+	defer span.End()
+
+	access := new(csfov1alpha1.FolderAccess)
+	if err := r.Get(ctx, req.NamespacedName, access); err != nil {
+		// do not requeue "not found" errors
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !access.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(access, folderAccessFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if err := r.revoke(ctx, access); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.deleteConsumerServiceAccount(ctx, access); err != nil {
+			return ctrl.Result{}, err
+		}
+		controllerutil.RemoveFinalizer(access, folderAccessFinalizer)
+		return ctrl.Result{}, r.Update(ctx, access)
+	}
+	if controllerutil.AddFinalizer(access, folderAccessFinalizer) {
+		if err := r.Update(ctx, access); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	folder, accepted, err := r.accept(ctx, access)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if accepted.Status != metav1.ConditionTrue {
+		logger.Info("folder access not accepted", "reason", accepted.Reason)
+		if err := r.revoke(ctx, access); err != nil {
+			return r.reconcileError(ctx, access, err)
+		}
+		meta.SetStatusCondition(&access.Status.Conditions, accepted)
+		meta.SetStatusCondition(&access.Status.Conditions, metav1.Condition{
+			Type:    csfov1alpha1.ConditionReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  accepted.Reason,
+			Message: accepted.Message,
+		})
+		return ctrl.Result{}, client.IgnoreNotFound(r.Status().Update(ctx, access))
+	}
+	meta.SetStatusCondition(&access.Status.Conditions, accepted)
+
+	email, err := r.consumerIdentity(ctx, access)
+	if errors.Is(err, gcp.ErrNotOwned) {
+		meta.SetStatusCondition(&access.Status.Conditions, metav1.Condition{
+			Type:    csfov1alpha1.ConditionReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  csfov1alpha1.ReasonAdoptionRefused,
+			Message: err.Error(),
+		})
+		return ctrl.Result{}, client.IgnoreNotFound(r.Status().Update(ctx, access))
+	}
+	if err != nil {
+		return r.reconcileError(ctx, access, err)
+	}
+	binding := accessBinding(folder, access, email)
+	if access.Status.Binding != nil && *access.Status.Binding != *binding {
+		if err := r.revoke(ctx, access); err != nil {
+			return r.reconcileError(ctx, access, err)
+		}
+	}
+	// The service account created before is no longer needed once an existing one is bound
+	if access.Spec.ServiceAccountEmail != "" {
+		if err := r.deleteConsumerServiceAccount(ctx, access); err != nil {
+			return r.reconcileError(ctx, access, err)
+		}
+	}
+	access.Status.Email = email
+
+	var expiry time.Time
+	if access.Spec.ExpiresAt != nil {
+		expiry = access.Spec.ExpiresAt.Time
+	}
+	if !expiry.IsZero() && !time.Now().Before(expiry) {
+		logger.Info("folder access expired", "expiresAt", expiry)
+		access.Status.Binding = binding
+		if err := r.revoke(ctx, access); err != nil {
+			return r.reconcileError(ctx, access, err)
+		}
+		meta.SetStatusCondition(&access.Status.Conditions, metav1.Condition{
+			Type:    csfov1alpha1.ConditionReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  csfov1alpha1.ReasonExpired,
+			Message: fmt.Sprintf("access expired at %s", expiry.UTC().Format(time.RFC3339)),
+		})
+		return ctrl.Result{}, client.IgnoreNotFound(r.Status().Update(ctx, access))
+	}
+
+	err = r.gcpClient.GrantRoleOnFolder(ctx, binding.Folder, binding.Bucket, binding.Role, binding.Member, expiry)
+	if err != nil {
+		return r.reconcileError(ctx, access, err)
+	}
+	logger.Info("granted folder access", "folder", binding.Folder, "role", binding.Role, "member", binding.Member)
+
+	access.Status.Binding = binding
+	meta.SetStatusCondition(&access.Status.Conditions, metav1.Condition{
+		Type:   csfov1alpha1.ConditionReady,
+		Status: metav1.ConditionTrue,
+		Reason: csfov1alpha1.ReasonReconciled,
+	})
+	if err := r.Status().Update(ctx, access); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !expiry.IsZero() {
+		// Reconcile again to revoke the access once it expired
+		return ctrl.Result{RequeueAfter: time.Until(expiry)}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// accept checks whether the Folder is ready and shared with the namespace of the access for the requested role,
+// and whether the StoragePolicies allow the role in that namespace
+func (r *FolderAccessReconciler) accept(ctx context.Context, access *csfov1alpha1.FolderAccess) (*csfov1alpha1.Folder, metav1.Condition, error) {
+	condition := metav1.Condition{
+		Type:               csfov1alpha1.ConditionAccepted,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: access.Generation,
+	}
+
+	folder := new(csfov1alpha1.Folder)
+	err := r.Get(ctx, folderKey(access), folder)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, condition, err
+	}
+	if err != nil || folder.Status.Folder == "" {
+		condition.Reason = csfov1alpha1.ReasonFolderNotReady
+		condition.Message = fmt.Sprintf("folder %s/%s does not exist or is not ready", access.Spec.FolderRef.Namespace, access.Spec.FolderRef.Name)
+		return nil, condition, nil
+	}
+
+	shared := slices.ContainsFunc(folder.Spec.SharedWith, func(share csfov1alpha1.FolderShare) bool {
+		return share.Namespace == access.Namespace && slices.Contains(share.Roles, access.Spec.Role)
+	})
+	if !shared {
+		condition.Reason = csfov1alpha1.ReasonNotShared
+		condition.Message = fmt.Sprintf("folder %s/%s is not shared with namespace %s for role %s",
+			folder.Namespace, folder.Name, access.Namespace, access.Spec.Role)
+		return nil, condition, nil
+	}
+	// An existing service account could be anyone's, e.g. of another project, the owner of the Folder lists them
+	if email := access.Spec.ServiceAccountEmail; email != "" {
+		shared = slices.ContainsFunc(folder.Spec.SharedWith, func(share csfov1alpha1.FolderShare) bool {
+			return share.Namespace == access.Namespace && slices.Contains(share.Roles, access.Spec.Role) &&
+				slices.Contains(share.ServiceAccounts, email)
+		})
+		if !shared {
+			condition.Reason = csfov1alpha1.ReasonNotShared
+			condition.Message = fmt.Sprintf("folder %s/%s is not shared with service account %s of namespace %s",
+				folder.Namespace, folder.Name, email, access.Namespace)
+			return nil, condition, nil
+		}
+	}
+
+	err = policy.Check(ctx, r.Client, access.Namespace, []policy.Access{{
+		Bucket: folder.Spec.BucketName,
+		Prefix: strings.TrimSuffix(folder.Spec.Name, "/") + "/",
+		Role:   access.Spec.Role,
+	}})
+	if errors.Is(err, policy.ErrDenied) {
+		condition.Reason = csfov1alpha1.ReasonPolicyDenied
+		condition.Message = err.Error()
+		return nil, condition, nil
+	}
+	if err != nil {
+		return nil, condition, err
+	}
+
+	condition.Status = metav1.ConditionTrue
+	condition.Reason = csfov1alpha1.ReasonAccepted
+	return folder, condition, nil
+}
+
+// accessBinding is the binding granted for the access to the service account of email. While the Folder is read-only
+// as its quota is exceeded, its consumers get read access only like the Folder itself.
+func accessBinding(folder *csfov1alpha1.Folder, access *csfov1alpha1.FolderAccess, email string) *csfov1alpha1.FolderAccessBinding {
+	return &csfov1alpha1.FolderAccessBinding{
+		Bucket: folder.Spec.BucketName,
+		Folder: folder.Status.Folder,
+		Role:   quotaRole(access.Spec.Role, folder.Status.ReadOnly),
+		Member: "serviceAccount:" + email,
+	}
+}
+
+// consumerIdentity returns the email of the service account receiving the role. Unless an existing one is given,
+// a service account is created with a Kubernetes ServiceAccount using it through workload identity.
+// Its email is recorded in the status before it is created, the finalizer deletes it along with the FolderAccess.
+func (r *FolderAccessReconciler) consumerIdentity(ctx context.Context, access *csfov1alpha1.FolderAccess) (string, error) {
+	if access.Spec.ServiceAccountEmail != "" {
+		return access.Spec.ServiceAccountEmail, nil
+	}
+
+	kubernetesSAName := access.Name + "-access"
+	gcpSAName := gcp.AccountID("fa-" + access.Name + "-" + access.Namespace)
+	if email := r.gcpClient.ServiceAccountEmail(gcpSAName); access.Status.Email != email {
+		access.Status.Email = email
+		if err := r.Status().Update(ctx, access); err != nil {
+			return "", err
+		}
+	}
+	// Service accounts are never adopted, their names are ambiguous and they could be of another consumer
+	account, _, err := r.gcpClient.CreateServiceAccount(ctx, gcpSAName, kubernetesSAName, access.Namespace,
+		folderAccessOwner(access), false)
+	if err != nil {
+		return "", err
+	}
+	k8sSA, err := resources.ServiceAccountWIFEnabled(ctx, r.Client,
+		access, kubernetesSAName, access.Namespace, gcpSAName, account.ProjectId)
+	if err != nil {
+		return "", err
+	}
+	access.Status.ServiceAccountName = k8sSA.Name
+	return account.Email, nil
+}
+
+// folderAccessOwner is the owner recorded in the description of the service account of the FolderAccess
+func folderAccessOwner(access *csfov1alpha1.FolderAccess) string {
+	return "FolderAccess " + access.Namespace + "/" + access.Name
+}
+
+// deleteConsumerServiceAccount deletes the service account created for the FolderAccess: the email of the status
+// unless it is the one of the spec. A service account the operator did not create for it is left untouched.
+func (r *FolderAccessReconciler) deleteConsumerServiceAccount(ctx context.Context, access *csfov1alpha1.FolderAccess) error {
+	email := access.Status.Email
+	if email == "" || email == access.Spec.ServiceAccountEmail {
+		return nil
+	}
+	err := r.gcpClient.DeleteServiceAccount(ctx, email, folderAccessOwner(access))
+	if errors.Is(err, gcp.ErrNotOwned) {
+		log.FromContext(ctx).Info("keeping service account", "reason", err.Error())
+	} else if err != nil {
+		return err
+	}
+	access.Status.Email, access.Status.ServiceAccountName = "", ""
+	return client.IgnoreNotFound(r.Status().Update(ctx, access))
+}
+
+// revoke removes the granted binding, if any. A managed folder which no longer exists has no binding to revoke.
+func (r *FolderAccessReconciler) revoke(ctx context.Context, access *csfov1alpha1.FolderAccess) error {
+	binding := access.Status.Binding
+	if binding == nil {
+		return nil
+	}
+	err := r.gcpClient.RevokeRoleOnFolder(ctx, binding.Folder, binding.Bucket, binding.Role, binding.Member)
+	if err != nil && retry.Reason(err) != retry.ReasonNotFound {
+		return err
+	}
+	log.FromContext(ctx).Info("revoked folder access", "folder", binding.Folder, "role", binding.Role, "member", binding.Member)
+
+	access.Status.Binding = nil
+	return client.IgnoreNotFound(r.Status().Update(ctx, access))
+}
+
+// reconcileError requeues transient errors, permanent ones are recorded on the Ready condition
+// and only retried after permanentErrorRetryInterval
+func (r *FolderAccessReconciler) reconcileError(ctx context.Context, access *csfov1alpha1.FolderAccess, err error) (ctrl.Result, error) {
+	if !retry.IsPermanent(err) {
+		return ctrl.Result{}, err
+	}
+	log.FromContext(ctx).Error(err, "failed to reconcile folder access")
+	meta.SetStatusCondition(&access.Status.Conditions, metav1.Condition{
+		Type:    csfov1alpha1.ConditionReady,
+		Status:  metav1.ConditionFalse,
+		Reason:  retry.Reason(err),
+		Message: err.Error(),
+	})
+	if err := r.Status().Update(ctx, access); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	return ctrl.Result{RequeueAfter: permanentErrorRetryInterval}, nil
+}
+
+func folderKey(access *csfov1alpha1.FolderAccess) types.NamespacedName {
+	return types.NamespacedName{Name: access.Spec.FolderRef.Name, Namespace: access.Spec.FolderRef.Namespace}
+}
+
+func indexFolderRef(obj client.Object) []string {
+	return []string{folderKey(obj.(*csfov1alpha1.FolderAccess)).String()}
+}
+
+// accessesForFolder requeues the accesses of a Folder, e.g. once it is shared or unshared
+func (r *FolderAccessReconciler) accessesForFolder(ctx context.Context, folder client.Object) []reconcile.Request {
+	var accesses csfov1alpha1.FolderAccessList
+	err := r.List(ctx, &accesses, client.MatchingFields{folderRefIndex: client.ObjectKeyFromObject(folder).String()})
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to list accesses of folder", "folder", folder.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(accesses.Items))
+	for _, access := range accesses.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&access)})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *FolderAccessReconciler) SetupWithManager(mgr ctrl.Manager, gcpProjectID string) error {
+	ctx := context.Background()
+	gcpClient, err := gcp.NewGCPClient(ctx, gcpProjectID)
+	if err != nil {
+		return fmt.Errorf("could not create GCP client: %w", err)
+	}
+	r.gcpClient = gcpClient
+
+	err = mgr.GetFieldIndexer().IndexField(ctx, &csfov1alpha1.FolderAccess{}, folderRefIndex, indexFolderRef)
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&csfov1alpha1.FolderAccess{}).
+		Owns(&corev1.ServiceAccount{}).
+		Watches(&csfov1alpha1.Folder{}, handler.EnqueueRequestsFromMapFunc(r.accessesForFolder)).
+		Complete(r)
+}
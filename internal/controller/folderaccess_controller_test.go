@@ -0,0 +1,183 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+)
+
+var _ = Describe("FolderAccess Controller", func() {
+	Context("When reconciling a resource", func() {
+		const resourceName = "test-access"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			By("creating the custom resource for the Kind FolderAccess")
+			access := &csfov1alpha1.FolderAccess{}
+			err := k8sClient.Get(ctx, typeNamespacedName, access)
+			if err != nil && errors.IsNotFound(err) {
+				resource := &csfov1alpha1.FolderAccess{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      resourceName,
+						Namespace: "default",
+					},
+					Spec: csfov1alpha1.FolderAccessSpec{
+						FolderRef: csfov1alpha1.FolderReference{Name: "missing", Namespace: "kube-public"},
+					},
+				}
+				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			resource := &csfov1alpha1.FolderAccess{}
+			err := k8sClient.Get(ctx, typeNamespacedName, resource)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Cleanup the specific resource instance FolderAccess")
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+			controllerReconciler := &FolderAccessReconciler{Client: k8sClient, Scheme: k8sClient.Scheme()}
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should not accept an access to a missing Folder", func() {
+			By("Reconciling the created resource")
+			controllerReconciler := &FolderAccessReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			access := &csfov1alpha1.FolderAccess{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, access)).To(Succeed())
+			accepted := meta.FindStatusCondition(access.Status.Conditions, csfov1alpha1.ConditionAccepted)
+			Expect(accepted).NotTo(BeNil())
+			Expect(accepted.Status).To(Equal(metav1.ConditionFalse))
+			Expect(accepted.Reason).To(Equal(csfov1alpha1.ReasonFolderNotReady))
+			Expect(access.Status.Binding).To(BeNil())
+		})
+	})
+})
+
+var _ = Describe("FolderAccess acceptance", func() {
+	ctx := context.Background()
+	const reader = "reader@other-project.iam.gserviceaccount.com"
+
+	var controllerReconciler *FolderAccessReconciler
+	access := func(role, serviceAccountEmail string) *csfov1alpha1.FolderAccess {
+		return &csfov1alpha1.FolderAccess{
+			ObjectMeta: metav1.ObjectMeta{Name: "team-a-data", Namespace: "team-b"},
+			Spec: csfov1alpha1.FolderAccessSpec{
+				FolderRef:           csfov1alpha1.FolderReference{Name: "data", Namespace: "team-a"},
+				Role:                role,
+				ServiceAccountEmail: serviceAccountEmail,
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		// Without a GCP client these specs fail on any call to GCP
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme.Scheme).
+			WithStatusSubresource(&csfov1alpha1.FolderAccess{}).
+			WithObjects(&csfov1alpha1.Folder{
+				ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "team-a"},
+				Spec: csfov1alpha1.FolderSpec{
+					BucketName: "bucket",
+					Name:       "team-a/data",
+					SharedWith: []csfov1alpha1.FolderShare{{
+						Namespace:       "team-b",
+						Roles:           []string{"roles/storage.objectViewer"},
+						ServiceAccounts: []string{reader},
+					}},
+				},
+				Status: csfov1alpha1.FolderStatus{Folder: "team-a/data/"},
+			}).Build()
+		controllerReconciler = &FolderAccessReconciler{Client: fakeClient, Scheme: scheme.Scheme}
+	})
+
+	It("should accept the shared roles only", func() {
+		folder, accepted, err := controllerReconciler.accept(ctx, access("roles/storage.objectViewer", ""))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(accepted.Status).To(Equal(metav1.ConditionTrue))
+		Expect(folder.Status.Folder).To(Equal("team-a/data/"))
+
+		_, accepted, err = controllerReconciler.accept(ctx, access("roles/storage.objectUser", ""))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(accepted.Reason).To(Equal(csfov1alpha1.ReasonNotShared))
+	})
+
+	It("should bind only the shared service accounts", func() {
+		_, accepted, err := controllerReconciler.accept(ctx, access("roles/storage.objectViewer", reader))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(accepted.Status).To(Equal(metav1.ConditionTrue))
+
+		_, accepted, err = controllerReconciler.accept(ctx,
+			access("roles/storage.objectViewer", "attacker@foreign-project.iam.gserviceaccount.com"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(accepted.Status).To(Equal(metav1.ConditionFalse))
+		Expect(accepted.Reason).To(Equal(csfov1alpha1.ReasonNotShared))
+		Expect(accepted.Message).To(ContainSubstring("attacker@foreign-project"))
+	})
+
+	It("should keep the service account of the spec", func() {
+		existing := access("roles/storage.objectViewer", reader)
+		existing.Status.Email = reader
+		Expect(controllerReconciler.Create(ctx, existing)).To(Succeed())
+		Expect(controllerReconciler.deleteConsumerServiceAccount(ctx, existing)).To(Succeed())
+		Expect(existing.Status.Email).To(Equal(reader))
+	})
+
+	It("should record the owner in the description of the service account", func() {
+		Expect(folderAccessOwner(access("", ""))).To(Equal("FolderAccess team-b/team-a-data"))
+	})
+
+	It("should remove the finalizer of a deleted access without service account", func() {
+		deleted := access("roles/storage.objectViewer", "")
+		deleted.Finalizers = []string{folderAccessFinalizer}
+		Expect(controllerReconciler.Create(ctx, deleted)).To(Succeed())
+		Expect(controllerReconciler.Delete(ctx, deleted)).To(Succeed())
+
+		_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(deleted)})
+		Expect(err).NotTo(HaveOccurred())
+		err = controllerReconciler.Get(ctx, client.ObjectKeyFromObject(deleted), deleted)
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+})
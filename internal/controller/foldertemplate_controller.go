@@ -0,0 +1,170 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/resources"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/tracing"
+)
+
+// FolderTemplateReconciler creates a Folder in every namespace selected by a FolderTemplate
+type FolderTemplateReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=csfo.sijoma.dev,resources=foldertemplates,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=csfo.sijoma.dev,resources=foldertemplates/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=csfo.sijoma.dev,resources=foldertemplates/finalizers,verbs=update
+
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+
+// Reconcile creates or updates the Folders of the selected namespaces and deletes the Folders of namespaces
+// which are no longer selected. Folders of deleted namespaces go with their namespace, all Folders of a
+// deleted template are garbage collected.
+func (r *FolderTemplateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("foldertemplate", req.Name)
+	logger.Info("reconciling started")
+
+	ctx, span := tracing.Tracer().Start(ctx, "FolderTemplate.Reconcile", trace.WithAttributes(
+		attribute.String("name", req.Name),
+	))
+	defer span.End()
+
+	folderTemplate := new(csfov1alpha1.FolderTemplate)
+	if err := r.Get(ctx, req.NamespacedName, folderTemplate); err != nil {
+		// do not requeue "not found" errors
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(&folderTemplate.Spec.NamespaceSelector)
+	if err != nil {
+		return ctrl.Result{}, r.setReady(ctx, folderTemplate, metav1.ConditionFalse, csfov1alpha1.ReasonInvalidTemplate, err.Error())
+	}
+	var namespaces corev1.NamespaceList
+	if err := r.List(ctx, &namespaces, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// A namespace failing, e.g. denied by the webhook, is recorded and does not hold up the other namespaces
+	selected := map[string]bool{}
+	var invalid, conflicts, failed []error
+	for _, namespace := range namespaces.Items {
+		if namespace.DeletionTimestamp != nil {
+			continue
+		}
+		selected[namespace.Name] = true
+		_, err := resources.TemplatedFolder(ctx, r.Client, folderTemplate, &namespace)
+		switch {
+		case errors.Is(err, resources.ErrInvalidTemplate):
+			logger.Info("skipping namespace failing the template", "namespace", namespace.Name, "error", err.Error())
+			invalid = append(invalid, err)
+		case errors.Is(err, resources.ErrFolderConflict):
+			logger.Info("skipping namespace with conflicting folder", "namespace", namespace.Name)
+			conflicts = append(conflicts, err)
+		case err != nil:
+			logger.Error(err, "failed to provision the folder of namespace", "namespace", namespace.Name)
+			failed = append(failed, fmt.Errorf("namespace %s: %w", namespace.Name, err))
+		}
+	}
+
+	var folders csfov1alpha1.FolderList
+	if err := r.List(ctx, &folders, client.MatchingLabels{resources.FolderTemplateLabel: folderTemplate.Name}); err != nil {
+		return ctrl.Result{}, err
+	}
+	provisioned := 0
+	for _, folderCR := range folders.Items {
+		if !metav1.IsControlledBy(&folderCR, folderTemplate) {
+			continue
+		}
+		if selected[folderCR.Namespace] {
+			provisioned++
+			continue
+		}
+		logger.Info("deleting folder of unselected namespace", "namespace", folderCR.Namespace, "folder", folderCR.Name)
+		if err := r.Delete(ctx, &folderCR); client.IgnoreNotFound(err) != nil {
+			failed = append(failed, fmt.Errorf("namespace %s: %w", folderCR.Namespace, err))
+		}
+	}
+
+	folderTemplate.Status.Folders = provisioned
+	switch {
+	case len(failed) > 0:
+		// Retried with backoff, the other namespaces are provisioned meanwhile
+		err := r.setReady(ctx, folderTemplate, metav1.ConditionFalse, csfov1alpha1.ReasonFolderFailed, errors.Join(failed...).Error())
+		return ctrl.Result{Requeue: true}, err
+	case len(invalid) > 0:
+		return ctrl.Result{}, r.setReady(ctx, folderTemplate, metav1.ConditionFalse, csfov1alpha1.ReasonInvalidTemplate, errors.Join(invalid...).Error())
+	case len(conflicts) > 0:
+		return ctrl.Result{}, r.setReady(ctx, folderTemplate, metav1.ConditionFalse, csfov1alpha1.ReasonFolderConflict, errors.Join(conflicts...).Error())
+	}
+	return ctrl.Result{}, r.setReady(ctx, folderTemplate, metav1.ConditionTrue, csfov1alpha1.ReasonReconciled, "")
+}
+
+// setReady records the Ready condition along with the status, a template deleted in the meantime is ignored
+func (r *FolderTemplateReconciler) setReady(ctx context.Context, folderTemplate *csfov1alpha1.FolderTemplate, status metav1.ConditionStatus, reason, message string) error {
+	meta.SetStatusCondition(&folderTemplate.Status.Conditions, metav1.Condition{
+		Type:               csfov1alpha1.ConditionReady,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: folderTemplate.Generation,
+	})
+	return client.IgnoreNotFound(r.Status().Update(ctx, folderTemplate))
+}
+
+// templatesForNamespace requeues all templates whenever a namespace is created, relabeled or deleted
+func (r *FolderTemplateReconciler) templatesForNamespace(ctx context.Context, _ client.Object) []reconcile.Request {
+	var templates csfov1alpha1.FolderTemplateList
+	if err := r.List(ctx, &templates); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list folder templates of namespace")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(templates.Items))
+	for _, folderTemplate := range templates.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&folderTemplate)})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *FolderTemplateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&csfov1alpha1.FolderTemplate{}).
+		// Folders deleted or changed by hand are restored
+		Owns(&csfov1alpha1.Folder{}).
+		Watches(&corev1.Namespace{}, handler.EnqueueRequestsFromMapFunc(r.templatesForNamespace)).
+		Complete(r)
+}
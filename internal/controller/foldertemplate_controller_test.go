@@ -0,0 +1,182 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/resources"
+)
+
+var _ = Describe("FolderTemplate Controller", func() {
+	Context("When reconciling a resource", func() {
+		const resourceName = "tenants"
+		const namespaceName = "tenant-a"
+
+		ctx := context.Background()
+		templateName := types.NamespacedName{Name: resourceName}
+		folderName := types.NamespacedName{Name: "storage", Namespace: namespaceName}
+
+		BeforeEach(func() {
+			By("creating a tenant namespace")
+			namespace := &corev1.Namespace{}
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: namespaceName}, namespace)
+			if err != nil && errors.IsNotFound(err) {
+				namespace = &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+					Name:   namespaceName,
+					Labels: map[string]string{"tenant": "true"},
+				}}
+				Expect(k8sClient.Create(ctx, namespace)).To(Succeed())
+			}
+
+			By("creating the custom resource for the Kind FolderTemplate")
+			folderTemplate := &csfov1alpha1.FolderTemplate{}
+			err = k8sClient.Get(ctx, templateName, folderTemplate)
+			if err != nil && errors.IsNotFound(err) {
+				resource := &csfov1alpha1.FolderTemplate{
+					ObjectMeta: metav1.ObjectMeta{Name: resourceName},
+					Spec: csfov1alpha1.FolderTemplateSpec{
+						NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "true"}},
+						FolderName:        "storage",
+						Folder: csfov1alpha1.FolderSpec{
+							BucketName: "my-bucket",
+							Name:       "tenants/{{.Namespace}}",
+						},
+					},
+				}
+				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			resource := &csfov1alpha1.FolderTemplate{}
+			err := k8sClient.Get(ctx, templateName, resource)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Cleanup the specific resource instance FolderTemplate")
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+		})
+
+		It("should create and delete the Folders of the selected namespaces", func() {
+			controllerReconciler := &FolderTemplateReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			By("Reconciling the created resource")
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: templateName})
+			Expect(err).NotTo(HaveOccurred())
+
+			folder := &csfov1alpha1.Folder{}
+			Expect(k8sClient.Get(ctx, folderName, folder)).To(Succeed())
+			Expect(folder.Spec.BucketName).To(Equal("my-bucket"))
+			Expect(folder.Spec.Name).To(Equal("tenants/tenant-a"))
+
+			folderTemplate := &csfov1alpha1.FolderTemplate{}
+			Expect(k8sClient.Get(ctx, templateName, folderTemplate)).To(Succeed())
+			Expect(folderTemplate.Status.Folders).To(Equal(1))
+			Expect(meta.IsStatusConditionTrue(folderTemplate.Status.Conditions, csfov1alpha1.ConditionReady)).To(BeTrue())
+
+			By("Unselecting the namespace")
+			namespace := &corev1.Namespace{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: namespaceName}, namespace)).To(Succeed())
+			namespace.Labels = nil
+			Expect(k8sClient.Update(ctx, namespace)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: templateName})
+			Expect(err).NotTo(HaveOccurred())
+			err = k8sClient.Get(ctx, folderName, folder)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+})
+
+var _ = Describe("FolderTemplate failures", func() {
+	ctx := context.Background()
+
+	It("should provision and delete the other namespaces when one is denied", func() {
+		folderTemplate := &csfov1alpha1.FolderTemplate{
+			ObjectMeta: metav1.ObjectMeta{Name: "tenants", UID: "tenants-uid"},
+			Spec: csfov1alpha1.FolderTemplateSpec{
+				NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "true"}},
+				FolderName:        "storage",
+				Folder:            csfov1alpha1.FolderSpec{BucketName: "my-bucket", Name: "tenants/{{.Namespace}}"},
+			},
+		}
+		tenant := func(name string, selected bool) *corev1.Namespace {
+			namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+			if selected {
+				namespace.Labels = map[string]string{"tenant": "true"}
+			}
+			return namespace
+		}
+		unselected := &csfov1alpha1.Folder{ObjectMeta: metav1.ObjectMeta{
+			Name:      "storage",
+			Namespace: "former",
+			Labels:    map[string]string{resources.FolderTemplateLabel: "tenants"},
+		}}
+		Expect(controllerutil.SetControllerReference(folderTemplate, unselected, scheme.Scheme)).To(Succeed())
+
+		// The webhook denies the Folder of namespace denied
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme.Scheme).
+			WithStatusSubresource(&csfov1alpha1.FolderTemplate{}).
+			WithObjects(folderTemplate, tenant("denied", true), tenant("allowed", true), tenant("former", false), unselected).
+			WithInterceptorFuncs(interceptor.Funcs{
+				Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+					if obj.GetNamespace() == "denied" {
+						return errors.NewForbidden(schema.GroupResource{Group: "csfo.sijoma.dev", Resource: "folders"},
+							obj.GetName(), fmt.Errorf("denied by the storage policies"))
+					}
+					return c.Create(ctx, obj, opts...)
+				},
+			}).Build()
+		controllerReconciler := &FolderTemplateReconciler{Client: fakeClient, Scheme: scheme.Scheme}
+
+		result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Name: "tenants"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Requeue).To(BeTrue())
+
+		folder := &csfov1alpha1.Folder{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Name: "storage", Namespace: "allowed"}, folder)).To(Succeed())
+		err = fakeClient.Get(ctx, types.NamespacedName{Name: "storage", Namespace: "former"}, folder)
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Name: "tenants"}, folderTemplate)).To(Succeed())
+		Expect(folderTemplate.Status.Folders).To(Equal(1))
+		ready := meta.FindStatusCondition(folderTemplate.Status.Conditions, csfov1alpha1.ConditionReady)
+		Expect(ready).NotTo(BeNil())
+		Expect(ready.Reason).To(Equal(csfov1alpha1.ReasonFolderFailed))
+		Expect(ready.Message).To(ContainSubstring("namespace denied"))
+	})
+})
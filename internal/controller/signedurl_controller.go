@@ -0,0 +1,355 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/gcs"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/retry"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/resources"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/retrievers"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/tracing"
+)
+
+// defaultSignedURLExpiry is the validity of signed URLs unless the SignedURL sets an expiry
+const defaultSignedURLExpiry = 24 * time.Hour
+
+// errListingTruncated stops the listing of a query once it found more than maxObjects objects
+var errListingTruncated = errors.New("listing truncated")
+
+// SignedURLReconciler signs the URLs of a SignedURL as the service account of its Folder
+// and regenerates them before they expire
+type SignedURLReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Clients list the objects of queries as the service account of the Folder
+	Clients   *gcs.ClientCache
+	gcpClient *gcp.Client
+}
+
+//+kubebuilder:rbac:groups=csfo.sijoma.dev,resources=signedurls,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=csfo.sijoma.dev,resources=signedurls/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=csfo.sijoma.dev,resources=signedurls/finalizers,verbs=update
+
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile signs the URLs whenever the spec changed, the target is missing or the URLs are about to expire
+func (r *SignedURLReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues(
+		"signedurl", req.Name,
+		"namespace", req.Namespace,
+	)
+	logger.Info("reconciling started")
+
+	ctx, span := tracing.Tracer().Start(ctx, "SignedURL.Reconcile", trace.WithAttributes(
+		attribute.String("namespace", req.Namespace),
+		attribute.String("name", req.Name),
+	))
+	defer span.End()
+
+	signedURL := new(csfov1alpha1.SignedURL)
+	if err := r.Get(ctx, req.NamespacedName, signedURL); err != nil {
+		// do not requeue "not found" errors
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	folder, reason, message, err := r.signer(ctx, signedURL)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if folder == nil {
+		logger.Info("signed url can not be signed", "reason", reason, "message", message)
+		return ctrl.Result{}, r.setReady(ctx, signedURL, metav1.ConditionFalse, reason, message)
+	}
+
+	now := time.Now()
+	due, err := r.due(ctx, signedURL, now)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !due {
+		return ctrl.Result{RequeueAfter: signedURL.Status.RenewTime.Sub(now)}, nil
+	}
+
+	// Checked before signing, a Secret or ConfigMap of the user is never overwritten
+	target, targetKey := signedURLTargetKey(signedURL)
+	err = resources.CheckOwned(ctx, r.Client, targetKey, target, signedURL)
+	if errors.Is(err, resources.ErrNotOwned) {
+		return ctrl.Result{}, r.setReady(ctx, signedURL, metav1.ConditionFalse, csfov1alpha1.ReasonNotOwned, err.Error())
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	expiry, renewBefore := signedURLExpiry(signedURL)
+	expires := now.Add(expiry)
+	objects, truncated, err := r.objects(ctx, signedURL, folder)
+	if err != nil {
+		return r.reconcileError(ctx, signedURL, err)
+	}
+
+	method := string(signedURL.Spec.Method)
+	if method == "" {
+		method = string(csfov1alpha1.SignedURLMethodGet)
+	}
+	urls := make(map[string]string, len(objects))
+	for _, object := range objects {
+		urls[object], err = r.gcpClient.SignedURL(ctx, folder.Spec.BucketName, object, method, folder.Status.Email, expires)
+		if err != nil {
+			return r.reconcileError(ctx, signedURL, err)
+		}
+	}
+	encoded, err := json.Marshal(urls)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	data := map[string]string{
+		resources.SignedURLsKey:       string(encoded),
+		resources.SignedURLExpiresKey: expires.UTC().Format(time.RFC3339),
+	}
+	if signedURL.Spec.Object != "" {
+		data[resources.SignedURLKey] = urls[signedURL.Spec.Object]
+	}
+	err = resources.SignedURLTarget(ctx, r.Client, signedURL, signedURL.Spec.Target, signedURL.Namespace, data)
+	if errors.Is(err, resources.ErrNotOwned) {
+		return ctrl.Result{}, r.setReady(ctx, signedURL, metav1.ConditionFalse, csfov1alpha1.ReasonNotOwned, err.Error())
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	logger.Info("signed urls", "urls", len(urls), "expires", expires)
+
+	renewTime := expires.Add(-renewBefore)
+	signedURL.Status.URLs = len(urls)
+	signedURL.Status.Truncated = truncated
+	signedURL.Status.ExpirationTime = &metav1.Time{Time: expires}
+	signedURL.Status.RenewTime = &metav1.Time{Time: renewTime}
+	signedURL.Status.ObservedGeneration = signedURL.Generation
+	err = r.setReady(ctx, signedURL, metav1.ConditionTrue, csfov1alpha1.ReasonReconciled, "")
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: renewTime.Sub(now)}, nil
+}
+
+// signer returns the Folder signing the URLs. Without a ready Folder with a service account, or with objects outside
+// of its managed folder, no Folder but the reason and message of the Ready condition are returned.
+func (r *SignedURLReconciler) signer(ctx context.Context, signedURL *csfov1alpha1.SignedURL) (*csfov1alpha1.Folder, string, string, error) {
+	folder := new(csfov1alpha1.Folder)
+	err := r.Get(ctx, signedURLFolderKey(signedURL), folder)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, "", "", err
+	}
+	if err != nil || folder.Status.Folder == "" {
+		return nil, csfov1alpha1.ReasonFolderNotReady,
+			fmt.Sprintf("folder %s does not exist or is not ready", signedURL.Spec.FolderRef.Name), nil
+	}
+	if folder.Status.Email == "" {
+		return nil, csfov1alpha1.ReasonSigningUnsupported,
+			fmt.Sprintf("folder %s uses the Direct identity mode, it has no service account to sign with", folder.Name), nil
+	}
+	if err := retrievers.AuthorizeFolder(folder, signedURL.Namespace); err != nil {
+		return nil, csfov1alpha1.ReasonImpersonationNotAllowed, err.Error(), nil
+	}
+
+	folderPrefix := strings.TrimSuffix(folder.Spec.Name, "/") + "/"
+	name := signedURL.Spec.Object
+	if signedURL.Spec.Query != nil {
+		name = signedURL.Spec.Query.Prefix
+	}
+	if !strings.HasPrefix(name, folderPrefix) {
+		return nil, csfov1alpha1.ReasonOutsideFolder,
+			fmt.Sprintf("gs://%s/%s is outside of folder gs://%s/%s", folder.Spec.BucketName, name, folder.Spec.BucketName, folderPrefix), nil
+	}
+	return folder, "", "", nil
+}
+
+// due reports whether the URLs need to be signed: for a new generation, once they are about to expire
+// or if their target is gone
+func (r *SignedURLReconciler) due(ctx context.Context, signedURL *csfov1alpha1.SignedURL, now time.Time) (bool, error) {
+	status := signedURL.Status
+	if status.ObservedGeneration != signedURL.Generation || status.RenewTime == nil || !now.Before(status.RenewTime.Time) {
+		return true, nil
+	}
+
+	object, key := signedURLTargetKey(signedURL)
+	err := r.Get(ctx, key, object)
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	return false, err
+}
+
+// signedURLTargetKey returns an empty Secret or ConfigMap of the target and its key
+func signedURLTargetKey(signedURL *csfov1alpha1.SignedURL) (client.Object, types.NamespacedName) {
+	target := signedURL.Spec.Target
+	if target.SecretName != "" {
+		return &corev1.Secret{}, types.NamespacedName{Name: target.SecretName, Namespace: signedURL.Namespace}
+	}
+	return &corev1.ConfigMap{}, types.NamespacedName{Name: target.ConfigMapName, Namespace: signedURL.Namespace}
+}
+
+// objects returns the names of the objects to sign, for a query at most maxObjects of them
+// and whether more objects were listed
+func (r *SignedURLReconciler) objects(ctx context.Context, signedURL *csfov1alpha1.SignedURL, folder *csfov1alpha1.Folder) ([]string, bool, error) {
+	if signedURL.Spec.Query == nil {
+		return []string{signedURL.Spec.Object}, false, nil
+	}
+
+	credentials, err := retrievers.ForFolder(folder, signedURL.Namespace)
+	if err != nil {
+		return nil, false, err
+	}
+	gcsClient, release, err := r.Clients.Get(ctx, credentials.Source, credentials.Version, credentials.Options...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer release()
+
+	maxObjects := signedURL.Spec.MaxObjects
+	if maxObjects <= 0 {
+		maxObjects = 100
+	}
+	var names []string
+	query := storage.Query{Prefix: signedURL.Spec.Query.Prefix, Delimiter: signedURL.Spec.Query.Delimiter}
+	_, err = gcsClient.ListObjects(ctx, folder.Spec.BucketName, query, collectNames(&names, maxObjects))
+	if errors.Is(err, errListingTruncated) {
+		return names, true, nil
+	}
+	return names, false, err
+}
+
+// collectNames returns the listing callback appending the object names to names,
+// it stops the listing with errListingTruncated once more than maxObjects objects were listed
+func collectNames(names *[]string, maxObjects int) func(objects []gcs.Object) error {
+	return func(objects []gcs.Object) error {
+		for _, object := range objects {
+			if len(*names) == maxObjects {
+				return errListingTruncated
+			}
+			*names = append(*names, object.Key)
+		}
+		return nil
+	}
+}
+
+// signedURLExpiry returns the validity of the URLs, capped to the maximum of V4 signed URLs,
+// and how long before they expire they are renewed
+func signedURLExpiry(signedURL *csfov1alpha1.SignedURL) (time.Duration, time.Duration) {
+	expiry := defaultSignedURLExpiry
+	if signedURL.Spec.Expiry != nil && signedURL.Spec.Expiry.Duration > 0 {
+		expiry = min(signedURL.Spec.Expiry.Duration, gcp.MaxSignedURLExpiry)
+	}
+	renewBefore := expiry / 3
+	if signedURL.Spec.RenewBefore != nil && signedURL.Spec.RenewBefore.Duration > 0 && signedURL.Spec.RenewBefore.Duration < expiry {
+		renewBefore = signedURL.Spec.RenewBefore.Duration
+	}
+	return expiry, renewBefore
+}
+
+// setReady records the Ready condition in the status
+func (r *SignedURLReconciler) setReady(ctx context.Context, signedURL *csfov1alpha1.SignedURL, status metav1.ConditionStatus, reason, message string) error {
+	meta.SetStatusCondition(&signedURL.Status.Conditions, metav1.Condition{
+		Type:               csfov1alpha1.ConditionReady,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: signedURL.Generation,
+	})
+	return client.IgnoreNotFound(r.Status().Update(ctx, signedURL))
+}
+
+// reconcileError requeues transient errors, permanent ones are recorded on the Ready condition
+func (r *SignedURLReconciler) reconcileError(ctx context.Context, signedURL *csfov1alpha1.SignedURL, err error) (ctrl.Result, error) {
+	if !retry.IsPermanent(err) {
+		return ctrl.Result{}, err
+	}
+	log.FromContext(ctx).Error(err, "failed to sign urls")
+	return ctrl.Result{}, r.setReady(ctx, signedURL, metav1.ConditionFalse, retry.Reason(err), err.Error())
+}
+
+func signedURLFolderKey(signedURL *csfov1alpha1.SignedURL) types.NamespacedName {
+	return types.NamespacedName{Name: signedURL.Spec.FolderRef.Name, Namespace: signedURL.Namespace}
+}
+
+func indexSignedURLFolder(obj client.Object) []string {
+	return []string{signedURLFolderKey(obj.(*csfov1alpha1.SignedURL)).String()}
+}
+
+// signedURLsForFolder requeues the SignedURLs of a Folder, e.g. once it is ready
+func (r *SignedURLReconciler) signedURLsForFolder(ctx context.Context, folder client.Object) []reconcile.Request {
+	var signedURLs csfov1alpha1.SignedURLList
+	err := r.List(ctx, &signedURLs, client.MatchingFields{folderRefIndex: client.ObjectKeyFromObject(folder).String()})
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to list signed urls of folder", "folder", folder.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(signedURLs.Items))
+	for _, signedURL := range signedURLs.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&signedURL)})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *SignedURLReconciler) SetupWithManager(mgr ctrl.Manager, gcpProjectID string) error {
+	ctx := context.Background()
+	gcpClient, err := gcp.NewGCPClient(ctx, gcpProjectID)
+	if err != nil {
+		return fmt.Errorf("could not create GCP client: %w", err)
+	}
+	r.gcpClient = gcpClient
+
+	err = mgr.GetFieldIndexer().IndexField(ctx, &csfov1alpha1.SignedURL{}, folderRefIndex, indexSignedURLFolder)
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&csfov1alpha1.SignedURL{}).
+		// Secrets are only watched by their metadata, see cmd/main.go
+		Owns(&corev1.Secret{}, builder.OnlyMetadata).
+		Owns(&corev1.ConfigMap{}).
+		Watches(&csfov1alpha1.Folder{}, handler.EnqueueRequestsFromMapFunc(r.signedURLsForFolder)).
+		Complete(r)
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/gcs"
+)
+
+var _ = Describe("SignedURL Controller", func() {
+	Context("When reconciling a resource", func() {
+		const resourceName = "test-signed-url"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			By("creating the custom resource for the Kind SignedURL")
+			signedURL := &csfov1alpha1.SignedURL{}
+			err := k8sClient.Get(ctx, typeNamespacedName, signedURL)
+			if err != nil && errors.IsNotFound(err) {
+				resource := &csfov1alpha1.SignedURL{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      resourceName,
+						Namespace: "default",
+					},
+					Spec: csfov1alpha1.SignedURLSpec{
+						FolderRef: corev1.LocalObjectReference{Name: "missing"},
+						Object:    "missing/report.csv",
+						Target:    csfov1alpha1.SignedURLTarget{SecretName: "report-url"},
+					},
+				}
+				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			resource := &csfov1alpha1.SignedURL{}
+			err := k8sClient.Get(ctx, typeNamespacedName, resource)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Cleanup the specific resource instance SignedURL")
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+		})
+
+		It("should not sign urls for a missing Folder", func() {
+			By("Reconciling the created resource")
+			controllerReconciler := &SignedURLReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			signedURL := &csfov1alpha1.SignedURL{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, signedURL)).To(Succeed())
+			ready := meta.FindStatusCondition(signedURL.Status.Conditions, csfov1alpha1.ConditionReady)
+			Expect(ready).NotTo(BeNil())
+			Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+			Expect(ready.Reason).To(Equal(csfov1alpha1.ReasonFolderNotReady))
+			Expect(signedURL.Status.URLs).To(BeZero())
+		})
+	})
+})
+
+var _ = Describe("SignedURL signing", func() {
+	ctx := context.Background()
+
+	folder := &csfov1alpha1.Folder{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "team-a"},
+		Spec:       csfov1alpha1.FolderSpec{BucketName: "bucket", Name: "teams/team-a"},
+		Status: csfov1alpha1.FolderStatus{
+			Folder: "teams/team-a/",
+			Email:  "data-team-a@project.iam.gserviceaccount.com",
+		},
+	}
+	newSignedURL := func(object string) *csfov1alpha1.SignedURL {
+		return &csfov1alpha1.SignedURL{
+			ObjectMeta: metav1.ObjectMeta{Name: "report", Namespace: "team-a", Generation: 1},
+			Spec: csfov1alpha1.SignedURLSpec{
+				FolderRef: corev1.LocalObjectReference{Name: "data"},
+				Object:    object,
+				Target:    csfov1alpha1.SignedURLTarget{SecretName: "report-url"},
+			},
+		}
+	}
+
+	It("should only sign objects inside the managed folder", func() {
+		controllerReconciler := &SignedURLReconciler{
+			Client: fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(folder.DeepCopy()).Build(),
+		}
+
+		signer, _, _, err := controllerReconciler.signer(ctx, newSignedURL("teams/team-a/report.csv"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(signer).NotTo(BeNil())
+
+		// A sibling folder sharing the prefix is outside
+		for _, object := range []string{"teams/team-ab/report.csv", "teams/team-a", "report.csv"} {
+			signer, reason, _, err := controllerReconciler.signer(ctx, newSignedURL(object))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(signer).To(BeNil())
+			Expect(reason).To(Equal(csfov1alpha1.ReasonOutsideFolder))
+		}
+
+		signedURL := newSignedURL("")
+		signedURL.Spec.Query = &csfov1alpha1.Query{Prefix: "teams/team-b/"}
+		_, reason, _, err := controllerReconciler.signer(ctx, signedURL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reason).To(Equal(csfov1alpha1.ReasonOutsideFolder))
+	})
+
+	It("should sign again for a new generation, before expiry or without target", func() {
+		now := time.Now()
+		signedURL := newSignedURL("teams/team-a/report.csv")
+		controllerReconciler := &SignedURLReconciler{
+			Client: fake.NewClientBuilder().WithScheme(scheme.Scheme).Build(),
+		}
+
+		due, err := controllerReconciler.due(ctx, signedURL, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(due).To(BeTrue())
+
+		signedURL.Status.ObservedGeneration = 1
+		signedURL.Status.RenewTime = &metav1.Time{Time: now.Add(time.Hour)}
+		due, err = controllerReconciler.due(ctx, signedURL, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(due).To(BeTrue(), "the target is missing")
+
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "report-url", Namespace: "team-a"}}
+		Expect(controllerReconciler.Create(ctx, secret)).To(Succeed())
+		due, err = controllerReconciler.due(ctx, signedURL, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(due).To(BeFalse())
+
+		due, err = controllerReconciler.due(ctx, signedURL, now.Add(time.Hour))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(due).To(BeTrue(), "the renew time passed")
+
+		signedURL.Generation = 2
+		due, err = controllerReconciler.due(ctx, signedURL, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(due).To(BeTrue(), "the spec changed")
+	})
+
+	It("should refuse to overwrite a target of the user", func() {
+		signedURL := newSignedURL("teams/team-a/report.csv")
+		signedURL.Spec.Target = csfov1alpha1.SignedURLTarget{ConfigMapName: "settings"}
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme.Scheme).
+			WithStatusSubresource(&csfov1alpha1.SignedURL{}).
+			WithObjects(folder.DeepCopy(), signedURL,
+				&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "team-a"}}).
+			Build()
+
+		// Without a GCP client signing would panic: the target is checked before
+		controllerReconciler := &SignedURLReconciler{Client: fakeClient, Scheme: scheme.Scheme}
+		_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{
+			Name: "report", Namespace: "team-a",
+		}})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Name: "report", Namespace: "team-a"}, signedURL)).To(Succeed())
+		ready := meta.FindStatusCondition(signedURL.Status.Conditions, csfov1alpha1.ConditionReady)
+		Expect(ready).NotTo(BeNil())
+		Expect(ready.Reason).To(Equal(csfov1alpha1.ReasonNotOwned))
+	})
+
+	It("should cap the expiry and renew in time", func() {
+		signedURL := newSignedURL("teams/team-a/report.csv")
+		expiry, renewBefore := signedURLExpiry(signedURL)
+		Expect(expiry).To(Equal(defaultSignedURLExpiry))
+		Expect(renewBefore).To(Equal(defaultSignedURLExpiry / 3))
+
+		signedURL.Spec.Expiry = &metav1.Duration{Duration: 30 * 24 * time.Hour}
+		signedURL.Spec.RenewBefore = &metav1.Duration{Duration: 24 * time.Hour}
+		expiry, renewBefore = signedURLExpiry(signedURL)
+		Expect(expiry).To(Equal(gcp.MaxSignedURLExpiry))
+		Expect(renewBefore).To(Equal(24 * time.Hour))
+
+		// A renewal not before the expiry falls back to a third of it
+		signedURL.Spec.Expiry = &metav1.Duration{Duration: 3 * time.Hour}
+		signedURL.Spec.RenewBefore = &metav1.Duration{Duration: 3 * time.Hour}
+		expiry, renewBefore = signedURLExpiry(signedURL)
+		Expect(expiry).To(Equal(3 * time.Hour))
+		Expect(renewBefore).To(Equal(time.Hour))
+	})
+
+	It("should truncate the listing after the maximum of objects", func() {
+		page := []gcs.Object{{Key: "a"}, {Key: "b"}, {Key: "c"}}
+
+		var names []string
+		collect := collectNames(&names, 3)
+		Expect(collect(page)).To(Succeed())
+		Expect(names).To(Equal([]string{"a", "b", "c"}))
+		Expect(collect([]gcs.Object{{Key: "d"}})).To(MatchError(errListingTruncated))
+		Expect(names).To(HaveLen(3))
+
+		names = nil
+		collect = collectNames(&names, 2)
+		Expect(collect(page)).To(MatchError(errListingTruncated))
+		Expect(names).To(Equal([]string{"a", "b"}))
+	})
+})
@@ -0,0 +1,149 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+)
+
+// namespacePlaceholder in prefix patterns is replaced by the namespace of the checked resource
+const namespacePlaceholder = "{namespace}"
+
+// ErrDenied is returned for accesses none of the StoragePolicies allows
+var ErrDenied = errors.New("denied by storage policies")
+
+// Access is a single use of a bucket by a Folder or a FileTransfer
+type Access struct {
+	Bucket string
+	// Prefix is the accessed object name or prefix
+	Prefix string
+	// Role granted on the prefix, only set for Folders
+	Role string
+	// Mode of the transfer, only set for FileTransfers
+	Mode csfov1alpha1.TransferMode
+}
+
+func (a Access) String() string {
+	s := fmt.Sprintf("gs://%s/%s", a.Bucket, a.Prefix)
+	if a.Role != "" {
+		s += " with role " + a.Role
+	}
+	if a.Mode != "" {
+		s += " in mode " + string(a.Mode)
+	}
+	return s
+}
+
+// FolderAccesses is the managed folder and the role granted on it
+func FolderAccesses(folder *csfov1alpha1.Folder) []Access {
+	role := folder.Spec.Role
+	if role == "" {
+		role = csfov1alpha1.DefaultFolderRole
+	}
+	return []Access{{
+		Bucket: folder.Spec.BucketName,
+		Prefix: strings.TrimSuffix(folder.Spec.Name, "/") + "/",
+		Role:   role,
+	}}
+}
+
+// TransferAccesses are the listed prefix, the copy destination and the manifest object of the transfer
+func TransferAccesses(ft *csfov1alpha1.FileTransfer) []Access {
+	mode := TransferMode(ft)
+	accesses := []Access{{Bucket: ft.Spec.BucketName, Prefix: ft.Spec.Query.Prefix, Mode: mode}}
+	if ft.Spec.CopyDestination != nil {
+		accesses = append(accesses, Access{Bucket: ft.Spec.BucketName, Prefix: ft.Spec.CopyDestination.Prefix, Mode: mode})
+	}
+	if manifest := ft.Spec.Manifest; manifest != nil && manifest.Object != nil {
+		bucket := manifest.Object.Bucket
+		if bucket == "" {
+			bucket = ft.Spec.BucketName
+		}
+		accesses = append(accesses, Access{Bucket: bucket, Prefix: manifest.Object.Name, Mode: mode})
+	}
+	return accesses
+}
+
+// TransferMode derives the mode of a transfer from its spec
+func TransferMode(ft *csfov1alpha1.FileTransfer) csfov1alpha1.TransferMode {
+	switch {
+	case ft.Spec.CopyDestination == nil:
+		return csfov1alpha1.TransferModeList
+	case ft.Spec.DryRun:
+		return csfov1alpha1.TransferModeDryRun
+	default:
+		return csfov1alpha1.TransferModeCopy
+	}
+}
+
+// Check evaluates the accesses of a resource in the namespace against the StoragePolicies of the cluster
+func Check(ctx context.Context, reader client.Reader, namespace string, accesses []Access) error {
+	var policies csfov1alpha1.StoragePolicyList
+	if err := reader.List(ctx, &policies); err != nil {
+		return fmt.Errorf("Check: %w", err)
+	}
+	if len(policies.Items) == 0 {
+		return nil
+	}
+	var ns corev1.Namespace
+	if err := reader.Get(ctx, types.NamespacedName{Name: namespace}, &ns); err != nil {
+		return fmt.Errorf("Check: %w", err)
+	}
+	return Evaluate(policies.Items, &ns, accesses)
+}
+
+// Evaluate returns ErrDenied for the first access none of the policies selecting the namespace allows.
+// Without policies everything is allowed.
+func Evaluate(policies []csfov1alpha1.StoragePolicy, namespace *corev1.Namespace, accesses []Access) error {
+	if len(policies) == 0 {
+		return nil
+	}
+	var selecting []csfov1alpha1.StoragePolicy
+	for _, policy := range policies {
+		selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.NamespaceSelector)
+		if err != nil {
+			return fmt.Errorf("Evaluate: invalid namespace selector of policy %s: %w", policy.Name, err)
+		}
+		if selector.Matches(labels.Set(namespace.Labels)) {
+			selecting = append(selecting, policy)
+		}
+	}
+
+	for _, access := range accesses {
+		allowed := slices.ContainsFunc(selecting, func(policy csfov1alpha1.StoragePolicy) bool {
+			return allows(&policy.Spec, namespace.Name, access)
+		})
+		if !allowed {
+			return fmt.Errorf("%w: namespace %s may not access %s", ErrDenied, namespace.Name, access)
+		}
+	}
+	return nil
+}
+
+func allows(spec *csfov1alpha1.StoragePolicySpec, namespace string, access Access) bool {
+	if !slices.Contains(spec.Buckets, access.Bucket) {
+		return false
+	}
+	if access.Role != "" && len(spec.Roles) > 0 && !slices.Contains(spec.Roles, access.Role) {
+		return false
+	}
+	if access.Mode != "" && len(spec.TransferModes) > 0 && !slices.Contains(spec.TransferModes, access.Mode) {
+		return false
+	}
+	if len(spec.Prefixes) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(spec.Prefixes, func(pattern string) bool {
+		return strings.HasPrefix(access.Prefix, strings.ReplaceAll(pattern, namespacePlaceholder, namespace))
+	})
+}
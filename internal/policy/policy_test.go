@@ -0,0 +1,87 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+)
+
+func TestEvaluate(t *testing.T) {
+	policies := []csfov1alpha1.StoragePolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "tenants"},
+			Spec: csfov1alpha1.StoragePolicySpec{
+				NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "true"}},
+				Buckets:           []string{"shared"},
+				Prefixes:          []string{"teams/{namespace}/"},
+				Roles:             []string{"roles/storage.objectViewer"},
+				TransferModes:     []csfov1alpha1.TransferMode{csfov1alpha1.TransferModeList, csfov1alpha1.TransferModeDryRun},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "platform"},
+			Spec: csfov1alpha1.StoragePolicySpec{
+				NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "platform"}},
+				Buckets:           []string{"shared", "platform"},
+			},
+		},
+	}
+	tenant := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"tenant": "true"}}}
+	platform := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "infra", Labels: map[string]string{"team": "platform"}}}
+	other := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+
+	tests := []struct {
+		name      string
+		namespace *corev1.Namespace
+		access    Access
+		allowed   bool
+	}{
+		{"own prefix", tenant, Access{Bucket: "shared", Prefix: "teams/team-a/raw/", Mode: csfov1alpha1.TransferModeList}, true},
+		{"prefix of another team", tenant, Access{Bucket: "shared", Prefix: "teams/team-b/"}, false},
+		{"whole bucket", tenant, Access{Bucket: "shared", Prefix: ""}, false},
+		{"other bucket", tenant, Access{Bucket: "platform", Prefix: "teams/team-a/"}, false},
+		{"allowed role", tenant, Access{Bucket: "shared", Prefix: "teams/team-a/", Role: "roles/storage.objectViewer"}, true},
+		{"folder admin", tenant, Access{Bucket: "shared", Prefix: "teams/team-a/", Role: "roles/storage.folderAdmin"}, false},
+		{"copy", tenant, Access{Bucket: "shared", Prefix: "teams/team-a/", Mode: csfov1alpha1.TransferModeCopy}, false},
+		{"unrestricted policy", platform, Access{Bucket: "platform", Role: "roles/storage.folderAdmin", Mode: csfov1alpha1.TransferModeCopy}, true},
+		{"not selected", other, Access{Bucket: "shared", Prefix: "teams/other/"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Evaluate(policies, tt.namespace, []Access{tt.access})
+			if tt.allowed && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrDenied) {
+				t.Errorf("expected ErrDenied, got %v", err)
+			}
+		})
+	}
+
+	if err := Evaluate(nil, other, []Access{{Bucket: "anything"}}); err != nil {
+		t.Errorf("expected everything to be allowed without policies, got %v", err)
+	}
+}
+
+func TestTransferAccesses(t *testing.T) {
+	ft := &csfov1alpha1.FileTransfer{Spec: csfov1alpha1.FileTransferSpec{
+		BucketName:      "shared",
+		Query:           csfov1alpha1.Query{Prefix: "teams/team-a/"},
+		CopyDestination: &csfov1alpha1.CopyDestination{Prefix: "teams/team-a/archive/"},
+		DryRun:          true,
+		Manifest:        &csfov1alpha1.Manifest{Object: &csfov1alpha1.ManifestObject{Bucket: "reports", Name: "team-a.jsonl"}},
+	}}
+	accesses := TransferAccesses(ft)
+	if len(accesses) != 3 || accesses[2].Bucket != "reports" || accesses[1].Prefix != "teams/team-a/archive/" {
+		t.Errorf("unexpected accesses %v", accesses)
+	}
+	for _, access := range accesses {
+		if access.Mode != csfov1alpha1.TransferModeDryRun {
+			t.Errorf("expected mode DryRun, got %s", access.Mode)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package transfer
+
+import (
+	"fmt"
+	"strings"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/gcs"
+)
+
+// CheckFolderScope ensures a transfer running as a Folder only touches objects inside the managed folder:
+// the bucket has to be the one of the Folder and every prefix has to be below the folder path.
+func CheckFolderScope(ft *csfov1alpha1.FileTransfer, folder *csfov1alpha1.Folder) error {
+	folderPrefix := strings.TrimSuffix(folder.Spec.Name, "/") + "/"
+	inFolder := func(bucket, name string) bool {
+		return bucket == folder.Spec.BucketName && strings.HasPrefix(name, folderPrefix)
+	}
+
+	if !inFolder(ft.Spec.BucketName, ft.Spec.Query.Prefix) {
+		return fmt.Errorf("query gs://%s/%s is outside of folder gs://%s/%s",
+			ft.Spec.BucketName, ft.Spec.Query.Prefix, folder.Spec.BucketName, folderPrefix)
+	}
+	if ft.Spec.CopyDestination != nil && !inFolder(ft.Spec.BucketName, ft.Spec.CopyDestination.Prefix) {
+		return fmt.Errorf("copy destination gs://%s/%s is outside of folder gs://%s/%s",
+			ft.Spec.BucketName, ft.Spec.CopyDestination.Prefix, folder.Spec.BucketName, folderPrefix)
+	}
+	if manifest := ft.Spec.Manifest; manifest != nil && manifest.Object != nil {
+		bucket := manifest.Object.Bucket
+		if bucket == "" {
+			bucket = ft.Spec.BucketName
+		}
+		if !inFolder(bucket, manifest.Object.Name) {
+			return fmt.Errorf("manifest gs://%s/%s is outside of folder gs://%s/%s",
+				bucket, manifest.Object.Name, folder.Spec.BucketName, folderPrefix)
+		}
+	}
+	return nil
+}
+
+// CheckPrefixes rejects copies into a destination the source query lists again, e.g. a destination
+// below the source prefix. The copied objects would be copied over and over.
+func CheckPrefixes(ft *csfov1alpha1.FileTransfer) error {
+	if ft.Spec.CopyDestination == nil || !gcs.Relists(query(ft), ft.Spec.CopyDestination.Prefix) {
+		return nil
+	}
+	return fmt.Errorf("%w: gs://%s/%s into gs://%s/%s", gcs.ErrOverlappingPrefixes,
+		ft.Spec.BucketName, ft.Spec.Query.Prefix, ft.Spec.BucketName, ft.Spec.CopyDestination.Prefix)
+}
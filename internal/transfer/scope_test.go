@@ -0,0 +1,75 @@
+package transfer
+
+import (
+	"testing"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+)
+
+func TestCheckFolderScope(t *testing.T) {
+	folder := &csfov1alpha1.Folder{Spec: csfov1alpha1.FolderSpec{BucketName: "tenants", Name: "team-a"}}
+
+	tests := []struct {
+		name    string
+		spec    csfov1alpha1.FileTransferSpec
+		allowed bool
+	}{
+		{"inside", csfov1alpha1.FileTransferSpec{
+			BucketName:      "tenants",
+			Query:           csfov1alpha1.Query{Prefix: "team-a/raw/"},
+			CopyDestination: &csfov1alpha1.CopyDestination{Prefix: "team-a/archive/"},
+		}, true},
+		{"other bucket", csfov1alpha1.FileTransferSpec{
+			BucketName: "shared",
+			Query:      csfov1alpha1.Query{Prefix: "team-a/raw/"},
+		}, false},
+		{"sibling folder with the same name prefix", csfov1alpha1.FileTransferSpec{
+			BucketName: "tenants",
+			Query:      csfov1alpha1.Query{Prefix: "team-ab/"},
+		}, false},
+		{"destination outside", csfov1alpha1.FileTransferSpec{
+			BucketName:      "tenants",
+			Query:           csfov1alpha1.Query{Prefix: "team-a/"},
+			CopyDestination: &csfov1alpha1.CopyDestination{Prefix: "team-b/"},
+		}, false},
+		{"manifest outside", csfov1alpha1.FileTransferSpec{
+			BucketName: "tenants",
+			Query:      csfov1alpha1.Query{Prefix: "team-a/"},
+			Manifest:   &csfov1alpha1.Manifest{Object: &csfov1alpha1.ManifestObject{Bucket: "audit", Name: "team-a/m.csv"}},
+		}, false},
+	}
+	for _, tt := range tests {
+		err := CheckFolderScope(&csfov1alpha1.FileTransfer{Spec: tt.spec}, folder)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s: allowed %v, got %v", tt.name, tt.allowed, err)
+		}
+	}
+}
+
+func TestCheckPrefixes(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   csfov1alpha1.Query
+		target  string
+		allowed bool
+	}{
+		{"sibling", csfov1alpha1.Query{Prefix: "raw/"}, "archive/", true},
+		{"below the source", csfov1alpha1.Query{Prefix: "raw/"}, "raw/archive/", false},
+		{"below the source, not listed with a delimiter", csfov1alpha1.Query{Prefix: "raw/", Delimiter: "/"}, "raw/archive/", true},
+		// Copies keep listing with the delimiter when summarizing
+		{"summarized", csfov1alpha1.Query{Prefix: "raw/", Delimiter: "/", Summarize: true}, "raw/archive/", true},
+	}
+	for _, tt := range tests {
+		ft := &csfov1alpha1.FileTransfer{Spec: csfov1alpha1.FileTransferSpec{
+			BucketName:      "bucket",
+			Query:           tt.query,
+			CopyDestination: &csfov1alpha1.CopyDestination{Prefix: tt.target},
+		}}
+		if err := CheckPrefixes(ft); (err == nil) != tt.allowed {
+			t.Errorf("%s: allowed %v, got %v", tt.name, tt.allowed, err)
+		}
+	}
+	if err := CheckPrefixes(&csfov1alpha1.FileTransfer{}); err != nil {
+		t.Errorf("expected listings without a copy destination to be allowed: %v", err)
+	}
+}
@@ -0,0 +1,384 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sretry "k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/gcs"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/retry"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/manifest"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/resources"
+)
+
+const (
+	// progressInterval throttles the status updates while copying
+	progressInterval = 10 * time.Second
+	// maxSummaryPrefixes bounds the sub-prefixes reported in the status
+	maxSummaryPrefixes = 100
+	// maxStatusFailures bounds the failed objects reported in the status
+	maxStatusFailures = 10
+)
+
+// Run copies the objects matched by the FileTransfer and records the progress on its status.
+// Without a copy destination the objects are only counted.
+// Failures are returned to the caller which decides whether the transfer is retried.
+func Run(ctx context.Context, c client.Client, gcsClient *gcs.StorageClient, ft *csfov1alpha1.FileTransfer) error {
+	logger := log.FromContext(ctx)
+	gcsQuery := query(ft)
+
+	sink, err := openManifest(ctx, c, gcsClient, ft)
+	if err != nil {
+		return err
+	}
+	summarizer := newSummarizer(ft)
+	onObjects := func(objects []gcs.Object) error {
+		if summarizer != nil {
+			summarizer.Add(objects)
+		}
+		return sink.write(objects)
+	}
+
+	if ft.Spec.CopyDestination == nil {
+		found, err := gcsClient.ListObjects(ctx, ft.Spec.BucketName, gcsQuery, onObjects)
+		if err != nil {
+			sink.abort()
+			return err
+		}
+		location, err := sink.close()
+		if err != nil {
+			return err
+		}
+		listed := ft.Status.DeepCopy()
+		listed.FoundObjects = found
+		listed.Manifest = location
+		setSummary(listed, summarizer)
+		if equality.Semantic.DeepEqual(listed, &ft.Status) {
+			return nil
+		}
+		logger.Info("found objects", "objectsFound", found)
+		return UpdateStatus(ctx, c, ft, func(status *csfov1alpha1.FileTransferStatus) {
+			status.FoundObjects = found
+			status.Manifest = location
+			setSummary(status, summarizer)
+		})
+	}
+
+	if ft.Spec.DryRun {
+		return plan(ctx, c, gcsClient, ft, gcsQuery, sink, summarizer, onObjects)
+	}
+
+	err = UpdateStatus(ctx, c, ft, func(status *csfov1alpha1.FileTransferStatus) {
+		status.CopyStatus = csfov1alpha1.CopyStatusRunning
+		// Set by the TTL of a previous dry run
+		status.CompletionTime = nil
+	})
+	if err != nil {
+		sink.abort()
+		return err
+	}
+
+	// A stopped copy resumes after the objects it already copied
+	gcsQuery.StartOffset = ft.Status.ResumeFrom
+	copiedBefore, failedBefore, failures := ft.Status.CopiedObjects, ft.Status.FailedObjects, ft.Status.Failures
+	if ft.Status.ResumeFrom == "" {
+		copiedBefore, failedBefore, failures = 0, 0, nil
+	}
+	if gcsQuery.StartOffset != "" {
+		logger.Info("resuming copy", "resumeFrom", gcsQuery.StartOffset, "copiedObjects", copiedBefore)
+		if summarizer != nil {
+			summarizer.Continue(summaryFromStatus(ft.Status.Summary), ft.Status.SummaryTruncated)
+		}
+	}
+
+	var progress gcs.Progress
+	lastUpdate := time.Now()
+	recordProgress := func(ctx context.Context) error {
+		return UpdateStatus(ctx, c, ft, func(status *csfov1alpha1.FileTransferStatus) {
+			status.FoundObjects = copiedBefore + failedBefore + progress.Found
+			status.CopiedObjects = copiedBefore + progress.Copied
+			status.ResumeFrom = progress.Next
+			status.FailedObjects = failedBefore + progress.Failed
+			status.Failures = failures
+			setSummary(status, summarizer)
+		})
+	}
+	opts := gcs.CopyOptions{
+		Verify: ft.Spec.Verify,
+		OnObjects: func(objects []gcs.Object) error {
+			for _, object := range objects {
+				if object.Result == gcs.CopyResultFailed && len(failures) < maxStatusFailures {
+					failures = append(failures, csfov1alpha1.ObjectFailure{Key: object.Key, Error: object.Error})
+				}
+			}
+			return onObjects(objects)
+		},
+		OnProgress: func(ctx context.Context, p gcs.Progress) {
+			progress = p
+			if time.Since(lastUpdate) < progressInterval {
+				return
+			}
+			lastUpdate = time.Now()
+			if err := recordProgress(ctx); err != nil {
+				logger.Error(err, "failed to record progress")
+			}
+		},
+	}
+	progress, err = gcsClient.CopyFiles(ctx, ft.Spec.BucketName, gcsQuery, ft.Spec.CopyDestination.Prefix, opts)
+	if err != nil {
+		sink.abort()
+		// A stopped copy records how far it got, the context is already cancelled
+		if ctx.Err() != nil && progress.Next != "" {
+			logger.Info("copy stopped", "resumeFrom", progress.Next)
+			if err := recordProgress(context.WithoutCancel(ctx)); err != nil {
+				logger.Error(err, "failed to record progress")
+			}
+		}
+		return err
+	}
+	copyStatus := csfov1alpha1.CopyStatusDone
+	if failedBefore+progress.Failed > 0 {
+		copyStatus = csfov1alpha1.CopyStatusFailed
+		logger.Info("copy finished with failed objects", "copiedObjects", copiedBefore+progress.Copied,
+			"failedObjects", failedBefore+progress.Failed)
+	} else {
+		logger.Info("successfully copied files", "copiedObjects", copiedBefore+progress.Copied)
+	}
+
+	location, err := sink.close()
+	if err != nil {
+		return err
+	}
+
+	return UpdateStatus(ctx, c, ft, func(status *csfov1alpha1.FileTransferStatus) {
+		status.FoundObjects = copiedBefore + failedBefore + progress.Found
+		status.CopiedObjects = copiedBefore + progress.Copied
+		status.ResumeFrom = ""
+		status.FailedObjects = failedBefore + progress.Failed
+		status.Failures = failures
+		status.CopyStatus = copyStatus
+		if copyStatus == csfov1alpha1.CopyStatusFailed {
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type:    csfov1alpha1.ConditionFailed,
+				Status:  metav1.ConditionTrue,
+				Reason:  csfov1alpha1.ReasonObjectsFailed,
+				Message: fmt.Sprintf("%d objects could not be copied", status.FailedObjects),
+			})
+		}
+		status.Manifest = location
+		setSummary(status, summarizer)
+	})
+}
+
+// plan computes what a copy would do without writing any object
+func plan(ctx context.Context, c client.Client, gcsClient *gcs.StorageClient,
+	ft *csfov1alpha1.FileTransfer, gcsQuery storage.Query, sink *manifestSink,
+	summarizer *gcs.Summarizer, onObjects func(objects []gcs.Object) error,
+) error {
+	opts := gcs.CopyOptions{DryRun: true, OnObjects: onObjects}
+	progress, err := gcsClient.CopyFiles(ctx, ft.Spec.BucketName, gcsQuery, ft.Spec.CopyDestination.Prefix, opts)
+	if err != nil {
+		sink.abort()
+		return err
+	}
+	summary := progress.Plan
+	log.FromContext(ctx).Info("planned copy", "copy", summary.Copy, "overwrite", summary.Overwrite,
+		"skip", summary.Skip, "bytes", summary.Bytes)
+
+	location, err := sink.close()
+	if err != nil {
+		return err
+	}
+
+	return UpdateStatus(ctx, c, ft, func(status *csfov1alpha1.FileTransferStatus) {
+		status.FoundObjects = progress.Found
+		status.CopyStatus = csfov1alpha1.CopyStatusPlanned
+		status.Manifest = location
+		setSummary(status, summarizer)
+		status.Plan = &csfov1alpha1.TransferPlan{
+			Copy:       summary.Copy,
+			Overwrite:  summary.Overwrite,
+			Skip:       summary.Skip,
+			TotalBytes: summary.Bytes,
+		}
+	})
+}
+
+// query is the listing of the FileTransfer.
+// A summarizing listing needs every object below the prefix, the delimiter then only groups the summary.
+// Copies and dry runs always list with the delimiter, their summary covers the copied objects.
+func query(ft *csfov1alpha1.FileTransfer) storage.Query {
+	gcsQuery := storage.Query{Prefix: ft.Spec.Query.Prefix, Delimiter: ft.Spec.Query.Delimiter}
+	if ft.Spec.Query.Summarize && ft.Spec.CopyDestination == nil {
+		gcsQuery.Delimiter = ""
+	}
+	return gcsQuery
+}
+
+// newSummarizer returns nil unless a summary is requested
+func newSummarizer(ft *csfov1alpha1.FileTransfer) *gcs.Summarizer {
+	if !ft.Spec.Query.Summarize {
+		return nil
+	}
+	return gcs.NewSummarizer(ft.Spec.Query.Prefix, ft.Spec.Query.Delimiter, maxSummaryPrefixes)
+}
+
+// setSummary records the summary on the status, a nil summarizer clears it
+func setSummary(status *csfov1alpha1.FileTransferStatus, summarizer *gcs.Summarizer) {
+	status.Summary = nil
+	status.SummaryTruncated = false
+	if summarizer == nil {
+		return
+	}
+	for _, summary := range summarizer.Summaries() {
+		status.Summary = append(status.Summary, csfov1alpha1.PrefixSummary{
+			Prefix:  summary.Prefix,
+			Objects: summary.Objects,
+			Bytes:   summary.Bytes,
+		})
+	}
+	status.SummaryTruncated = summarizer.Truncated()
+}
+
+func summaryFromStatus(summaries []csfov1alpha1.PrefixSummary) []gcs.PrefixSummary {
+	var previous []gcs.PrefixSummary
+	for _, summary := range summaries {
+		previous = append(previous, gcs.PrefixSummary{
+			Prefix:  summary.Prefix,
+			Objects: summary.Objects,
+			Bytes:   summary.Bytes,
+		})
+	}
+	return previous
+}
+
+// manifestSink streams the manifest rows to the requested target while the objects are listed.
+// A nil sink discards the rows, so transfers without a manifest need no special handling.
+type manifestSink struct {
+	writer *manifest.Writer
+	// finish completes the upload and returns the location of the manifest
+	finish func() (string, error)
+	// cancel discards a partially written manifest
+	cancel func()
+}
+
+// openManifest starts the manifest if one is requested.
+// Manifest objects are uploaded while streaming, ConfigMaps are limited in size anyway and written at the end.
+func openManifest(ctx context.Context, c client.Client, gcsClient *gcs.StorageClient,
+	ft *csfov1alpha1.FileTransfer,
+) (*manifestSink, error) {
+	spec := ft.Spec.Manifest
+	if spec == nil {
+		return nil, nil
+	}
+
+	if spec.Object != nil {
+		bucket := spec.Object.Bucket
+		if bucket == "" {
+			bucket = ft.Spec.BucketName
+		}
+		uploadCtx, cancel := context.WithCancel(ctx)
+		upload := gcsClient.NewObjectWriter(uploadCtx, bucket, spec.Object.Name, manifest.ContentType(spec.Format))
+		writer := manifest.NewWriter(upload, spec.Format)
+		return &manifestSink{
+			writer: writer,
+			finish: func() (string, error) {
+				defer cancel()
+				if err := writer.Flush(); err != nil {
+					return "", err
+				}
+				if err := upload.Close(); err != nil {
+					return "", fmt.Errorf("openManifest: %w", err)
+				}
+				return fmt.Sprintf("gs://%s/%s", bucket, spec.Object.Name), nil
+			},
+			cancel: cancel,
+		}, nil
+	}
+
+	var content bytes.Buffer
+	writer := manifest.NewWriter(&content, spec.Format)
+	return &manifestSink{
+		writer: writer,
+		finish: func() (string, error) {
+			if err := writer.Flush(); err != nil {
+				return "", err
+			}
+			configMap, err := resources.ManifestConfigMap(ctx, c, ft, spec.ConfigMap, manifest.FileName(spec.Format), content.Bytes())
+			if err != nil {
+				return "", err
+			}
+			return "configmap/" + configMap.Name, nil
+		},
+		cancel: func() {},
+	}, nil
+}
+
+func (s *manifestSink) write(objects []gcs.Object) error {
+	if s == nil {
+		return nil
+	}
+	return s.writer.Write(objects)
+}
+
+// close finishes the manifest and returns its location, empty without a manifest
+func (s *manifestSink) close() (string, error) {
+	if s == nil {
+		return "", nil
+	}
+	return s.finish()
+}
+
+func (s *manifestSink) abort() {
+	if s != nil {
+		s.cancel()
+	}
+}
+
+// IsPermanent reports whether a transfer failed for good, on permanent GCP errors or a manifest ConfigMap
+// of the same name the transfer does not own
+func IsPermanent(err error) bool {
+	return retry.IsPermanent(err) || errors.Is(err, resources.ErrNotOwned)
+}
+
+// MarkFailed records a permanent error, e.g. missing permissions or a missing bucket. The transfer is not retried.
+func MarkFailed(ctx context.Context, c client.Client, ft *csfov1alpha1.FileTransfer, cause error) error {
+	reason := retry.Reason(cause)
+	if errors.Is(cause, resources.ErrNotOwned) {
+		reason = csfov1alpha1.ReasonNotOwned
+	}
+	return UpdateStatus(ctx, c, ft, func(status *csfov1alpha1.FileTransferStatus) {
+		status.CopyStatus = csfov1alpha1.CopyStatusFailed
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    csfov1alpha1.ConditionFailed,
+			Status:  metav1.ConditionTrue,
+			Reason:  reason,
+			Message: cause.Error(),
+		})
+	})
+}
+
+// UpdateStatus applies mutate on the latest version of the FileTransfer status, retrying on conflicts.
+// ft is updated in place with the stored object.
+func UpdateStatus(ctx context.Context, c client.Client, ft *csfov1alpha1.FileTransfer,
+	mutate func(status *csfov1alpha1.FileTransferStatus),
+) error {
+	return k8sretry.RetryOnConflict(k8sretry.DefaultRetry, func() error {
+		if err := c.Get(ctx, client.ObjectKeyFromObject(ft), ft); err != nil {
+			return err
+		}
+		mutate(&ft.Status)
+		return c.Status().Update(ctx, ft)
+	})
+}
@@ -0,0 +1,37 @@
+package transfer
+
+import (
+	"testing"
+
+	"cloud.google.com/go/storage"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+)
+
+func TestQuery(t *testing.T) {
+	summarized := csfov1alpha1.Query{Prefix: "raw/", Delimiter: "/", Summarize: true}
+	destination := &csfov1alpha1.CopyDestination{Prefix: "archive/"}
+
+	tests := []struct {
+		name string
+		spec csfov1alpha1.FileTransferSpec
+		want storage.Query
+	}{
+		{"listing", csfov1alpha1.FileTransferSpec{Query: csfov1alpha1.Query{Prefix: "raw/", Delimiter: "/"}},
+			storage.Query{Prefix: "raw/", Delimiter: "/"}},
+		// The summary needs every object below the prefix
+		{"summarized listing", csfov1alpha1.FileTransferSpec{Query: summarized},
+			storage.Query{Prefix: "raw/"}},
+		{"summarized copy", csfov1alpha1.FileTransferSpec{Query: summarized, CopyDestination: destination},
+			storage.Query{Prefix: "raw/", Delimiter: "/"}},
+		{"summarized dry run", csfov1alpha1.FileTransferSpec{Query: summarized, CopyDestination: destination, DryRun: true},
+			storage.Query{Prefix: "raw/", Delimiter: "/"}},
+	}
+	for _, tt := range tests {
+		got := query(&csfov1alpha1.FileTransfer{Spec: tt.spec})
+		if got.Prefix != tt.want.Prefix || got.Delimiter != tt.want.Delimiter {
+			t.Errorf("%s: got prefix %q delimiter %q, want %q %q", tt.name, got.Prefix, got.Delimiter,
+				tt.want.Prefix, tt.want.Delimiter)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/internal/policy"
+	"github.com/sijoma/cloud-storage-file-operator/internal/transfer"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/retrievers"
+)
+
+var filetransferlog = logf.Log.WithName("filetransfer-webhook")
+
+// SetupFileTransferWebhookWithManager registers the validating webhook of FileTransfers
+func SetupFileTransferWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&csfov1alpha1.FileTransfer{}).
+		WithValidator(&FileTransferCustomValidator{Client: mgr.GetClient()}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-csfo-sijoma-dev-v1alpha1-filetransfer,mutating=false,failurePolicy=fail,sideEffects=None,groups=csfo.sijoma.dev,resources=filetransfers,verbs=create;update,versions=v1alpha1,name=vfiletransfer.kb.io,admissionReviewVersions=v1
+
+// FileTransferCustomValidator rejects FileTransfers referencing secrets of other namespaces not shared with them,
+// FileTransfers accessing buckets, prefixes or modes the StoragePolicies do not allow,
+// copies into a destination their query lists again and impersonation with the credentials of the operator
+type FileTransferCustomValidator struct {
+	Client client.Reader
+}
+
+var _ webhook.CustomValidator = &FileTransferCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator
+func (v *FileTransferCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	fileTransfer, ok := obj.(*csfov1alpha1.FileTransfer)
+	if !ok {
+		return nil, fmt.Errorf("expected a FileTransfer but got a %T", obj)
+	}
+	filetransferlog.Info("validate create", "name", fileTransfer.Name, "namespace", fileTransfer.Namespace)
+	if err := retrievers.AuthorizeImpersonation(fileTransfer); err != nil {
+		return nil, err
+	}
+	if err := transfer.CheckPrefixes(fileTransfer); err != nil {
+		return nil, err
+	}
+	if err := retrievers.AuthorizeSecret(ctx, v.Client, fileTransfer); err != nil {
+		return nil, err
+	}
+	return nil, policy.Check(ctx, v.Client, fileTransfer.Namespace, policy.TransferAccesses(fileTransfer))
+}
+
+// ValidateUpdate implements webhook.CustomValidator. The impersonation, the prefixes, the secret and the policies
+// are only checked again when they changed, so transfers can still be suspended or cancelled after their secret stopped being
+// shared or a policy changed.
+func (v *FileTransferCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldTransfer, ok := oldObj.(*csfov1alpha1.FileTransfer)
+	if !ok {
+		return nil, fmt.Errorf("expected a FileTransfer but got a %T", oldObj)
+	}
+	fileTransfer, ok := newObj.(*csfov1alpha1.FileTransfer)
+	if !ok {
+		return nil, fmt.Errorf("expected a FileTransfer but got a %T", newObj)
+	}
+	filetransferlog.Info("validate update", "name", fileTransfer.Name, "namespace", fileTransfer.Namespace)
+	if !equality.Semantic.DeepEqual(oldTransfer.Spec.Credentials, fileTransfer.Spec.Credentials) ||
+		!equality.Semantic.DeepEqual(oldTransfer.Spec.BucketSecret, fileTransfer.Spec.BucketSecret) ||
+		oldTransfer.Spec.Executor != fileTransfer.Spec.Executor {
+		if err := retrievers.AuthorizeImpersonation(fileTransfer); err != nil {
+			return nil, err
+		}
+	}
+	if !equality.Semantic.DeepEqual(oldTransfer.Spec.Query, fileTransfer.Spec.Query) ||
+		!equality.Semantic.DeepEqual(oldTransfer.Spec.CopyDestination, fileTransfer.Spec.CopyDestination) {
+		if err := transfer.CheckPrefixes(fileTransfer); err != nil {
+			return nil, err
+		}
+	}
+	if !equality.Semantic.DeepEqual(oldTransfer.Spec.BucketSecret, fileTransfer.Spec.BucketSecret) {
+		if err := retrievers.AuthorizeSecret(ctx, v.Client, fileTransfer); err != nil {
+			return nil, err
+		}
+	}
+	accesses := policy.TransferAccesses(fileTransfer)
+	if equality.Semantic.DeepEqual(policy.TransferAccesses(oldTransfer), accesses) {
+		return nil, nil
+	}
+	return nil, policy.Check(ctx, v.Client, fileTransfer.Namespace, accesses)
+}
+
+// ValidateDelete implements webhook.CustomValidator, deletes are always allowed
+func (v *FileTransferCustomValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/retrievers"
+)
+
+func TestValidateBucketSecret(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = csfov1alpha1.AddToScheme(scheme)
+
+	validator := &FileTransferCustomValidator{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "private", Namespace: "shared"}},
+		&v1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:        "team-a",
+			Namespace:   "shared",
+			Annotations: map[string]string{retrievers.AllowedNamespacesAnnotation: "team-a"},
+		}},
+	).Build()}
+	transfer := func(secret string) *csfov1alpha1.FileTransfer {
+		return &csfov1alpha1.FileTransfer{
+			ObjectMeta: metav1.ObjectMeta{Name: "copy", Namespace: "team-a"},
+			Spec: csfov1alpha1.FileTransferSpec{
+				BucketSecret: &v1.SecretReference{Name: secret, Namespace: "shared"},
+			},
+		}
+	}
+	ctx := context.Background()
+
+	if _, err := validator.ValidateCreate(ctx, transfer("team-a")); err != nil {
+		t.Errorf("expected a shared secret to be allowed: %v", err)
+	}
+	if _, err := validator.ValidateCreate(ctx, transfer("private")); err == nil {
+		t.Error("expected a secret not shared with the namespace to be rejected")
+	}
+	if _, err := validator.ValidateCreate(ctx, transfer("missing")); err == nil {
+		t.Error("expected a missing secret of another namespace to be rejected")
+	}
+	if _, err := validator.ValidateUpdate(ctx, transfer("private"), transfer("private")); err != nil {
+		t.Errorf("expected updates keeping the secret to be allowed: %v", err)
+	}
+	if _, err := validator.ValidateUpdate(ctx, transfer("team-a"), transfer("private")); err == nil {
+		t.Error("expected switching to a secret not shared with the namespace to be rejected")
+	}
+}
+
+func TestValidateOverlappingPrefixes(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = csfov1alpha1.AddToScheme(scheme)
+
+	validator := &FileTransferCustomValidator{Client: fake.NewClientBuilder().WithScheme(scheme).Build()}
+	transfer := func(target string) *csfov1alpha1.FileTransfer {
+		return &csfov1alpha1.FileTransfer{
+			ObjectMeta: metav1.ObjectMeta{Name: "copy", Namespace: "team-a"},
+			Spec: csfov1alpha1.FileTransferSpec{
+				BucketName:      "bucket",
+				Query:           csfov1alpha1.Query{Prefix: "raw/"},
+				CopyDestination: &csfov1alpha1.CopyDestination{Prefix: target},
+			},
+		}
+	}
+	ctx := context.Background()
+
+	if _, err := validator.ValidateCreate(ctx, transfer("archive/")); err != nil {
+		t.Errorf("expected a sibling destination to be allowed: %v", err)
+	}
+	if _, err := validator.ValidateCreate(ctx, transfer("raw/archive/")); err == nil {
+		t.Error("expected a destination below the source prefix to be rejected")
+	}
+	if _, err := validator.ValidateUpdate(ctx, transfer("archive/"), transfer("raw/archive/")); err == nil {
+		t.Error("expected switching to a destination below the source prefix to be rejected")
+	}
+	// Existing transfers can still be suspended
+	suspended := transfer("raw/archive/")
+	suspended.Spec.Suspend = true
+	if _, err := validator.ValidateUpdate(ctx, transfer("raw/archive/"), suspended); err != nil {
+		t.Errorf("expected updates keeping the prefixes to be allowed: %v", err)
+	}
+}
+
+func TestValidateImpersonation(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = csfov1alpha1.AddToScheme(scheme)
+
+	validator := &FileTransferCustomValidator{Client: fake.NewClientBuilder().WithScheme(scheme).Build()}
+	transfer := func(executor csfov1alpha1.Executor) *csfov1alpha1.FileTransfer {
+		return &csfov1alpha1.FileTransfer{
+			ObjectMeta: metav1.ObjectMeta{Name: "copy", Namespace: "team-a"},
+			Spec: csfov1alpha1.FileTransferSpec{
+				BucketName:  "bucket",
+				Executor:    executor,
+				Credentials: &csfov1alpha1.Credentials{ImpersonateServiceAccount: "other@project.iam.gserviceaccount.com"},
+			},
+		}
+	}
+	ctx := context.Background()
+
+	if _, err := validator.ValidateCreate(ctx, transfer("")); err == nil {
+		t.Error("expected impersonation with the operator credentials to be rejected")
+	}
+	if _, err := validator.ValidateCreate(ctx, transfer(csfov1alpha1.ExecutorJob)); err != nil {
+		t.Errorf("expected worker Jobs to impersonate: %v", err)
+	}
+	if _, err := validator.ValidateUpdate(ctx, transfer(csfov1alpha1.ExecutorJob), transfer("")); err == nil {
+		t.Error("expected switching to the inline executor to be rejected")
+	}
+}
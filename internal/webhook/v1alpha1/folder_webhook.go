@@ -0,0 +1,85 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/internal/policy"
+)
+
+var folderlog = logf.Log.WithName("folder-webhook")
+
+// SetupFolderWebhookWithManager registers the validating webhook of Folders
+func SetupFolderWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&csfov1alpha1.Folder{}).
+		WithValidator(&FolderCustomValidator{Client: mgr.GetClient()}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-csfo-sijoma-dev-v1alpha1-folder,mutating=false,failurePolicy=fail,sideEffects=None,groups=csfo.sijoma.dev,resources=folders,verbs=create;update,versions=v1alpha1,name=vfolder.kb.io,admissionReviewVersions=v1
+
+// FolderCustomValidator rejects Folders on buckets, prefixes or with roles the StoragePolicies do not allow
+type FolderCustomValidator struct {
+	Client client.Reader
+}
+
+var _ webhook.CustomValidator = &FolderCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator
+func (v *FolderCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	folder, ok := obj.(*csfov1alpha1.Folder)
+	if !ok {
+		return nil, fmt.Errorf("expected a Folder but got a %T", obj)
+	}
+	folderlog.Info("validate create", "name", folder.Name, "namespace", folder.Namespace)
+	return nil, policy.Check(ctx, v.Client, folder.Namespace, policy.FolderAccesses(folder))
+}
+
+// ValidateUpdate implements webhook.CustomValidator, the policies are only checked again when the accesses changed
+func (v *FolderCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldFolder, ok := oldObj.(*csfov1alpha1.Folder)
+	if !ok {
+		return nil, fmt.Errorf("expected a Folder but got a %T", oldObj)
+	}
+	folder, ok := newObj.(*csfov1alpha1.Folder)
+	if !ok {
+		return nil, fmt.Errorf("expected a Folder but got a %T", newObj)
+	}
+	folderlog.Info("validate update", "name", folder.Name, "namespace", folder.Namespace)
+	accesses := policy.FolderAccesses(folder)
+	if equality.Semantic.DeepEqual(policy.FolderAccesses(oldFolder), accesses) {
+		return nil, nil
+	}
+	return nil, policy.Check(ctx, v.Client, folder.Namespace, accesses)
+}
+
+// ValidateDelete implements webhook.CustomValidator, deletes are always allowed
+func (v *FolderCustomValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
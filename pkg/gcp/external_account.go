@@ -0,0 +1,69 @@
+package gcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	stsTokenURL         = "https://sts.googleapis.com/v1/token"
+	jwtSubjectTokenType = "urn:ietf:params:oauth:token-type:jwt"
+	// impersonationURLFormat takes the email of the impersonated service account
+	impersonationURLFormat = "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/%s:generateAccessToken"
+)
+
+// externalAccountConfig is a credential configuration file of workload identity federation
+type externalAccountConfig struct {
+	Type                           string           `json:"type"`
+	Audience                       string           `json:"audience"`
+	SubjectTokenType               string           `json:"subject_token_type"`
+	TokenURL                       string           `json:"token_url"`
+	CredentialSource               credentialSource `json:"credential_source"`
+	ServiceAccountImpersonationURL string           `json:"service_account_impersonation_url,omitempty"`
+}
+
+type credentialSource struct {
+	File   string                 `json:"file"`
+	Format credentialSourceFormat `json:"format"`
+}
+
+type credentialSourceFormat struct {
+	Type string `json:"type"`
+}
+
+// ExternalAccountConfig is the credential configuration exchanging the Kubernetes ServiceAccount token at tokenPath
+// with the workload identity provider, e.g. projects/123/locations/global/workloadIdentityPools/pool/providers/eks.
+// The federated token impersonates the service account with the given email, if any.
+func ExternalAccountConfig(provider, tokenPath, email string) ([]byte, error) {
+	config := externalAccountConfig{
+		Type:             "external_account",
+		Audience:         Audience(provider),
+		SubjectTokenType: jwtSubjectTokenType,
+		TokenURL:         stsTokenURL,
+		CredentialSource: credentialSource{File: tokenPath, Format: credentialSourceFormat{Type: "text"}},
+	}
+	if email != "" {
+		config.ServiceAccountImpersonationURL = fmt.Sprintf(impersonationURLFormat, email)
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("ExternalAccountConfig: %w", err)
+	}
+	return data, nil
+}
+
+// Audience of the workload identity provider, the ServiceAccount token has to be issued for it
+func Audience(provider string) string {
+	return "//iam.googleapis.com/" + strings.TrimPrefix(provider, "//iam.googleapis.com/")
+}
+
+// ExternalPrincipal is the principal of a Kubernetes ServiceAccount in the pool of the workload identity provider.
+// It expects the provider to map google.subject to the sub claim of the token, system:serviceaccount:<ns>:<name>.
+func ExternalPrincipal(provider, kubernetesNamespace, kubernetesSA string) string {
+	pool := strings.TrimPrefix(provider, "//iam.googleapis.com/")
+	if i := strings.Index(pool, "/providers/"); i >= 0 {
+		pool = pool[:i]
+	}
+	return fmt.Sprintf("principal://iam.googleapis.com/%s/subject/system:serviceaccount:%s:%s", pool, kubernetesNamespace, kubernetesSA)
+}
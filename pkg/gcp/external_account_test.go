@@ -0,0 +1,44 @@
+package gcp
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const provider = "projects/123/locations/global/workloadIdentityPools/clusters/providers/eks"
+
+func TestExternalAccountConfig(t *testing.T) {
+	data, err := ExternalAccountConfig(provider, "/var/run/secrets/tokens/gcp", "data-team-a@project.iam.gserviceaccount.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config map[string]any
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	if config["type"] != "external_account" ||
+		config["audience"] != "//iam.googleapis.com/"+provider ||
+		config["service_account_impersonation_url"] != "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/data-team-a@project.iam.gserviceaccount.com:generateAccessToken" {
+		t.Errorf("unexpected config %s", data)
+	}
+	if source := config["credential_source"].(map[string]any); source["file"] != "/var/run/secrets/tokens/gcp" {
+		t.Errorf("unexpected credential source %v", source)
+	}
+
+	data, err = ExternalAccountConfig("//iam.googleapis.com/"+provider, "/token", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config = nil
+	_ = json.Unmarshal(data, &config)
+	if _, found := config["service_account_impersonation_url"]; found || config["audience"] != "//iam.googleapis.com/"+provider {
+		t.Errorf("expected a direct config without impersonation, got %s", data)
+	}
+}
+
+func TestExternalPrincipal(t *testing.T) {
+	want := "principal://iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/clusters/subject/system:serviceaccount:team-a:data-owner"
+	if got := ExternalPrincipal(provider, "team-a", "data-owner"); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -3,13 +3,16 @@ package gcp
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"google.golang.org/api/iam/v1"
+	"google.golang.org/api/iamcredentials/v1"
 	"google.golang.org/api/option"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/gcs"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/retry"
 )
 
 type Client struct {
@@ -17,6 +20,8 @@ type Client struct {
 	// For creating GCP Service Accounts
 	client    *iam.Service
 	projectID string
+	// For signing as GCP Service Accounts
+	credentials *iamcredentials.Service
 	// Custom client for ManagedFolders (no official support in storage client)
 	// https://cloud.google.com/storage/docs/access-control/using-iam-permissions#managed-folder-iam
 	folderService *gcs.ManagedFolderClient
@@ -31,12 +36,18 @@ func NewGCPClient(ctx context.Context, gcpProjectID string, opts ...option.Clien
 	if err != nil {
 		return nil, fmt.Errorf("failed to create gcp client: %w", err)
 	}
+	gcsClient.SetRetry(retry.Default.StorageOptions()...)
 
 	client, err := iam.NewService(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("NewGCPClient: %w", err)
 	}
 
+	credentials, err := iamcredentials.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("NewGCPClient: %w", err)
+	}
+
 	folderService, err := gcs.NewFolderClient(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("NewGCPClient: %w", err)
@@ -45,46 +56,120 @@ func NewGCPClient(ctx context.Context, gcpProjectID string, opts ...option.Clien
 	return &Client{
 		gcs:           gcsClient,
 		client:        client,
+		credentials:   credentials,
 		folderService: folderService,
 		projectID:     gcpProjectID,
 	}, nil
 }
 
-// CreateServiceAccount creates a service account.
-func (p Client) CreateServiceAccount(ctx context.Context, saName, kubernetesSA, kubernetesNamespace string) (*iam.ServiceAccount, error) {
+// CreateServiceAccount creates a service account for the owner, e.g. "Folder team-a/data", recorded in its description.
+// An existing service account created outside of the operator is only adopted if allowed, one the operator created for
+// another owner never is: the names are ambiguous, e.g. for Folder a-b in namespace c and Folder a in namespace b-c.
+// ErrNotOwned is returned otherwise. Returns whether the operator owns the service account.
+func (p Client) CreateServiceAccount(ctx context.Context, saName, kubernetesSA, kubernetesNamespace, owner string,
+	adopt bool,
+) (*iam.ServiceAccount, bool, error) {
 	logger := log.FromContext(ctx)
 
 	displayName := "storage-" + saName + "-" + kubernetesNamespace
-	account, err := p.getOrCreateServiceAccount(ctx, saName, displayName)
+	account, err := p.getOrCreateServiceAccount(ctx, saName, displayName, ownerDescription(owner))
 	if err != nil {
-		return nil, fmt.Errorf("CreateServiceAccount: %w", err)
+		return nil, false, fmt.Errorf("CreateServiceAccount: %w", err)
+	}
+	owned := account.Description == ownerDescription(owner)
+	if !owned && (!adopt || managedByOperator(account)) {
+		return nil, false, fmt.Errorf("CreateServiceAccount: %w: service account %s (%s)",
+			ErrNotOwned, account.Email, account.Description)
 	}
-	logger.Info("service account connected", "serviceAccount", account.Name)
+	logger.Info("service account connected", "serviceAccount", account.Name, "owned", owned)
 
 	// Workload identity binding - This needs to be on the service account
 	member := fmt.Sprintf("serviceAccount:%s.svc.id.goog[%s/%s]", account.ProjectId, kubernetesNamespace, kubernetesSA)
 	err = p.addBindingOnSA(ctx, account, member, "roles/iam.workloadIdentityUser")
 	if err != nil {
-		return nil, fmt.Errorf("CreateServiceAccount: %w", err)
+		return nil, false, fmt.Errorf("CreateServiceAccount: %w", err)
 	}
 	logger.V(2).Info("workload identity added", "member", member)
 
-	return account, nil
+	return account, owned, nil
+}
+
+// DeleteServiceAccount deletes the service account the operator created for the owner. A service account of
+// another owner is left untouched and ErrNotOwned returned, one which no longer exists is considered deleted.
+func (p Client) DeleteServiceAccount(ctx context.Context, email, owner string) error {
+	err := p.deleteServiceAccount(ctx, email, ownerDescription(owner))
+	if err != nil {
+		return fmt.Errorf("DeleteServiceAccount: %w", err)
+	}
+	return nil
 }
 
-func (p Client) CreateManagedFolder(ctx context.Context, folder, bucketName string) (string, error) {
-	createdFolder, err := p.folderService.GetOrCreateManagedFolder(ctx, folder, bucketName)
+// AllowImpersonation grants roles/iam.serviceAccountTokenCreator on the service account to the member,
+// e.g. to let the operator run transfers as the service account of a Folder
+func (p Client) AllowImpersonation(ctx context.Context, account *iam.ServiceAccount, member string) error {
+	err := p.addBindingOnSA(ctx, account, member, "roles/iam.serviceAccountTokenCreator")
 	if err != nil {
-		return "", fmt.Errorf("CreateManagedFolder: %w", err)
+		return fmt.Errorf("AllowImpersonation: %w", err)
 	}
+	return nil
+}
 
-	return createdFolder, nil
+// AllowWorkloadIdentity grants roles/iam.workloadIdentityUser on the service account to the member,
+// e.g. the principal of a Kubernetes ServiceAccount in a workload identity pool outside of GKE
+func (p Client) AllowWorkloadIdentity(ctx context.Context, account *iam.ServiceAccount, member string) error {
+	err := p.addBindingOnSA(ctx, account, member, "roles/iam.workloadIdentityUser")
+	if err != nil {
+		return fmt.Errorf("AllowWorkloadIdentity: %w", err)
+	}
+	return nil
 }
 
-func (p Client) GrantRoleOnFolder(ctx context.Context, folder, bucketName, role, principal string) error {
-	err := p.folderService.AddIAMBinding(ctx, folder, bucketName, role, principal)
+// CreateManagedFolder creates the managed folder, an existing one is only adopted if allowed and ErrNotOwned is
+// returned otherwise. Returns whether the folder was created.
+func (p Client) CreateManagedFolder(ctx context.Context, folder, bucketName string, adopt bool) (string, bool, error) {
+	createdFolder, created, err := p.folderService.GetOrCreateManagedFolder(ctx, folder, bucketName)
+	if err != nil {
+		return "", false, fmt.Errorf("CreateManagedFolder: %w", err)
+	}
+	if !created && !adopt {
+		return "", false, fmt.Errorf("CreateManagedFolder: %w: managed folder gs://%s/%s", ErrNotOwned, bucketName, folder)
+	}
+
+	return createdFolder, created, nil
+}
+
+// DeleteManagedFolder deletes the managed folder, the objects below it are kept
+func (p Client) DeleteManagedFolder(ctx context.Context, folder, bucketName string) error {
+	err := p.folderService.DeleteManagedFolder(ctx, folder, bucketName)
+	if err != nil {
+		return fmt.Errorf("DeleteManagedFolder: %w", err)
+	}
+	return nil
+}
+
+// GrantRoleOnFolder binds the role to the principal on the managed folder, until the expiry unless it is zero
+func (p Client) GrantRoleOnFolder(ctx context.Context, folder, bucketName, role, principal string, expiry time.Time) error {
+	err := p.folderService.AddIAMBinding(ctx, folder, bucketName, role, principal, expiry)
 	if err != nil {
 		return fmt.Errorf("GrantRoleOnFolder: %w", err)
 	}
 	return nil
 }
+
+// RevokeRoleOnFolder removes the role of the principal on the managed folder
+func (p Client) RevokeRoleOnFolder(ctx context.Context, folder, bucketName, role, principal string) error {
+	err := p.folderService.RemoveIAMBinding(ctx, folder, bucketName, role, principal)
+	if err != nil {
+		return fmt.Errorf("RevokeRoleOnFolder: %w", err)
+	}
+	return nil
+}
+
+// RemoveExpiredRolesOnFolder drops the bindings of the managed folder which expired, returning how many
+func (p Client) RemoveExpiredRolesOnFolder(ctx context.Context, folder, bucketName string) (int, error) {
+	removed, err := p.folderService.RemoveExpiredBindings(ctx, folder, bucketName, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("RemoveExpiredRolesOnFolder: %w", err)
+	}
+	return removed, nil
+}
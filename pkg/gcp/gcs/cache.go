@@ -0,0 +1,146 @@
+package gcs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/api/option"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// evictInterval is how often idle clients are looked for
+const evictInterval = time.Minute
+
+// ClientCache shares storage clients between reconciles, keyed by their credential source,
+// e.g. a secret, and its version. A new version of a source replaces the client of the previous one.
+// It is a manager Runnable evicting idle clients, all clients are closed once the manager stops.
+type ClientCache struct {
+	idleTimeout time.Duration
+	newClient   func(ctx context.Context, opts ...option.ClientOption) (*StorageClient, error)
+
+	mu      sync.Mutex
+	clients map[string]*cachedClient
+}
+
+type cachedClient struct {
+	client   *StorageClient
+	version  string
+	inUse    int
+	lastUsed time.Time
+	// retired clients are closed once they are no longer in use
+	retired bool
+}
+
+// NewClientCache closes clients unused for idleTimeout
+func NewClientCache(idleTimeout time.Duration) *ClientCache {
+	return &ClientCache{
+		idleTimeout: idleTimeout,
+		newClient:   NewGcsClient,
+		clients:     map[string]*cachedClient{},
+	}
+}
+
+// Get returns the client of the credential source in the given version, creating it with opts if needed.
+// The client must not be used after calling release.
+func (c *ClientCache) Get(ctx context.Context, source, version string, opts ...option.ClientOption) (*StorageClient, func(), error) {
+	c.mu.Lock()
+	if cached, found := c.clients[source]; found && cached.version == version {
+		defer c.mu.Unlock()
+		return c.acquire(cached)
+	}
+	c.mu.Unlock()
+
+	// Creating a client can be slow, it is done without holding the lock.
+	// The client outlives the reconcile creating it.
+	client, err := c.newClient(context.WithoutCancel(ctx), opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, found := c.clients[source]
+	if found && cached.version == version {
+		// Another reconcile created the client in the meantime
+		_ = client.Close()
+		return c.acquire(cached)
+	}
+	if found {
+		log.FromContext(ctx).Info("credentials rotated, replacing storage client", "source", source)
+		c.retire(source)
+	}
+	cached = &cachedClient{client: client, version: version}
+	c.clients[source] = cached
+	return c.acquire(cached)
+}
+
+// acquire marks the client in use and returns its release func. Called with the lock held.
+func (c *ClientCache) acquire(cached *cachedClient) (*StorageClient, func(), error) {
+	cached.inUse++
+	cached.lastUsed = time.Now()
+	var once sync.Once
+	release := func() {
+		once.Do(func() { c.release(cached) })
+	}
+	return cached.client, release, nil
+}
+
+func (c *ClientCache) release(cached *cachedClient) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached.inUse--
+	cached.lastUsed = time.Now()
+	if cached.retired && cached.inUse == 0 {
+		_ = cached.client.Close()
+	}
+}
+
+// retire removes the client from the cache, it is closed right away unless in use. Called with the lock held.
+func (c *ClientCache) retire(source string) {
+	cached := c.clients[source]
+	delete(c.clients, source)
+	cached.retired = true
+	if cached.inUse == 0 {
+		_ = cached.client.Close()
+	}
+}
+
+// evict retires the clients idle for longer than the idle timeout
+func (c *ClientCache) evict(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for source, cached := range c.clients {
+		if cached.inUse == 0 && now.Sub(cached.lastUsed) > c.idleTimeout {
+			c.retire(source)
+		}
+	}
+}
+
+// Len is the amount of cached clients
+func (c *ClientCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.clients)
+}
+
+// Start evicts idle clients until the context is done, then closes all clients
+func (c *ClientCache) Start(ctx context.Context) error {
+	ticker := time.NewTicker(evictInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			c.mu.Lock()
+			for source := range c.clients {
+				c.retire(source)
+			}
+			c.mu.Unlock()
+			return nil
+		case now := <-ticker.C:
+			c.evict(now)
+		}
+	}
+}
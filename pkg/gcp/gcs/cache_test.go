@@ -0,0 +1,68 @@
+package gcs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/api/option"
+)
+
+func TestClientCache(t *testing.T) {
+	ctx := context.Background()
+	cache := NewClientCache(time.Minute)
+	created := 0
+	cache.newClient = func(ctx context.Context, opts ...option.ClientOption) (*StorageClient, error) {
+		created++
+		return NewGcsClient(ctx, option.WithoutAuthentication())
+	}
+
+	first, release, err := cache.Get(ctx, "secret:default/creds", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	same, release, _ := cache.Get(ctx, "secret:default/creds", "1")
+	if same != first || created != 1 {
+		t.Errorf("expected the cached client to be reused, created %d clients", created)
+	}
+
+	// Rotating the secret replaces the client, the previous one stays usable until released
+	rotated, releaseRotated, _ := cache.Get(ctx, "secret:default/creds", "2")
+	if rotated == first || created != 2 || cache.Len() != 1 {
+		t.Errorf("expected a new client for the rotated secret, created %d clients", created)
+	}
+	release()
+	releaseRotated()
+
+	cache.evict(time.Now().Add(2 * time.Minute))
+	if cache.Len() != 0 {
+		t.Errorf("expected idle clients to be evicted, %d left", cache.Len())
+	}
+}
+
+func TestClientCacheCreatesClientsWithoutLock(t *testing.T) {
+	ctx := context.Background()
+	cache := NewClientCache(time.Minute)
+	var racing *StorageClient
+	raced := false
+	cache.newClient = func(ctx context.Context, opts ...option.ClientOption) (*StorageClient, error) {
+		// Would deadlock if the lock was held, another reconcile creates the same client meanwhile
+		if !raced {
+			raced = true
+			var release func()
+			racing, release, _ = cache.Get(ctx, "secret:default/creds", "1")
+			defer release()
+		}
+		return NewGcsClient(ctx, option.WithoutAuthentication())
+	}
+
+	client, release, err := cache.Get(ctx, "secret:default/creds", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if client != racing || cache.Len() != 1 {
+		t.Errorf("expected the client created in the meantime to be reused, %d cached", cache.Len())
+	}
+}
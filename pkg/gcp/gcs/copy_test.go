@@ -0,0 +1,102 @@
+package gcs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// fakeStorage serves rewrites and object metadata of a single bucket like the storage JSON API
+type fakeStorage struct {
+	mu sync.Mutex
+	// objects are the stored destination objects by name
+	objects map[string]map[string]any
+	// denied sources can not be copied
+	denied map[string]bool
+	// corrupt destinations are stored with another checksum than the rewrite response reports
+	corrupt map[string]bool
+}
+
+func (f *fakeStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/storage/v1")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if source, destination, found := strings.Cut(path, "/rewriteTo/b/bucket/o/"); found && r.Method == http.MethodPost {
+		source, _ = url.PathUnescape(strings.TrimPrefix(source, "/b/bucket/o/"))
+		destination, _ = url.PathUnescape(destination)
+		if f.denied[source] {
+			http.Error(w, `{"error": {"code": 403, "message": "denied"}}`, http.StatusForbidden)
+			return
+		}
+		written := map[string]any{"bucket": "bucket", "name": destination, "size": "3", "crc32c": "AAAAAA==", "generation": "1"}
+		stored := map[string]any{"bucket": "bucket", "name": destination, "size": "3", "crc32c": "AAAAAA==", "generation": "1"}
+		if f.corrupt[destination] {
+			stored["crc32c"] = "AAAAAQ=="
+		}
+		f.objects[destination] = stored
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"kind": "storage#rewriteResponse", "done": true, "objectSize": "3", "totalBytesRewritten": "3", "resource": written,
+		})
+		return
+	}
+	if name, found := strings.CutPrefix(path, "/b/bucket/o/"); found && r.Method == http.MethodGet {
+		name, _ = url.PathUnescape(name)
+		if object, ok := f.objects[name]; ok {
+			_ = json.NewEncoder(w).Encode(object)
+			return
+		}
+	}
+	http.Error(w, `{"error": {"code": 404, "message": "not found"}}`, http.StatusNotFound)
+}
+
+func TestCopyPage(t *testing.T) {
+	fake := &fakeStorage{
+		objects: map[string]map[string]any{},
+		denied:  map[string]bool{"src/denied": true},
+		corrupt: map[string]bool{"dst/corrupt": true},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx, option.WithEndpoint(server.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := StorageClient{client: client}
+
+	objects := []Object{
+		{Key: "src/a", Size: 3, CRC32C: "AAAAAA==", Generation: 1, Destination: "dst/a", Action: CopyActionCopy},
+		{Key: "src/corrupt", Size: 3, CRC32C: "AAAAAA==", Generation: 1, Destination: "dst/corrupt", Action: CopyActionCopy},
+		{Key: "src/denied", Size: 3, CRC32C: "AAAAAA==", Generation: 1, Destination: "dst/denied", Action: CopyActionCopy},
+		{Key: "src/same", Size: 3, CRC32C: "AAAAAA==", Generation: 1, Destination: "dst/same", Action: CopyActionSkip},
+	}
+	var progress Progress
+	if err := g.copyPage(ctx, "bucket", objects, "src/z", &progress, CopyOptions{Verify: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []CopyResult{CopyResultCopied, CopyResultFailed, CopyResultFailed, CopyResultSkipped}
+	for i, result := range want {
+		if objects[i].Result != result {
+			t.Errorf("object %s: got %s (%s), want %s", objects[i].Key, objects[i].Result, objects[i].Error, result)
+		}
+	}
+	// The corrupt destination only shows up when reading it back, the rewrite response looks fine
+	if !strings.Contains(objects[1].Error, errChecksumMismatch.Error()) {
+		t.Errorf("expected a checksum mismatch, got %q", objects[1].Error)
+	}
+	// Failed objects are not counted as copied
+	if progress.Copied != 2 || progress.Failed != 2 || progress.Next != "src/z" {
+		t.Errorf("unexpected progress %+v", progress)
+	}
+}
@@ -0,0 +1,61 @@
+package gcs
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/api/iam/v1"
+)
+
+// policyVersion 3 is required for bindings with conditions
+const policyVersion = 3
+
+// expiryTitle marks the conditions of expiring bindings managed by the operator,
+// conditions with other titles are left alone
+const expiryTitle = "csfo-expiry"
+
+// expiryExpressionFormat grants a binding until the given RFC 3339 timestamp
+const expiryExpressionFormat = `request.time < timestamp(%q)`
+
+// expiryCondition returns the condition of a binding expiring at expiry, nil for a zero expiry
+func expiryCondition(expiry time.Time) *iam.Expr {
+	if expiry.IsZero() {
+		return nil
+	}
+	return &iam.Expr{
+		Title:       expiryTitle,
+		Description: "Expiring access managed by the cloud-storage-file-operator",
+		Expression:  fmt.Sprintf(expiryExpressionFormat, expiry.UTC().Format(time.RFC3339)),
+	}
+}
+
+// bindingExpiry returns when a binding with an expiry condition of the operator expires
+func bindingExpiry(binding *iam.Binding) (time.Time, bool) {
+	if binding.Condition == nil || binding.Condition.Title != expiryTitle {
+		return time.Time{}, false
+	}
+	var timestamp string
+	_, err := fmt.Sscanf(binding.Condition.Expression, expiryExpressionFormat, &timestamp)
+	if err != nil {
+		return time.Time{}, false
+	}
+	expiry, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return expiry, true
+}
+
+// sameCondition reports whether the binding has the given condition, nil meaning no condition
+func sameCondition(binding *iam.Binding, condition *iam.Expr) bool {
+	if binding.Condition == nil || condition == nil {
+		return binding.Condition == nil && condition == nil
+	}
+	return binding.Condition.Title == condition.Title && binding.Condition.Expression == condition.Expression
+}
+
+// managedCondition reports whether the binding has no condition or an expiry condition of the operator
+func managedCondition(binding *iam.Binding) bool {
+	_, expiring := bindingExpiry(binding)
+	return binding.Condition == nil || expiring
+}
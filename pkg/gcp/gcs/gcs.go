@@ -2,17 +2,75 @@ package gcs
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"fmt"
-	"strings"
+	"io"
 	"sync"
-	"time"
 
 	"cloud.google.com/go/storage"
-	"google.golang.org/api/iterator"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"google.golang.org/api/option"
+
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/retry"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/tracing"
+)
+
+const (
+	// listPageSize is the amount of objects requested per list call
+	listPageSize = 1000
+	// copyBatchSize is the amount of objects copied concurrently
+	copyBatchSize = 100
+	// verifyAttempts is how often an object is copied until its destination matches the source checksums
+	verifyAttempts = 3
+)
+
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// Object is a listed object and the result of its copy
+type Object struct {
+	Key        string `json:"key"`
+	Size       int64  `json:"size"`
+	Generation int64  `json:"generation"`
+	// CRC32C checksum, base64 encoded in big-endian order like the storage API
+	CRC32C string `json:"crc32c"`
+	// MD5 hash, base64 encoded. Composite objects have none.
+	MD5 string `json:"md5,omitempty"`
+	// Destination key of the copy
+	Destination string `json:"destination,omitempty"`
+	// Action is the planned handling of the object
+	Action CopyAction `json:"action,omitempty"`
+	// Result of the copy, empty if the object was not copied
+	Result CopyResult `json:"result,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// CopyResult is the outcome of copying a single object
+type CopyResult string
+
+const (
+	CopyResultCopied  CopyResult = "Copied"
+	CopyResultSkipped CopyResult = "Skipped"
+	CopyResultFailed  CopyResult = "Failed"
 )
 
+func objectFromAttrs(attrs *storage.ObjectAttrs) Object {
+	crc32c := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc32c, attrs.CRC32C)
+	object := Object{
+		Key:        attrs.Name,
+		Size:       attrs.Size,
+		Generation: attrs.Generation,
+		CRC32C:     base64.StdEncoding.EncodeToString(crc32c),
+	}
+	if len(attrs.MD5) > 0 {
+		object.MD5 = base64.StdEncoding.EncodeToString(attrs.MD5)
+	}
+	return object
+}
+
 type StorageClient struct {
 	client *storage.Client
 }
@@ -22,84 +80,233 @@ func NewGcsClient(ctx context.Context, opts ...option.ClientOption) (*StorageCli
 	if err != nil {
 		return nil, fmt.Errorf("failed creating gcs client %w", err)
 	}
+	client.SetRetry(retry.Default.StorageOptions()...)
 
 	return &StorageClient{
 		client: client,
 	}, nil
 }
 
-func (g StorageClient) FindObjects(ctx context.Context, bucket string, sq storage.Query) ([]string, error) {
-	var foundObjects []string
-	obj := g.client.Bucket(bucket).Objects(ctx, &sq)
-	for {
-		objectArrs, err := obj.Next()
-		if errors.Is(err, iterator.Done) {
+// Close releases the connections of the client
+func (g StorageClient) Close() error {
+	return g.client.Close()
+}
+
+// CopyOptions tune CopyFiles
+type CopyOptions struct {
+	// DryRun only plans the copy, no object is written
+	DryRun bool
+	// Verify compares every written object against the checksums of its source, mismatches are copied again
+	Verify bool
+	// OnObjects receives every processed batch with the planned action and the result per object
+	OnObjects func(objects []Object) error
+	// OnProgress is called after every processed batch
+	OnProgress func(ctx context.Context, progress Progress)
+}
+
+// Progress of a running copy
+type Progress struct {
+	// Found is the amount of objects listed so far, all matching objects once the copy is done
+	Found int
+	// Copied is the amount of objects copied (or skipped) so far
+	Copied int
+	// Failed is the amount of objects of which the copy failed, they are not included in Copied
+	Failed int
+	// Next is the key of the first object not yet copied, empty once all objects are done.
+	// Used as StartOffset of the query to resume an interrupted copy.
+	Next string
+	// Plan counts the planned actions of the objects listed so far
+	Plan Plan
+}
+
+// CopyFiles copies all objects matching the query to the target prefix.
+// The source listing is streamed page by page into the copy, the destination listing is walked alongside it.
+// Objects with an identical destination are skipped. A target prefix the source query lists is rejected
+// with ErrOverlappingPrefixes.
+// A cancelled context stops the copy after the running batch.
+// The returned progress is the state reached, also when the copy failed.
+func (g StorageClient) CopyFiles(ctx context.Context, bucket string, sq storage.Query, targetPrefix string, opts CopyOptions) (Progress, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "gcs.CopyFiles")
+	defer span.End()
+	span.SetAttributes(attribute.String("bucket", bucket), attribute.String("prefix", sq.Prefix),
+		attribute.Bool("dryRun", opts.DryRun))
+
+	var progress Progress
+	if Relists(sq, targetPrefix) {
+		return progress, fmt.Errorf("%w: gs://%s/%s into gs://%s/%s", ErrOverlappingPrefixes,
+			bucket, sq.Prefix, bucket, targetPrefix)
+	}
+	sources := g.pages(ctx, bucket, sq)
+	destinations := &cursor{next: g.pages(ctx, bucket, destinationQuery(sq, targetPrefix))}
+
+	page, done, err := sources()
+	for err == nil && len(page) > 0 {
+		// The following page is fetched first, its first key is where an interrupted copy resumes
+		var following []Object
+		if !done {
+			following, done, err = sources()
+			if err != nil {
+				break
+			}
+		}
+		next := ""
+		if len(following) > 0 {
+			next = following[0].Key
+		}
+
+		if err = planObjects(page, destinations, sq.Prefix, targetPrefix); err != nil {
 			break
 		}
-		if err != nil {
-			return nil, err
+		progress.Found += len(page)
+		progress.Plan.Add(page)
+		if err = g.copyPage(ctx, bucket, page, next, &progress, opts); err != nil {
+			break
 		}
-		foundObjects = append(foundObjects, objectArrs.Name)
+		page = following
 	}
-	return foundObjects, nil
-}
-
-func (g StorageClient) CopyFiles(ctx context.Context, bucketName string, sq storage.Query, targetPrefix string) error {
-	foundObjects, err := g.FindObjects(ctx, bucketName, sq)
 	if err != nil {
-		fmt.Println("naaah")
-		return err
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return progress, err
 	}
-	if len(foundObjects) == 0 {
-		return fmt.Errorf("no objects found")
+	if progress.Found == 0 && !opts.DryRun {
+		return progress, fmt.Errorf("no objects found")
 	}
+	span.SetAttributes(attribute.Int("objects", progress.Found))
+	return progress, nil
+}
 
-	err = g.copyFiles(ctx, bucketName, sq.Prefix, targetPrefix, foundObjects)
-	if err != nil {
-		return err
+// copyPage copies a planned page in batches, next is the first key after the page
+func (g StorageClient) copyPage(ctx context.Context, bucket string, objects []Object, next string, progress *Progress, opts CopyOptions) error {
+	for start := 0; start < len(objects); start += copyBatchSize {
+		end := min(start+copyBatchSize, len(objects))
+		batch := objects[start:end]
+		if !opts.DryRun {
+			err := g.copyBatch(ctx, bucket, batch, opts.Verify)
+			if err != nil {
+				return err
+			}
+			// Objects of an interrupted batch are not reported as copied
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			for _, object := range batch {
+				if object.Result == CopyResultFailed {
+					progress.Failed++
+				} else {
+					progress.Copied++
+				}
+			}
+			progress.Next = next
+			if end < len(objects) {
+				progress.Next = objects[end].Key
+			}
+		}
+		if opts.OnObjects != nil {
+			if err := opts.OnObjects(batch); err != nil {
+				return err
+			}
+		}
+		if opts.OnProgress != nil {
+			opts.OnProgress(ctx, *progress)
+		}
 	}
 	return nil
 }
 
-func (g StorageClient) copyFiles(ctx context.Context, bucket, prefix, targetPrefix string, objectKeys []string) error {
+// copyBatch copies the given planned objects concurrently and waits for all of them to finish
+func (g StorageClient) copyBatch(ctx context.Context, bucket string, objects []Object, verify bool) error {
+	ctx, span := tracing.Tracer().Start(ctx, "gcs.copyBatch")
+	defer span.End()
+	span.SetAttributes(attribute.String("bucket", bucket), attribute.Int("objects", len(objects)))
+
 	wg := sync.WaitGroup{}
-	for i, obj := range objectKeys {
-		targetPath, found := strings.CutPrefix(obj, prefix)
-		if !found {
-			return errors.New("did not found prefix on obj")
+	for i := range objects {
+		obj := &objects[i]
+		if obj.Action == CopyActionSkip {
+			obj.Result = CopyResultSkipped
+			continue
 		}
 
-		src := obj
-		dst := targetPrefix + targetPath
-
 		wg.Add(1)
 		go func() {
-			err := g.copyFile(ctx, bucket, src, dst)
+			err := g.copyObject(ctx, bucket, *obj, verify)
 			if err != nil {
 				// We should bubble these up
-				fmt.Println(err)
+				span.RecordError(err)
+				obj.Result = CopyResultFailed
+				obj.Error = err.Error()
+			} else {
+				obj.Result = CopyResultCopied
 			}
 			wg.Done()
 		}()
-		// Todo: WIP Artificial slowdown - use buffered channel
-		if i%100 == 0 {
-			time.Sleep(time.Millisecond * 200)
-		}
 	}
 	wg.Wait()
 	return nil
 }
 
-func (g StorageClient) copyFile(ctx context.Context, bucket, srcObj, dstObj string) error {
+// copyObject copies a planned object. With verify the listed generation of the source is copied
+// and the stored destination object is read back and compared against its checksums, mismatches are copied again.
+func (g StorageClient) copyObject(ctx context.Context, bucket string, obj Object, verify bool) error {
+	if !verify {
+		_, err := g.copyFile(ctx, bucket, obj.Key, 0, obj.Destination)
+		return err
+	}
+
+	var err error
+	for attempt := 0; attempt < verifyAttempts; attempt++ {
+		var written, stored *storage.ObjectAttrs
+		written, err = g.copyFile(ctx, bucket, obj.Key, obj.Generation, obj.Destination)
+		if err != nil {
+			return err
+		}
+		// The response of the copy only echoes what the API intended to write
+		stored, err = g.client.Bucket(bucket).Object(obj.Destination).Generation(written.Generation).Attrs(ctx)
+		if err != nil {
+			return fmt.Errorf("Object(%q).Attrs: %w", obj.Destination, err)
+		}
+		if err = verifyChecksums(obj, objectFromAttrs(stored)); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("Object(%q) after %d attempts: %w", obj.Destination, verifyAttempts, err)
+}
+
+// verifyChecksums compares a written object against its source, MD5 only if both have one
+func verifyChecksums(source, written Object) error {
+	if written.Size != source.Size || written.CRC32C != source.CRC32C {
+		return fmt.Errorf("%w: size %d crc32c %s, expected size %d crc32c %s", errChecksumMismatch,
+			written.Size, written.CRC32C, source.Size, source.CRC32C)
+	}
+	if source.MD5 != "" && written.MD5 != "" && written.MD5 != source.MD5 {
+		return fmt.Errorf("%w: md5 %s, expected %s", errChecksumMismatch, written.MD5, source.MD5)
+	}
+	return nil
+}
+
+// copyFile copies srcObj to dstObj, a generation other than 0 pins the copied version of the source
+func (g StorageClient) copyFile(ctx context.Context, bucket, srcObj string, generation int64, dstObj string) (*storage.ObjectAttrs, error) {
 	src := g.client.Bucket(bucket).Object(srcObj)
+	if generation != 0 {
+		src = src.Generation(generation)
+	}
 	dst := g.client.Bucket(bucket).Object(dstObj)
 
 	//dst = dst.If(storage.Conditions{DoesNotExist: true}).
 
 	copier := dst.CopierFrom(src)
-	_, err := copier.Run(ctx)
+	attrs, err := copier.Run(ctx)
 	if err != nil {
-		return fmt.Errorf("Object(%q).CopierFrom(%q).Run: %w", dstObj, srcObj, err)
+		return nil, fmt.Errorf("Object(%q).CopierFrom(%q).Run: %w", dstObj, srcObj, err)
 	}
-	return nil
+	return attrs, nil
+}
+
+// NewObjectWriter uploads everything written to it as the named object once closed.
+// Cancelling the context aborts the upload.
+func (g StorageClient) NewObjectWriter(ctx context.Context, bucket, name, contentType string) io.WriteCloser {
+	writer := g.client.Bucket(bucket).Object(name).NewWriter(ctx)
+	writer.ContentType = contentType
+	return writer
 }
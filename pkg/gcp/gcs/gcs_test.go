@@ -0,0 +1,31 @@
+package gcs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerifyChecksums(t *testing.T) {
+	source := Object{Key: "src/a", Size: 3, CRC32C: "aaaa", MD5: "bWQ1"}
+
+	tests := []struct {
+		name    string
+		written Object
+		match   bool
+	}{
+		{"identical", Object{Size: 3, CRC32C: "aaaa", MD5: "bWQ1"}, true},
+		{"composite without md5", Object{Size: 3, CRC32C: "aaaa"}, true},
+		{"crc32c differs", Object{Size: 3, CRC32C: "bbbb", MD5: "bWQ1"}, false},
+		{"size differs", Object{Size: 4, CRC32C: "aaaa", MD5: "bWQ1"}, false},
+		{"md5 differs", Object{Size: 3, CRC32C: "aaaa", MD5: "eHh4"}, false},
+	}
+	for _, tt := range tests {
+		err := verifyChecksums(source, tt.written)
+		if tt.match && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if !tt.match && !errors.Is(err, errChecksumMismatch) {
+			t.Errorf("%s: expected a checksum mismatch, got %v", tt.name, err)
+		}
+	}
+}
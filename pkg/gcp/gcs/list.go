@@ -0,0 +1,123 @@
+package gcs
+
+import (
+	"context"
+
+	"cloud.google.com/go/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/api/iterator"
+
+	"github.com/sijoma/cloud-storage-file-operator/pkg/tracing"
+)
+
+// listAttrs are the only object attributes requested when listing
+var listAttrs = []string{"Name", "Size", "Generation", "CRC32C", "MD5"}
+
+// pageFunc returns the next page of a listing and whether it was the last one
+type pageFunc func() ([]Object, bool, error)
+
+// ListObjects streams the objects matching the query to fn, one page at a time.
+// Pages are not kept after fn returned, so the memory use does not grow with the listing.
+// Returns the amount of listed objects.
+func (g StorageClient) ListObjects(ctx context.Context, bucket string, sq storage.Query, fn func(objects []Object) error) (int, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "gcs.ListObjects")
+	defer span.End()
+	span.SetAttributes(attribute.String("bucket", bucket), attribute.String("prefix", sq.Prefix))
+
+	found := 0
+	next := g.pages(ctx, bucket, sq)
+	for {
+		objects, done, err := next()
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return found, err
+		}
+		found += len(objects)
+		if len(objects) > 0 {
+			if err := fn(objects); err != nil {
+				return found, err
+			}
+		}
+		if done {
+			break
+		}
+	}
+	span.SetAttributes(attribute.Int("objects", found))
+	return found, nil
+}
+
+// pages lists the objects matching the query lazily, requesting only the attributes in listAttrs.
+// Empty pages in the middle of a listing are skipped, so are the sub-prefixes of a delimited listing.
+func (g StorageClient) pages(ctx context.Context, bucket string, sq storage.Query) pageFunc {
+	// Only fails for unknown attributes
+	_ = sq.SetAttrSelection(listAttrs)
+	pager := iterator.NewPager(g.client.Bucket(bucket).Objects(ctx, &sq), listPageSize, "")
+	page := 0
+	return func() ([]Object, bool, error) {
+		for {
+			attrs, done, err := g.nextPage(ctx, pager, page)
+			page++
+			if err != nil {
+				return nil, false, err
+			}
+			objects := make([]Object, 0, len(attrs))
+			for _, object := range attrs {
+				// Sub-prefixes of a delimited listing come without a name
+				if object.Prefix != "" {
+					continue
+				}
+				objects = append(objects, objectFromAttrs(object))
+			}
+			if len(objects) == 0 && !done {
+				continue
+			}
+			return objects, done, nil
+		}
+	}
+}
+
+// nextPage fetches a single page of the listing, reporting whether it was the last one
+func (g StorageClient) nextPage(ctx context.Context, pager *iterator.Pager, page int) ([]*storage.ObjectAttrs, bool, error) {
+	_, span := tracing.Tracer().Start(ctx, "gcs.ListObjects.page")
+	defer span.End()
+	span.SetAttributes(attribute.Int("page", page))
+
+	var objects []*storage.ObjectAttrs
+	nextToken, err := pager.NextPage(&objects)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, false, err
+	}
+	span.SetAttributes(attribute.Int("objects", len(objects)))
+	return objects, nextToken == "", nil
+}
+
+// cursor walks a sorted listing lazily, fetching the next page only when needed
+type cursor struct {
+	next pageFunc
+	page []Object
+	done bool
+}
+
+// seek skips all objects before key and returns the first remaining one, nil once the listing is exhausted
+func (c *cursor) seek(key string) (*Object, error) {
+	for {
+		for len(c.page) > 0 && c.page[0].Key < key {
+			c.page = c.page[1:]
+		}
+		if len(c.page) > 0 {
+			return &c.page[0], nil
+		}
+		if c.done {
+			return nil, nil
+		}
+		page, done, err := c.next()
+		if err != nil {
+			return nil, err
+		}
+		c.page, c.done = page, done
+	}
+}
@@ -9,10 +9,16 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
 	"time"
 
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iam/v1"
+
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/retry"
 )
 
 type ManagedFolderClient struct {
@@ -30,6 +36,12 @@ func NewFolderClient(ctx context.Context) (*ManagedFolderClient, error) {
 	if err != nil {
 		return nil, fmt.Errorf("newFolderClient: %w", err)
 	}
+	// Every managed folder request gets its own span as child of the calling reconcile
+	httpClient.Transport = otelhttp.NewTransport(httpClient.Transport,
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return "managedFolders " + r.Method
+		}),
+	)
 
 	return &ManagedFolderClient{
 		client:   httpClient,
@@ -75,19 +87,6 @@ type managedFolderResource struct {
 	Metageneration string    `json:"metageneration"`
 }
 
-// Not the same as googleapi.Error
-type apiError struct {
-	Error struct {
-		Code    int    `json:"code"`
-		Message string `json:"message"`
-		Errors  []struct {
-			Message string `json:"message"`
-			Domain  string `json:"domain"`
-			Reason  string `json:"reason"`
-		} `json:"errors"`
-	} `json:"error"`
-}
-
 type notFoundError struct {
 	folderName string
 }
@@ -96,35 +95,46 @@ func (n *notFoundError) Error() string {
 	return fmt.Sprintf("folder %s not found", n.folderName)
 }
 
+// do sends a JSON request with the retry policy and decodes the response into out.
+// The body is sent again on every attempt, failed responses are returned as *googleapi.Error.
+func (c *ManagedFolderClient) do(ctx context.Context, method, endpoint string, body []byte, out any) error {
+	return retry.Default.Do(ctx, func(ctx context.Context) error {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if err := googleapi.CheckResponse(resp); err != nil {
+			return err
+		}
+		if out == nil {
+			return nil
+		}
+		return json.NewDecoder(resp.Body).Decode(out)
+	})
+}
+
 func (c *ManagedFolderClient) getManagedFolder(ctx context.Context, folder string, bucketName string) (*managedFolderResource, error) {
 	endpoint := fmt.Sprintf(c.endpoint, bucketName)
 	endpoint = endpoint + "/" + url.PathEscape(folder)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
-	if err != nil {
-		return nil, fmt.Errorf("getManagedFolder: %w", err)
-	}
-
-	resp, err := c.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("getManagedFolder: %w", err)
-	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound {
+	var managedFolder managedFolderResource
+	err := c.do(ctx, http.MethodGet, endpoint, nil, &managedFolder)
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
 		return nil, &notFoundError{folder}
 	}
-
-	if resp.StatusCode >= 300 {
-		var reqErr apiError
-		err := json.NewDecoder(resp.Body).Decode(&reqErr)
-		if err != nil {
-			return nil, fmt.Errorf("getManagedFolder: %w", err)
-		}
-		return nil, fmt.Errorf("getManagedFolder: %s, %v", resp.Status, reqErr)
-	}
-
-	var managedFolder managedFolderResource
-	err = json.NewDecoder(resp.Body).Decode(&managedFolder)
 	if err != nil {
 		return nil, fmt.Errorf("getManagedFolder: %w", err)
 	}
@@ -138,29 +148,9 @@ func (c *ManagedFolderClient) createManagedFolder(ctx context.Context, folder, b
 		return nil, fmt.Errorf("createManagedFolder: %w", err)
 	}
 	endpoint := fmt.Sprintf(c.endpoint, bucketName)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
-	if err != nil {
-		return nil, fmt.Errorf("createManagedFolder: %w", err)
-	}
-	defer req.Body.Close()
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("createManagedFolder: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		var folderError apiError
-		err := json.NewDecoder(resp.Body).Decode(&folderError)
-		if err != nil {
-			return nil, err
-		}
-		return nil, fmt.Errorf("createManagedFolder: %s", folderError.Error.Message)
-	}
 
 	var managedFolder managedFolderResource
-	err = json.NewDecoder(resp.Body).Decode(&managedFolder)
+	err = c.do(ctx, http.MethodPost, endpoint, body, &managedFolder)
 	if err != nil {
 		return nil, fmt.Errorf("createManagedFolder: %w", err)
 	}
@@ -168,11 +158,12 @@ func (c *ManagedFolderClient) createManagedFolder(ctx context.Context, folder, b
 	return &managedFolder, nil
 }
 
-// GetOrCreateManagedFolder tries to get the managedFolder, if not found it creates the desired folder
+// GetOrCreateManagedFolder tries to get the managedFolder, if not found it creates the desired folder.
+// Returns whether the folder was created.
 //
 // Get: https://cloud.google.com/storage/docs/json_api/v1/managedFolder/get
 // Insert: https://cloud.google.com/storage/docs/json_api/v1/managedFolder/insert
-func (c *ManagedFolderClient) GetOrCreateManagedFolder(ctx context.Context, folder string, bucketName string) (string, error) {
+func (c *ManagedFolderClient) GetOrCreateManagedFolder(ctx context.Context, folder string, bucketName string) (string, bool, error) {
 	managedFolder, err := c.getManagedFolder(ctx, folder, bucketName)
 	if err != nil {
 		var notFoundErr *notFoundError
@@ -180,38 +171,40 @@ func (c *ManagedFolderClient) GetOrCreateManagedFolder(ctx context.Context, fold
 		case errors.As(err, &notFoundErr):
 			createdFolder, err := c.createManagedFolder(ctx, folder, bucketName)
 			if err != nil {
-				return "", fmt.Errorf("getOrCreateManagedFolder: %w", err)
+				return "", false, fmt.Errorf("getOrCreateManagedFolder: %w", err)
 			}
-			return createdFolder.Name, nil
+			return createdFolder.Name, true, nil
 		default:
-			return "", fmt.Errorf("getOrCreateManagedFolder: %w", err)
+			return "", false, fmt.Errorf("getOrCreateManagedFolder: %w", err)
 		}
 	}
 
-	return managedFolder.Name, nil
+	return managedFolder.Name, false, nil
 }
 
-func (c *ManagedFolderClient) getIAMPolicy(ctx context.Context, folder, bucketName string) (*iam.Policy, error) {
-	endpoint := fmt.Sprintf(c.endpoint, bucketName)
-	endpoint += "/" + url.PathEscape(folder) + "/iam"
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
-	if err != nil {
-		return nil, fmt.Errorf("getIAMPolicy: %w", err)
+// DeleteManagedFolder deletes the managed folder along with its IAM policy, the objects below it are kept.
+// A managed folder which no longer exists is considered deleted.
+//
+// Delete: https://cloud.google.com/storage/docs/json_api/v1/managedFolder/delete
+func (c *ManagedFolderClient) DeleteManagedFolder(ctx context.Context, folder, bucketName string) error {
+	endpoint := fmt.Sprintf(c.endpoint, bucketName) + "/" + url.PathEscape(folder) + "?allowNonEmpty=true"
+	err := c.do(ctx, http.MethodDelete, endpoint, nil, nil)
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return nil
 	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("getIAMPolicy: %w", err)
+		return fmt.Errorf("deleteManagedFolder: %w", err)
 	}
+	return nil
+}
 
-	if resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("getIAMPolicy: %s, %v", resp.Status, resp.StatusCode)
-	}
+func (c *ManagedFolderClient) getIAMPolicy(ctx context.Context, folder, bucketName string) (*iam.Policy, error) {
+	endpoint := fmt.Sprintf(c.endpoint, bucketName)
+	endpoint += "/" + url.PathEscape(folder) + "/iam?optionsRequestedPolicyVersion=" + strconv.Itoa(policyVersion)
 
 	var iamPolicy iam.Policy
-	err = json.NewDecoder(resp.Body).Decode(&iamPolicy)
+	err := c.do(ctx, http.MethodGet, endpoint, nil, &iamPolicy)
 	if err != nil {
 		return nil, fmt.Errorf("getIAMPolicy: %w", err)
 	}
@@ -223,58 +216,70 @@ func (c *ManagedFolderClient) setIAMPolicy(ctx context.Context, folder, bucketNa
 	endpoint := fmt.Sprintf(c.endpoint, bucketName)
 	endpoint += "/" + url.PathEscape(folder) + "/iam"
 
+	policy.Version = policyVersion
 	body, err := json.Marshal(policy)
 	if err != nil {
 		return fmt.Errorf("setIAMPolicy: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewReader(body))
+	err = c.do(ctx, http.MethodPut, endpoint, body, nil)
 	if err != nil {
-		return fmt.Errorf("getIAMPolicy: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("getIAMPolicy: %w", err)
-	}
-
-	if resp.StatusCode >= 300 {
-		return fmt.Errorf("getIAMPolicy: %s, %v", resp.Status, resp.StatusCode)
+		return fmt.Errorf("setIAMPolicy: %w", err)
 	}
 
 	return nil
 }
 
-// AddIAMBinding retrieves the current IAM Policy for the folder and adds a binding for the principal on the desired role
+// AddIAMBinding retrieves the current IAM Policy for the folder and adds a binding for the principal on the desired role.
+// A non-zero expiry puts the principal into a binding with an IAM condition granting the role until then,
+// the principal is removed from the bindings of the role with another expiry or without one.
+// The policy is left untouched if the principal already has the role with the expiry.
 //
 // getIamPolicy: https://cloud.google.com/storage/docs/json_api/v1/managedFolder/getIamPolicy
 // setIamPolicy: https://cloud.google.com/storage/docs/json_api/v1/managedFolder/setIamPolicy
-func (c *ManagedFolderClient) AddIAMBinding(ctx context.Context, folder, bucketName, role, principal string) error {
+func (c *ManagedFolderClient) AddIAMBinding(ctx context.Context, folder, bucketName, role, principal string, expiry time.Time) error {
 	iamPolicy, err := c.getIAMPolicy(ctx, folder, bucketName)
 	if err != nil {
 		return err
 	}
 
+	condition := expiryCondition(expiry)
+	changed := false
 	var binding *iam.Binding
 	for _, b := range iamPolicy.Bindings {
-		if b.Role == role {
+		if b.Role != role {
+			continue
+		}
+		if sameCondition(b, condition) {
 			binding = b
-			break
+			continue
+		}
+		if managedCondition(b) && slices.Contains(b.Members, principal) {
+			b.Members = slices.DeleteFunc(b.Members, func(member string) bool { return member == principal })
+			changed = true
 		}
 	}
 
 	if binding != nil {
-		// If the binding exists, adds the member to the binding
-		binding.Members = append(binding.Members, principal)
+		if !slices.Contains(binding.Members, principal) {
+			// If the binding exists, adds the member to the binding
+			binding.Members = append(binding.Members, principal)
+			changed = true
+		}
 	} else {
 		// If the binding does not exist, adds a new binding to the policy
 		binding = &iam.Binding{
-			Role:    role,
-			Members: []string{principal},
+			Role:      role,
+			Members:   []string{principal},
+			Condition: condition,
 		}
 		iamPolicy.Bindings = append(iamPolicy.Bindings, binding)
+		changed = true
+	}
+	if !changed {
+		return nil
 	}
+	iamPolicy.Bindings = slices.DeleteFunc(iamPolicy.Bindings, func(b *iam.Binding) bool { return len(b.Members) == 0 })
 
 	err = c.setIAMPolicy(ctx, folder, bucketName, *iamPolicy)
 	if err != nil {
@@ -283,3 +288,51 @@ func (c *ManagedFolderClient) AddIAMBinding(ctx context.Context, folder, bucketN
 
 	return nil
 }
+
+// RemoveIAMBinding removes the principal from the bindings of the role, with or without an expiry.
+// Bindings without members are dropped, the policy is left untouched if the principal does not have the role.
+func (c *ManagedFolderClient) RemoveIAMBinding(ctx context.Context, folder, bucketName, role, principal string) error {
+	iamPolicy, err := c.getIAMPolicy(ctx, folder, bucketName)
+	if err != nil {
+		return err
+	}
+
+	removed := false
+	bindings := iamPolicy.Bindings[:0]
+	for _, b := range iamPolicy.Bindings {
+		if b.Role == role && managedCondition(b) && slices.Contains(b.Members, principal) {
+			b.Members = slices.DeleteFunc(b.Members, func(member string) bool { return member == principal })
+			removed = true
+		}
+		if len(b.Members) > 0 {
+			bindings = append(bindings, b)
+		}
+	}
+	if !removed {
+		return nil
+	}
+	iamPolicy.Bindings = bindings
+
+	return c.setIAMPolicy(ctx, folder, bucketName, *iamPolicy)
+}
+
+// RemoveExpiredBindings drops the bindings whose expiry condition lapsed before now.
+// Returns the amount of removed bindings, the policy is left untouched if there are none.
+func (c *ManagedFolderClient) RemoveExpiredBindings(ctx context.Context, folder, bucketName string, now time.Time) (int, error) {
+	iamPolicy, err := c.getIAMPolicy(ctx, folder, bucketName)
+	if err != nil {
+		return 0, err
+	}
+
+	before := len(iamPolicy.Bindings)
+	iamPolicy.Bindings = slices.DeleteFunc(iamPolicy.Bindings, func(b *iam.Binding) bool {
+		expiry, expiring := bindingExpiry(b)
+		return expiring && !now.Before(expiry)
+	})
+	removed := before - len(iamPolicy.Bindings)
+	if removed == 0 {
+		return 0, nil
+	}
+
+	return removed, c.setIAMPolicy(ctx, folder, bucketName, *iamPolicy)
+}
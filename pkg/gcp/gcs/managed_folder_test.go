@@ -0,0 +1,155 @@
+package gcs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/iam/v1"
+)
+
+// fakePolicyServer serves the IAM policy of a single managed folder and counts the updates
+func fakePolicyServer(t *testing.T, policy *iam.Policy, updates *int) *ManagedFolderClient {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/b/bucket/managedFolders/team-a/iam" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == http.MethodPut {
+			*updates++
+			if err := json.NewDecoder(r.Body).Decode(policy); err != nil {
+				t.Error(err)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(policy)
+	}))
+	t.Cleanup(server.Close)
+	return &ManagedFolderClient{client: server.Client(), endpoint: server.URL + "/b/%s/managedFolders"}
+}
+
+func TestIAMBindings(t *testing.T) {
+	ctx := context.Background()
+	const role, member = "roles/storage.objectViewer", "serviceAccount:reader@project.iam.gserviceaccount.com"
+	policy := &iam.Policy{Bindings: []*iam.Binding{{Role: "roles/storage.folderAdmin", Members: []string{"serviceAccount:owner"}}}}
+	updates := 0
+	c := fakePolicyServer(t, policy, &updates)
+
+	for range 2 {
+		if err := c.AddIAMBinding(ctx, "team-a", "bucket", role, member, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if updates != 1 || len(policy.Bindings) != 2 || len(policy.Bindings[1].Members) != 1 {
+		t.Errorf("expected a single binding after adding it twice, got %d updates and %+v", updates, policy.Bindings)
+	}
+
+	for range 2 {
+		if err := c.RemoveIAMBinding(ctx, "team-a", "bucket", role, member); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if updates != 2 || len(policy.Bindings) != 1 || policy.Bindings[0].Role != "roles/storage.folderAdmin" {
+		t.Errorf("expected the emptied binding to be dropped once, got %d updates and %+v", updates, policy.Bindings)
+	}
+}
+
+func TestExpiringIAMBindings(t *testing.T) {
+	ctx := context.Background()
+	const role, member = "roles/storage.objectViewer", "serviceAccount:contractor@project.iam.gserviceaccount.com"
+	foreign := &iam.Binding{Role: role, Members: []string{member}, Condition: &iam.Expr{Title: "office-hours", Expression: "true"}}
+	policy := &iam.Policy{Bindings: []*iam.Binding{foreign}}
+	updates := 0
+	c := fakePolicyServer(t, policy, &updates)
+
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	if err := c.AddIAMBinding(ctx, "team-a", "bucket", role, member, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddIAMBinding(ctx, "team-a", "bucket", role, member, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if policy.Version != 3 || len(policy.Bindings) != 2 || policy.Bindings[1].Condition == nil ||
+		policy.Bindings[1].Condition.Expression != `request.time < timestamp("2026-10-19T13:00:00Z")` {
+		t.Fatalf("expected the unconditional binding to be replaced by an expiring one, got %+v", policy)
+	}
+
+	removed, err := c.RemoveExpiredBindings(ctx, "team-a", "bucket", now)
+	if err != nil || removed != 0 {
+		t.Fatalf("expected no binding to expire yet, got %d, %v", removed, err)
+	}
+	removed, err = c.RemoveExpiredBindings(ctx, "team-a", "bucket", now.Add(2*time.Hour))
+	if err != nil || removed != 1 {
+		t.Fatalf("expected the expired binding to be removed, got %d, %v", removed, err)
+	}
+	if updates != 3 || len(policy.Bindings) != 1 || policy.Bindings[0].Condition.Title != "office-hours" {
+		t.Errorf("expected only the binding with a foreign condition to remain, got %d updates and %+v", updates, policy.Bindings)
+	}
+}
+
+func TestGetOrCreateManagedFolder(t *testing.T) {
+	ctx := context.Background()
+	folders := map[string]bool{"existing/": true}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/b/bucket/managedFolders":
+			var request managedFolderRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				t.Error(err)
+			}
+			folders[request.Name] = true
+			_ = json.NewEncoder(w).Encode(managedFolderResource{Name: request.Name})
+		case r.Method == http.MethodGet && folders[strings.TrimPrefix(r.URL.Path, "/b/bucket/managedFolders/")]:
+			_ = json.NewEncoder(w).Encode(managedFolderResource{Name: strings.TrimPrefix(r.URL.Path, "/b/bucket/managedFolders/")})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	c := &ManagedFolderClient{client: server.Client(), endpoint: server.URL + "/b/%s/managedFolders"}
+
+	for _, tc := range []struct {
+		folder  string
+		created bool
+	}{
+		{folder: "existing/", created: false},
+		{folder: "new/", created: true},
+		{folder: "new/", created: false},
+	} {
+		name, created, err := c.GetOrCreateManagedFolder(ctx, tc.folder, "bucket")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != tc.folder || created != tc.created {
+			t.Errorf("expected %s to be created %t, got %s created %t", tc.folder, tc.created, name, created)
+		}
+	}
+}
+
+func TestDeleteManagedFolder(t *testing.T) {
+	deleted := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete || r.URL.Path != "/b/bucket/managedFolders/team-a" || deleted > 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("allowNonEmpty") != "true" {
+			t.Errorf("expected the objects to be kept, got %s", r.URL.RawQuery)
+		}
+		deleted++
+	}))
+	t.Cleanup(server.Close)
+	c := &ManagedFolderClient{client: server.Client(), endpoint: server.URL + "/b/%s/managedFolders"}
+
+	for range 2 {
+		if err := c.DeleteManagedFolder(context.Background(), "team-a", "bucket"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if deleted != 1 {
+		t.Errorf("expected a single deletion, got %d", deleted)
+	}
+}
@@ -0,0 +1,93 @@
+package gcs
+
+import (
+	"errors"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// CopyAction is the planned handling of a single object
+type CopyAction string
+
+const (
+	// CopyActionCopy creates a new object at the destination
+	CopyActionCopy CopyAction = "Copy"
+	// CopyActionOverwrite replaces an existing destination object with different content
+	CopyActionOverwrite CopyAction = "Overwrite"
+	// CopyActionSkip leaves an identical destination object untouched
+	CopyActionSkip CopyAction = "Skip"
+)
+
+// Plan summarizes the planned actions of a copy
+type Plan struct {
+	Copy      int
+	Overwrite int
+	Skip      int
+	// Bytes is the amount of bytes written by copies and overwrites
+	Bytes int64
+}
+
+// Add counts the planned actions of the objects
+func (p *Plan) Add(objects []Object) {
+	for _, object := range objects {
+		switch object.Action {
+		case CopyActionCopy:
+			p.Copy++
+			p.Bytes += object.Size
+		case CopyActionOverwrite:
+			p.Overwrite++
+			p.Bytes += object.Size
+		case CopyActionSkip:
+			p.Skip++
+		}
+	}
+}
+
+// ErrOverlappingPrefixes is returned for copies whose destination objects would be listed by the source query again
+var ErrOverlappingPrefixes = errors.New("copy destination overlaps the source listing")
+
+// Relists reports whether objects copied to targetPrefix are listed by the source query again,
+// they would be copied over and over. With a delimiter only objects directly below the prefix are listed.
+func Relists(sq storage.Query, targetPrefix string) bool {
+	rest, found := strings.CutPrefix(targetPrefix, sq.Prefix)
+	return found && (sq.Delimiter == "" || !strings.Contains(rest, sq.Delimiter))
+}
+
+// destinationQuery lists the objects below the target prefix.
+// A StartOffset of the source query is applied to the destination listing as well.
+func destinationQuery(sq storage.Query, targetPrefix string) storage.Query {
+	destinationQuery := storage.Query{Prefix: targetPrefix, Delimiter: sq.Delimiter}
+	if offset, found := strings.CutPrefix(sq.StartOffset, sq.Prefix); found && sq.StartOffset != "" {
+		destinationQuery.StartOffset = targetPrefix + offset
+	}
+	return destinationQuery
+}
+
+// planObjects decides the action of every source object.
+// Rewriting the prefix keeps the lexicographic order of the listing,
+// so the destination listing is walked alongside the source pages.
+func planObjects(sources []Object, destinations *cursor, prefix, targetPrefix string) error {
+	for i := range sources {
+		source := &sources[i]
+		targetPath, found := strings.CutPrefix(source.Key, prefix)
+		if !found {
+			return errors.New("did not found prefix on obj")
+		}
+		source.Destination = targetPrefix + targetPath
+
+		destination, err := destinations.seek(source.Destination)
+		if err != nil {
+			return err
+		}
+		switch {
+		case destination == nil || destination.Key != source.Destination:
+			source.Action = CopyActionCopy
+		case destination.Size == source.Size && destination.CRC32C == source.CRC32C:
+			source.Action = CopyActionSkip
+		default:
+			source.Action = CopyActionOverwrite
+		}
+	}
+	return nil
+}
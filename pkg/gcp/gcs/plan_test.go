@@ -0,0 +1,80 @@
+package gcs
+
+import (
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+// pagesOf serves the objects as a listing with the given page size
+func pagesOf(objects []Object, size int) pageFunc {
+	return func() ([]Object, bool, error) {
+		page := objects[:min(size, len(objects))]
+		objects = objects[len(page):]
+		return page, len(objects) == 0, nil
+	}
+}
+
+func TestPlanObjects(t *testing.T) {
+	sources := []Object{
+		{Key: "src/a", Size: 1, CRC32C: "aaaa"},
+		{Key: "src/b", Size: 2, CRC32C: "bbbb"},
+		{Key: "src/c/d", Size: 3, CRC32C: "cccc"},
+	}
+	destinations := []Object{
+		{Key: "dst/0", Size: 1, CRC32C: "0000"},
+		{Key: "dst/a", Size: 1, CRC32C: "aaaa"},
+		{Key: "dst/b", Size: 2, CRC32C: "xxxx"},
+	}
+
+	// The sources arrive page by page, the destination listing is shared between them
+	destinationCursor := &cursor{next: pagesOf(destinations, 2)}
+	for _, page := range [][]Object{sources[:2], sources[2:]} {
+		if err := planObjects(page, destinationCursor, "src/", "dst/"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []struct {
+		destination string
+		action      CopyAction
+	}{
+		{"dst/a", CopyActionSkip},
+		{"dst/b", CopyActionOverwrite},
+		{"dst/c/d", CopyActionCopy},
+	}
+	for i, w := range want {
+		if sources[i].Destination != w.destination || sources[i].Action != w.action {
+			t.Errorf("object %s: got %s %s, want %s %s", sources[i].Key,
+				sources[i].Destination, sources[i].Action, w.destination, w.action)
+		}
+	}
+
+	var plan Plan
+	plan.Add(sources)
+	if plan.Copy != 1 || plan.Overwrite != 1 || plan.Skip != 1 || plan.Bytes != 5 {
+		t.Errorf("unexpected plan %+v", plan)
+	}
+}
+
+func TestRelists(t *testing.T) {
+	tests := []struct {
+		prefix, delimiter, target string
+		relists                   bool
+	}{
+		{"src/", "", "dst/", false},
+		{"src/", "", "src/copy/", true},
+		{"src/", "", "src/", true},
+		{"", "", "copy/", true},
+		// With a delimiter only objects directly below the prefix are listed
+		{"src/", "/", "src/copy/", false},
+		{"src/", "/", "src/copy-", true},
+		{"src/a", "", "src/", false},
+	}
+	for _, tt := range tests {
+		sq := storage.Query{Prefix: tt.prefix, Delimiter: tt.delimiter}
+		if got := Relists(sq, tt.target); got != tt.relists {
+			t.Errorf("Relists(%q, %q, %q) = %v, want %v", tt.prefix, tt.delimiter, tt.target, got, tt.relists)
+		}
+	}
+}
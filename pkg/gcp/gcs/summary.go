@@ -0,0 +1,87 @@
+package gcs
+
+import "strings"
+
+// PrefixSummary is the object count and size below a prefix
+type PrefixSummary struct {
+	Prefix  string
+	Objects int
+	Bytes   int64
+}
+
+// Summarizer groups listed objects by their immediate sub-prefix, like du does for directories.
+// Objects sharing a prefix are contiguous in a listing, so only the summaries are kept, not the objects.
+type Summarizer struct {
+	prefix    string
+	delimiter string
+	limit     int
+	// direct counts the objects directly below the prefix, they are spread across the listing
+	direct    PrefixSummary
+	prefixes  []PrefixSummary
+	truncated bool
+}
+
+// NewSummarizer keeps at most limit sub-prefixes, the delimiter defaults to "/"
+func NewSummarizer(prefix, delimiter string, limit int) *Summarizer {
+	if delimiter == "" {
+		delimiter = "/"
+	}
+	return &Summarizer{prefix: prefix, delimiter: delimiter, limit: limit, direct: PrefixSummary{Prefix: prefix}}
+}
+
+// Continue picks up the summaries of an interrupted listing resumed after the last summarized object
+func (s *Summarizer) Continue(previous []PrefixSummary, truncated bool) {
+	for _, summary := range previous {
+		if summary.Prefix == s.prefix {
+			s.direct = summary
+			continue
+		}
+		s.prefixes = append(s.prefixes, summary)
+	}
+	s.truncated = truncated
+}
+
+// Add counts the objects, they have to arrive in listing order
+func (s *Summarizer) Add(objects []Object) {
+	for _, object := range objects {
+		group := s.group(object.Key)
+		summary := &s.direct
+		if group != s.prefix {
+			last := len(s.prefixes) - 1
+			if last < 0 || s.prefixes[last].Prefix != group {
+				if len(s.prefixes) >= s.limit {
+					s.truncated = true
+					continue
+				}
+				s.prefixes = append(s.prefixes, PrefixSummary{Prefix: group})
+				last++
+			}
+			summary = &s.prefixes[last]
+		}
+		summary.Objects++
+		summary.Bytes += object.Size
+	}
+}
+
+// group returns the immediate sub-prefix of the key, the prefix itself for objects directly below it
+func (s *Summarizer) group(key string) string {
+	rest := strings.TrimPrefix(key, s.prefix)
+	if i := strings.Index(rest, s.delimiter); i >= 0 {
+		return s.prefix + rest[:i+len(s.delimiter)]
+	}
+	return s.prefix
+}
+
+// Summaries returns the objects directly below the prefix first, followed by the sub-prefixes in listing order
+func (s *Summarizer) Summaries() []PrefixSummary {
+	summaries := make([]PrefixSummary, 0, len(s.prefixes)+1)
+	if s.direct.Objects > 0 {
+		summaries = append(summaries, s.direct)
+	}
+	return append(summaries, s.prefixes...)
+}
+
+// Truncated reports whether sub-prefixes were dropped because of the limit
+func (s *Summarizer) Truncated() bool {
+	return s.truncated
+}
@@ -0,0 +1,40 @@
+package gcs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSummarizer(t *testing.T) {
+	objects := []Object{
+		{Key: "data/a.txt", Size: 1},
+		{Key: "data/b/1", Size: 2},
+		{Key: "data/b/c/2", Size: 3},
+		{Key: "data/b0", Size: 4},
+		{Key: "data/c/3", Size: 5},
+		{Key: "data/d/4", Size: 6},
+	}
+
+	summarizer := NewSummarizer("data/", "", 2)
+	summarizer.Add(objects[:2])
+	summarizer.Add(objects[2:])
+
+	want := []PrefixSummary{
+		{Prefix: "data/", Objects: 2, Bytes: 5},
+		{Prefix: "data/b/", Objects: 2, Bytes: 5},
+		{Prefix: "data/c/", Objects: 1, Bytes: 5},
+	}
+	if got := summarizer.Summaries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if !summarizer.Truncated() {
+		t.Error("expected the summary to be truncated")
+	}
+
+	resumed := NewSummarizer("data/", "/", 3)
+	resumed.Continue(want, false)
+	resumed.Add(objects[4:])
+	if got := resumed.Summaries(); len(got) != 4 || got[2].Objects != 2 || got[3].Prefix != "data/d/" {
+		t.Errorf("unexpected resumed summary %+v", got)
+	}
+}
@@ -0,0 +1,63 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/retry"
+)
+
+// CreateHMACKey creates an HMAC key for the service account, its secret is only returned this once
+func (p Client) CreateHMACKey(ctx context.Context, serviceAccountEmail string) (*storage.HMACKey, error) {
+	key, err := p.gcs.CreateHMACKey(ctx, p.projectID, serviceAccountEmail)
+	if err != nil {
+		return nil, fmt.Errorf("CreateHMACKey: %w", err)
+	}
+	return key, nil
+}
+
+// ListHMACKeys returns the HMAC keys of the service account which are not deleted yet
+func (p Client) ListHMACKeys(ctx context.Context, serviceAccountEmail string) ([]*storage.HMACKey, error) {
+	it := p.gcs.ListHMACKeys(ctx, p.projectID, storage.ForHMACKeyServiceAccountEmail(serviceAccountEmail))
+	var keys []*storage.HMACKey
+	for {
+		key, err := it.Next()
+		if err == iterator.Done {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ListHMACKeys: %w", err)
+		}
+		keys = append(keys, key)
+	}
+}
+
+// DeleteHMACKey deactivates the HMAC key and deletes it, only inactive keys can be deleted.
+// A key which no longer exists is considered deleted.
+func (p Client) DeleteHMACKey(ctx context.Context, accessID string) error {
+	handle := p.gcs.HMACKeyHandle(p.projectID, accessID)
+	key, err := handle.Get(ctx)
+	if retry.Reason(err) == retry.ReasonNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("DeleteHMACKey: %w", err)
+	}
+	if key.State == storage.Deleted {
+		return nil
+	}
+	if key.State == storage.Active {
+		_, err = handle.Update(ctx, storage.HMACKeyAttrsToUpdate{State: storage.Inactive})
+		if err != nil {
+			return fmt.Errorf("DeleteHMACKey: deactivate: %w", err)
+		}
+	}
+	err = handle.Delete(ctx)
+	if err != nil && retry.Reason(err) != retry.ReasonNotFound {
+		return fmt.Errorf("DeleteHMACKey: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"google.golang.org/api/cloudresourcemanager/v1"
+
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/retry"
+)
+
+// workloadIdentityPrincipalFormat is the IAM principal of a Kubernetes ServiceAccount in the workload identity pool
+// of the project: project number, project id, namespace and ServiceAccount
+const workloadIdentityPrincipalFormat = "principal://iam.googleapis.com/projects/%s/locations/global/workloadIdentityPools/%s.svc.id.goog/subject/ns/%s/sa/%s"
+
+// WorkloadIdentityPrincipal is the principal of a Kubernetes ServiceAccount, IAM roles can be granted to it
+// without a service account
+func (p Client) WorkloadIdentityPrincipal(projectNumber, kubernetesNamespace, kubernetesSA string) string {
+	return fmt.Sprintf(workloadIdentityPrincipalFormat, projectNumber, p.projectID, kubernetesNamespace, kubernetesSA)
+}
+
+// LookupProjectNumber fetches the number of the project, it needs resourcemanager.projects.get
+func (p Client) LookupProjectNumber(ctx context.Context) (string, error) {
+	service, err := cloudresourcemanager.NewService(ctx)
+	if err != nil {
+		return "", fmt.Errorf("LookupProjectNumber: %w", err)
+	}
+	var project *cloudresourcemanager.Project
+	err = retry.Default.Do(ctx, func(ctx context.Context) (err error) {
+		project, err = service.Projects.Get(p.projectID).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("LookupProjectNumber: %w", err)
+	}
+	return strconv.FormatInt(project.ProjectNumber, 10), nil
+}
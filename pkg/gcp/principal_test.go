@@ -0,0 +1,11 @@
+package gcp
+
+import "testing"
+
+func TestWorkloadIdentityPrincipal(t *testing.T) {
+	c := Client{projectID: "my-project"}
+	want := "principal://iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/my-project.svc.id.goog/subject/ns/team-a/sa/data-owner"
+	if got := c.WorkloadIdentityPrincipal("123", "team-a", "data-owner"); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
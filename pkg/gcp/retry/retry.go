@@ -0,0 +1,117 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/googleapi"
+)
+
+// Reasons of permanent errors, used as condition reasons
+const (
+	ReasonPermissionDenied = "PermissionDenied"
+	ReasonNotFound         = "NotFound"
+	ReasonInvalidRequest   = "InvalidRequest"
+)
+
+// Policy retries transient errors with exponential backoff and full jitter
+type Policy struct {
+	// Initial is the upper bound of the first pause
+	Initial time.Duration
+	// Max caps the pause between two attempts
+	Max time.Duration
+	// Multiplier grows the pause after every attempt
+	Multiplier float64
+	// MaxAttempts includes the first call
+	MaxAttempts int
+	// Retryable classifies the errors worth another attempt
+	Retryable func(err error) bool
+}
+
+// Default is the policy of all storage and IAM calls
+var Default = Policy{
+	Initial:     500 * time.Millisecond,
+	Max:         30 * time.Second,
+	Multiplier:  2,
+	MaxAttempts: 5,
+	Retryable:   IsRetryable,
+}
+
+// Do calls fn until it succeeds, fails with a non retryable error or the attempts are used up
+func (p Policy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	backoff := p.backoff()
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn(ctx)
+		if err == nil || attempt >= p.MaxAttempts || !p.Retryable(err) {
+			break
+		}
+		if sleepErr := gax.Sleep(ctx, backoff.Pause()); sleepErr != nil {
+			return fmt.Errorf("%w (retry stopped: %w)", err, sleepErr)
+		}
+	}
+	return err
+}
+
+// StorageOptions applies the policy to a storage client. Every call is retried,
+// including copies and uploads which the client only retries with preconditions by default.
+func (p Policy) StorageOptions() []storage.RetryOption {
+	return []storage.RetryOption{
+		storage.WithBackoff(p.backoff()),
+		storage.WithMaxAttempts(p.MaxAttempts),
+		storage.WithPolicy(storage.RetryAlways),
+		storage.WithErrorFunc(p.Retryable),
+	}
+}
+
+func (p Policy) backoff() gax.Backoff {
+	return gax.Backoff{Initial: p.Initial, Max: p.Max, Multiplier: p.Multiplier}
+}
+
+// IsRetryable reports whether err is transient: timeouts, rate limits, server errors and broken connections
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if code := statusCode(err); code != 0 {
+		return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Reason returns the condition reason of a permanent error, empty if retrying later might succeed
+func Reason(err error) string {
+	if errors.Is(err, storage.ErrBucketNotExist) {
+		return ReasonNotFound
+	}
+	switch statusCode(err) {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ReasonPermissionDenied
+	case http.StatusNotFound:
+		return ReasonNotFound
+	case http.StatusBadRequest:
+		return ReasonInvalidRequest
+	}
+	return ""
+}
+
+// IsPermanent reports whether err will not go away by retrying, e.g. missing permissions or a missing bucket
+func IsPermanent(err error) bool {
+	return Reason(err) != ""
+}
+
+func statusCode(err error) int {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	return 0
+}
@@ -0,0 +1,60 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+		reason    string
+	}{
+		{&googleapi.Error{Code: http.StatusTooManyRequests}, true, ""},
+		{fmt.Errorf("copy: %w", &googleapi.Error{Code: http.StatusServiceUnavailable}), true, ""},
+		{&googleapi.Error{Code: http.StatusForbidden}, false, ReasonPermissionDenied},
+		{&googleapi.Error{Code: http.StatusNotFound}, false, ReasonNotFound},
+		{fmt.Errorf("list: %w", storage.ErrBucketNotExist), false, ReasonNotFound},
+		{context.Canceled, false, ""},
+	}
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.retryable {
+			t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.retryable)
+		}
+		if got := Reason(tt.err); got != tt.reason {
+			t.Errorf("Reason(%v) = %q, want %q", tt.err, got, tt.reason)
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	policy := Default
+	policy.Initial = time.Millisecond
+	policy.MaxAttempts = 3
+
+	attempts := 0
+	err := policy.Do(context.Background(), func(context.Context) error {
+		attempts++
+		return &googleapi.Error{Code: http.StatusInternalServerError}
+	})
+	if err == nil || attempts != 3 {
+		t.Errorf("expected 3 failed attempts, got %d: %v", attempts, err)
+	}
+
+	attempts = 0
+	err = policy.Do(context.Background(), func(context.Context) error {
+		attempts++
+		return &googleapi.Error{Code: http.StatusForbidden}
+	})
+	if !errors.As(err, new(*googleapi.Error)) || attempts != 1 {
+		t.Errorf("expected a single attempt for a permanent error, got %d: %v", attempts, err)
+	}
+}
@@ -2,21 +2,63 @@ package gcp
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iam/v1"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/retry"
 )
 
-func (p Client) getOrCreateServiceAccount(ctx context.Context, saName, displayName string) (*iam.ServiceAccount, error) {
+// ErrNotOwned is returned for resources which already exist, were not created by the operator and may not be adopted
+var ErrNotOwned = errors.New("already exists and is not owned by the operator")
+
+// ownerDescription marks the service accounts created by the operator, along with the resource they were created for
+func ownerDescription(owner string) string {
+	return "Managed by cloud-storage-file-operator for " + owner
+}
+
+// maxAccountIDLength is the limit of service account IDs
+const maxAccountIDLength = 30
+
+// AccountID shortens the service account ID to the 30 characters GCP allows. Longer IDs are truncated and suffixed
+// with a hash of the full ID, keeping IDs of different resources apart.
+func AccountID(id string) string {
+	if len(id) <= maxAccountIDLength {
+		return id
+	}
+	sum := sha256.Sum256([]byte(id))
+	suffix := hex.EncodeToString(sum[:])[:8]
+	return strings.TrimRight(id[:maxAccountIDLength-len(suffix)-1], "-") + "-" + suffix
+}
+
+// ServiceAccountEmail is the email of the service account of the project
+func (p Client) ServiceAccountEmail(saName string) string {
+	return fmt.Sprintf("%s@%s.iam.gserviceaccount.com", saName, p.projectID)
+}
+
+// managedByOperator reports whether the operator created the service account, for whichever owner
+func managedByOperator(account *iam.ServiceAccount) bool {
+	return strings.HasPrefix(account.Description, ownerDescription(""))
+}
+
+func (p Client) getOrCreateServiceAccount(ctx context.Context, saName, displayName, description string) (*iam.ServiceAccount, error) {
 	logger := log.FromContext(ctx)
 
-	email := fmt.Sprintf("%s@%s.iam.gserviceaccount.com", saName, p.projectID)
+	email := p.ServiceAccountEmail(saName)
 	serviceAccountLongName := fmt.Sprintf("projects/%s/serviceAccounts/%s", p.projectID, email)
 
-	account, err := p.client.Projects.ServiceAccounts.Get(serviceAccountLongName).Context(ctx).Do()
+	var account *iam.ServiceAccount
+	err := retry.Default.Do(ctx, func(ctx context.Context) (err error) {
+		account, err = p.client.Projects.ServiceAccounts.Get(serviceAccountLongName).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		var e *googleapi.Error
 		if errors.As(err, &e) {
@@ -38,10 +80,15 @@ func (p Client) getOrCreateServiceAccount(ctx context.Context, saName, displayNa
 		AccountId: saName,
 		ServiceAccount: &iam.ServiceAccount{
 			DisplayName: displayName,
+			Description: description,
 		},
 	}
-	createdAccount, err := p.client.Projects.ServiceAccounts.Create("projects/"+p.projectID, request).
-		Context(ctx).Do()
+	var createdAccount *iam.ServiceAccount
+	err = retry.Default.Do(ctx, func(ctx context.Context) (err error) {
+		createdAccount, err = p.client.Projects.ServiceAccounts.Create("projects/"+p.projectID, request).
+			Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("Projects.ServiceAccounts.Create: %w\n", err)
 	}
@@ -49,8 +96,41 @@ func (p Client) getOrCreateServiceAccount(ctx context.Context, saName, displayNa
 	return createdAccount, nil
 }
 
+func (p Client) deleteServiceAccount(ctx context.Context, email, description string) error {
+	serviceAccountLongName := fmt.Sprintf("projects/%s/serviceAccounts/%s", p.projectID, email)
+
+	var account *iam.ServiceAccount
+	err := retry.Default.Do(ctx, func(ctx context.Context) (err error) {
+		account, err = p.client.Projects.ServiceAccounts.Get(serviceAccountLongName).Context(ctx).Do()
+		return err
+	})
+	if retry.Reason(err) == retry.ReasonNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("deleteServiceAccount: %w", err)
+	}
+	if account.Description != description {
+		return fmt.Errorf("deleteServiceAccount: %w: service account %s (%s)", ErrNotOwned, email, account.Description)
+	}
+
+	err = retry.Default.Do(ctx, func(ctx context.Context) error {
+		_, err := p.client.Projects.ServiceAccounts.Delete(serviceAccountLongName).Context(ctx).Do()
+		return err
+	})
+	if err != nil && retry.Reason(err) != retry.ReasonNotFound {
+		return fmt.Errorf("deleteServiceAccount: %w", err)
+	}
+	log.FromContext(ctx).Info("service account deleted", "serviceAccount", serviceAccountLongName)
+	return nil
+}
+
 func (p Client) addBindingOnSA(ctx context.Context, sa *iam.ServiceAccount, member, role string) error {
-	saPolicy, err := p.client.Projects.ServiceAccounts.GetIamPolicy(sa.Name).Context(ctx).Do()
+	var saPolicy *iam.Policy
+	err := retry.Default.Do(ctx, func(ctx context.Context) (err error) {
+		saPolicy, err = p.client.Projects.ServiceAccounts.GetIamPolicy(sa.Name).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("addBindingOnSA: Projects.GetIamPolicy: %w", err)
 	}
@@ -68,6 +148,9 @@ func (p Client) addBindingOnSA(ctx context.Context, sa *iam.ServiceAccount, memb
 	}
 
 	if binding != nil {
+		if slices.Contains(binding.Members, member) {
+			return nil
+		}
 		// If the binding exists, adds the member to the binding
 		binding.Members = append(binding.Members, member)
 	} else {
@@ -77,11 +160,15 @@ func (p Client) addBindingOnSA(ctx context.Context, sa *iam.ServiceAccount, memb
 		request.Policy.Bindings = append(request.Policy.Bindings, binding)
 	}
 
-	updatedPolicy, err := p.client.Projects.ServiceAccounts.SetIamPolicy(sa.Name, request).Context(ctx).Do()
+	var updatedPolicy *iam.Policy
+	err = retry.Default.Do(ctx, func(ctx context.Context) (err error) {
+		updatedPolicy, err = p.client.Projects.ServiceAccounts.SetIamPolicy(sa.Name, request).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("addBindingOnSA: Projects.SetIamPolicy: %w", err)
 	}
 
-	fmt.Printf("updated policy %s", updatedPolicy.Bindings)
+	log.FromContext(ctx).V(2).Info("updated policy", "bindings", updatedPolicy.Bindings)
 	return nil
 }
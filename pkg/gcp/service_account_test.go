@@ -0,0 +1,117 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/iam/v1"
+	"google.golang.org/api/option"
+)
+
+// fakeIAM serves the service accounts of the project "project", keyed by their email
+func fakeIAM(t *testing.T, accounts map[string]*iam.ServiceAccount) Client {
+	const prefix = "/v1/projects/project/serviceAccounts"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, prefix)
+		switch {
+		case strings.HasSuffix(path, ":getIamPolicy"), strings.HasSuffix(path, ":setIamPolicy"):
+			_ = json.NewEncoder(w).Encode(&iam.Policy{})
+		case r.Method == http.MethodPost && path == "":
+			var request iam.CreateServiceAccountRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				t.Error(err)
+			}
+			account := request.ServiceAccount
+			account.Email = request.AccountId + "@project.iam.gserviceaccount.com"
+			account.Name = "projects/project/serviceAccounts/" + account.Email
+			accounts[account.Email] = account
+			_ = json.NewEncoder(w).Encode(account)
+		case accounts[strings.TrimPrefix(path, "/")] == nil:
+			http.Error(w, `{"error": {"code": 404, "message": "not found"}}`, http.StatusNotFound)
+		case r.Method == http.MethodDelete:
+			delete(accounts, strings.TrimPrefix(path, "/"))
+			_, _ = w.Write([]byte("{}"))
+		default:
+			_ = json.NewEncoder(w).Encode(accounts[strings.TrimPrefix(path, "/")])
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	service, err := iam.NewService(context.Background(),
+		option.WithEndpoint(server.URL+"/"), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Client{client: service, projectID: "project"}
+}
+
+func TestCreateServiceAccountOwnership(t *testing.T) {
+	ctx := context.Background()
+	const email = "a-b-c@project.iam.gserviceaccount.com"
+	accounts := map[string]*iam.ServiceAccount{}
+	c := fakeIAM(t, accounts)
+
+	account, owned, err := c.CreateServiceAccount(ctx, "a-b-c", "a-b-owner", "c", "Folder c/a-b", false)
+	if err != nil || !owned || account.Email != email {
+		t.Fatalf("expected the service account to be created and owned, got %v", err)
+	}
+	if _, owned, err := c.CreateServiceAccount(ctx, "a-b-c", "a-b-owner", "c", "Folder c/a-b", false); err != nil || !owned {
+		t.Errorf("expected the own service account to be used again, got %v", err)
+	}
+
+	// Folder a in namespace b-c maps to the same name, it is never adopted
+	_, _, err = c.CreateServiceAccount(ctx, "a-b-c", "a-owner", "b-c", "Folder b-c/a", true)
+	if !errors.Is(err, ErrNotOwned) {
+		t.Errorf("expected the service account of another Folder not to be adopted, got %v", err)
+	}
+
+	// Service accounts created outside of the operator are only adopted if allowed
+	accounts[email].Description = "created by hand"
+	if _, _, err := c.CreateServiceAccount(ctx, "a-b-c", "a-b-owner", "c", "Folder c/a-b", false); !errors.Is(err, ErrNotOwned) {
+		t.Errorf("expected ErrNotOwned without adoption, got %v", err)
+	}
+	if _, owned, err := c.CreateServiceAccount(ctx, "a-b-c", "a-b-owner", "c", "Folder c/a-b", true); err != nil || owned {
+		t.Errorf("expected the service account to be adopted, got %v", err)
+	}
+}
+
+func TestDeleteServiceAccount(t *testing.T) {
+	ctx := context.Background()
+	const email = "a-b-c@project.iam.gserviceaccount.com"
+	accounts := map[string]*iam.ServiceAccount{
+		email: {Email: email, Description: ownerDescription("Folder b-c/a")},
+	}
+	c := fakeIAM(t, accounts)
+
+	if err := c.DeleteServiceAccount(ctx, email, "Folder c/a-b"); !errors.Is(err, ErrNotOwned) || accounts[email] == nil {
+		t.Errorf("expected the service account of another Folder to be kept, got %v", err)
+	}
+	if err := c.DeleteServiceAccount(ctx, email, "Folder b-c/a"); err != nil || accounts[email] != nil {
+		t.Errorf("expected the own service account to be deleted, got %v", err)
+	}
+	if err := c.DeleteServiceAccount(ctx, email, "Folder b-c/a"); err != nil {
+		t.Errorf("expected a deleted service account to be ignored, got %v", err)
+	}
+}
+
+func TestAccountID(t *testing.T) {
+	if id := AccountID("fa-data-team-a"); id != "fa-data-team-a" {
+		t.Errorf("expected short IDs to be kept, got %s", id)
+	}
+	long := AccountID("fa-analytics-reader-team-analytics")
+	other := AccountID("fa-analytics-reader-team-analytics-eu")
+	if len(long) > 30 || len(other) > 30 {
+		t.Errorf("expected IDs of at most 30 characters, got %s and %s", long, other)
+	}
+	if long == other {
+		t.Errorf("expected IDs with the same prefix to differ, got %s", long)
+	}
+	if !strings.HasPrefix(long, "fa-analytics-reader-t") {
+		t.Errorf("expected the truncated ID to keep its prefix, got %s", long)
+	}
+}
@@ -0,0 +1,49 @@
+package gcp
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iamcredentials/v1"
+
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/retry"
+)
+
+// MaxSignedURLExpiry is the longest validity of a V4 signed URL
+const MaxSignedURLExpiry = 7 * 24 * time.Hour
+
+// SignedURL returns a V4 signed URL of the object allowing the method until expires.
+// It is signed as the service account through the IAM signBlob API, so no key is needed,
+// the operator needs roles/iam.serviceAccountTokenCreator on the service account.
+func (p Client) SignedURL(ctx context.Context, bucketName, object, method, email string, expires time.Time) (string, error) {
+	signedURL, err := storage.SignedURL(bucketName, object, &storage.SignedURLOptions{
+		GoogleAccessID: email,
+		Method:         method,
+		Expires:        expires,
+		Scheme:         storage.SigningSchemeV4,
+		SignBytes: func(payload []byte) ([]byte, error) {
+			return p.signBlob(ctx, email, payload)
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("SignedURL: %w", err)
+	}
+	return signedURL, nil
+}
+
+func (p Client) signBlob(ctx context.Context, email string, payload []byte) ([]byte, error) {
+	name := "projects/-/serviceAccounts/" + email
+	request := &iamcredentials.SignBlobRequest{Payload: base64.StdEncoding.EncodeToString(payload)}
+	var response *iamcredentials.SignBlobResponse
+	err := retry.Default.Do(ctx, func(ctx context.Context) (err error) {
+		response, err = p.credentials.Projects.ServiceAccounts.SignBlob(name, request).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("signBlob: %w", err)
+	}
+	return base64.StdEncoding.DecodeString(response.SignedBlob)
+}
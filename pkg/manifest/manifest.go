@@ -0,0 +1,99 @@
+package manifest
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/gcs"
+)
+
+var csvHeader = []string{"key", "size", "generation", "crc32c", "md5", "destination", "action", "result", "error"}
+
+// FileName is the data key of the manifest inside a ConfigMap
+func FileName(format csfov1alpha1.ManifestFormat) string {
+	if format == csfov1alpha1.ManifestFormatCSV {
+		return "manifest.csv"
+	}
+	return "manifest.jsonl"
+}
+
+// ContentType of the manifest object
+func ContentType(format csfov1alpha1.ManifestFormat) string {
+	if format == csfov1alpha1.ManifestFormatCSV {
+		return "text/csv"
+	}
+	return "application/jsonl"
+}
+
+// Writer streams manifest rows in the given format, JSONL is used by default
+type Writer struct {
+	json   *json.Encoder
+	csv    *csv.Writer
+	header bool
+}
+
+// NewWriter returns a Writer encoding to w
+func NewWriter(w io.Writer, format csfov1alpha1.ManifestFormat) *Writer {
+	if format == csfov1alpha1.ManifestFormatCSV {
+		return &Writer{csv: csv.NewWriter(w)}
+	}
+	return &Writer{json: json.NewEncoder(w)}
+}
+
+// Write appends one row per object
+func (m *Writer) Write(objects []gcs.Object) error {
+	if m.csv != nil {
+		return m.writeCSV(objects)
+	}
+	for _, object := range objects {
+		if err := m.json.Encode(object); err != nil {
+			return fmt.Errorf("manifest.Write: %w", err)
+		}
+	}
+	return nil
+}
+
+// Flush writes any buffered rows, a CSV manifest always gets its header
+func (m *Writer) Flush() error {
+	if m.csv == nil {
+		return nil
+	}
+	if err := m.writeCSV(nil); err != nil {
+		return err
+	}
+	m.csv.Flush()
+	if err := m.csv.Error(); err != nil {
+		return fmt.Errorf("manifest.Flush: %w", err)
+	}
+	return nil
+}
+
+func (m *Writer) writeCSV(objects []gcs.Object) error {
+	if !m.header {
+		if err := m.csv.Write(csvHeader); err != nil {
+			return fmt.Errorf("manifest.Write: %w", err)
+		}
+		m.header = true
+	}
+	for _, object := range objects {
+		err := m.csv.Write([]string{
+			object.Key,
+			strconv.FormatInt(object.Size, 10),
+			strconv.FormatInt(object.Generation, 10),
+			object.CRC32C,
+			object.MD5,
+			object.Destination,
+			string(object.Action),
+			string(object.Result),
+			object.Error,
+		})
+		if err != nil {
+			return fmt.Errorf("manifest.Write: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package manifest
+
+import (
+	"bytes"
+	"testing"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/gcp/gcs"
+)
+
+var objects = []gcs.Object{
+	{Key: "in/a.txt", Size: 3, Generation: 7, CRC32C: "AAAAAA==", Destination: "out/a.txt", Result: gcs.CopyResultCopied},
+	{Key: "in/b,c.txt", Size: 0, Generation: 8, Result: gcs.CopyResultFailed, Error: "denied"},
+}
+
+func TestWriterJSONL(t *testing.T) {
+	var out bytes.Buffer
+	writer := NewWriter(&out, csfov1alpha1.ManifestFormatJSONL)
+	if err := writer.Write(objects); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"key":"in/a.txt","size":3,"generation":7,"crc32c":"AAAAAA==","destination":"out/a.txt","result":"Copied"}
+{"key":"in/b,c.txt","size":0,"generation":8,"crc32c":"","result":"Failed","error":"denied"}
+`
+	if out.String() != want {
+		t.Errorf("unexpected manifest:\n%s", out.String())
+	}
+}
+
+func TestWriterCSV(t *testing.T) {
+	var out bytes.Buffer
+	writer := NewWriter(&out, csfov1alpha1.ManifestFormatCSV)
+	// Rows written in several batches share a single header
+	if err := writer.Write(objects[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Write(objects[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := `key,size,generation,crc32c,md5,destination,action,result,error
+in/a.txt,3,7,AAAAAA==,,out/a.txt,,Copied,
+"in/b,c.txt",0,8,,,,,Failed,denied
+`
+	if out.String() != want {
+		t.Errorf("unexpected manifest:\n%s", out.String())
+	}
+}
+
+func TestWriterEmptyCSV(t *testing.T) {
+	var out bytes.Buffer
+	if err := NewWriter(&out, csfov1alpha1.ManifestFormatCSV).Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "key,size,generation,crc32c,md5,destination,action,result,error\n" {
+		t.Errorf("expected only the header, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := NewWriter(&out, csfov1alpha1.ManifestFormatJSONL).Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected an empty JSONL manifest, got %q", out.String())
+	}
+}
+
+func TestFormat(t *testing.T) {
+	if FileName(csfov1alpha1.ManifestFormatCSV) != "manifest.csv" || ContentType(csfov1alpha1.ManifestFormatCSV) != "text/csv" {
+		t.Error("unexpected file name or content type of CSV manifests")
+	}
+	// JSONL is the default
+	if FileName("") != "manifest.jsonl" || ContentType("") != "application/jsonl" {
+		t.Error("unexpected file name or content type of JSONL manifests")
+	}
+}
@@ -0,0 +1,37 @@
+package resources
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CredentialConfigKey is the key of the credential configuration inside the ConfigMap
+const CredentialConfigKey = "credential-configuration.json"
+
+// CredentialConfigMap creates a ConfigMap holding a credential configuration, to be mounted by workloads
+// and referenced by GOOGLE_APPLICATION_CREDENTIALS. An existing ConfigMap not owned by owner fails with ErrNotOwned.
+func CredentialConfigMap(ctx context.Context, client client.Client,
+	owner client.Object, name, namespace string, config []byte,
+) (*v1.ConfigMap, error) {
+	configMap := v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	_, err := ctrl.CreateOrUpdate(ctx, client, &configMap, func() error {
+		if err := checkOwned(&configMap, owner); err != nil {
+			return err
+		}
+		configMap.Data = map[string]string{CredentialConfigKey: string(config)}
+		return ctrl.SetControllerReference(owner, &configMap, client.Scheme())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &configMap, nil
+}
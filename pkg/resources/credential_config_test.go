@@ -0,0 +1,59 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+)
+
+func TestCredentialConfigMap(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = csfov1alpha1.AddToScheme(scheme)
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "team-a"},
+			Data:       map[string]string{"app.yaml": "debug: true"},
+		},
+	).Build()
+	folder := &csfov1alpha1.Folder{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "team-a", UID: "data-uid"},
+	}
+	ctx := context.Background()
+
+	if _, err := CredentialConfigMap(ctx, c, folder, "settings", "team-a", []byte("{}")); !errors.Is(err, ErrNotOwned) {
+		t.Errorf("expected ErrNotOwned, got %v", err)
+	}
+	if _, err := CredentialConfigMap(ctx, c, folder, "data-credentials", "team-a", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the ConfigMap of the Folder is deleted
+	settings := types.NamespacedName{Name: "settings", Namespace: "team-a"}
+	if err := DeleteOwned(ctx, c, settings, &v1.ConfigMap{}, folder); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, settings, &v1.ConfigMap{}); err != nil {
+		t.Errorf("expected the ConfigMap of the user to be kept, got %v", err)
+	}
+	credentials := types.NamespacedName{Name: "data-credentials", Namespace: "team-a"}
+	for range 2 {
+		if err := DeleteOwned(ctx, c, credentials, &v1.ConfigMap{}, folder); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Get(ctx, credentials, &v1.ConfigMap{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the ConfigMap of the Folder to be deleted, got %v", err)
+	}
+}
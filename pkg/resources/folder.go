@@ -0,0 +1,96 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+)
+
+// FolderTemplateLabel marks the Folders created from a FolderTemplate with the name of the template
+const FolderTemplateLabel = "csfo.sijoma.dev/folder-template"
+
+// ErrInvalidTemplate is returned for templates which can not be executed for a namespace,
+// e.g. referencing a label the namespace does not have
+var ErrInvalidTemplate = errors.New("invalid folder template")
+
+// ErrFolderConflict is returned when a Folder of the same name exists which was not created from the template
+var ErrFolderConflict = errors.New("folder was not created from the template")
+
+// folderTemplateData are the fields available in the templates of a FolderTemplate
+type folderTemplateData struct {
+	Namespace string
+	Labels    map[string]string
+}
+
+// RenderFolderSpec executes the templated bucket name and path of the FolderTemplate for the namespace
+func RenderFolderSpec(folderTemplate *csfov1alpha1.FolderTemplate, namespace *v1.Namespace) (csfov1alpha1.FolderSpec, error) {
+	data := folderTemplateData{Namespace: namespace.Name, Labels: namespace.Labels}
+	spec := folderTemplate.Spec.Folder
+	var err error
+	spec.BucketName, err = render(spec.BucketName, data)
+	if err != nil {
+		return spec, fmt.Errorf("%w: bucketName of namespace %s: %w", ErrInvalidTemplate, namespace.Name, err)
+	}
+	spec.Name, err = render(spec.Name, data)
+	if err != nil {
+		return spec, fmt.Errorf("%w: name of namespace %s: %w", ErrInvalidTemplate, namespace.Name, err)
+	}
+	if spec.BucketName == "" || spec.Name == "" {
+		return spec, fmt.Errorf("%w: empty bucketName or name for namespace %s", ErrInvalidTemplate, namespace.Name)
+	}
+	return spec, nil
+}
+
+func render(text string, data folderTemplateData) (string, error) {
+	tmpl, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
+
+// TemplatedFolder creates or updates the Folder of the template in the namespace.
+// Folders of the same name not created from the template are left alone and reported as ErrFolderConflict.
+func TemplatedFolder(ctx context.Context, client client.Client,
+	owner *csfov1alpha1.FolderTemplate, namespace *v1.Namespace,
+) (*csfov1alpha1.Folder, error) {
+	spec, err := RenderFolderSpec(owner, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	folder := csfov1alpha1.Folder{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      owner.Spec.FolderName,
+			Namespace: namespace.Name,
+		},
+	}
+	_, err = ctrl.CreateOrUpdate(ctx, client, &folder, func() error {
+		if !folder.CreationTimestamp.IsZero() && folder.Labels[FolderTemplateLabel] != owner.Name {
+			return fmt.Errorf("%w: %s/%s", ErrFolderConflict, folder.Namespace, folder.Name)
+		}
+		if folder.Labels == nil {
+			folder.Labels = map[string]string{}
+		}
+		folder.Labels[FolderTemplateLabel] = owner.Name
+		folder.Spec = spec
+		return ctrl.SetControllerReference(owner, &folder, client.Scheme())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
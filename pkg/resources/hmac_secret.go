@@ -0,0 +1,49 @@
+package resources
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Keys of the HMAC key Secret, named like the variables of S3 tools so the Secret can be used with envFrom
+const (
+	HMACAccessIDKey = "AWS_ACCESS_KEY_ID"
+	HMACSecretKey   = "AWS_SECRET_ACCESS_KEY"
+	HMACEndpointKey = "AWS_ENDPOINT_URL"
+)
+
+// interoperabilityEndpoint is the S3 compatible XML API of Cloud Storage
+const interoperabilityEndpoint = "https://storage.googleapis.com"
+
+// HMACSecret creates a Secret holding an HMAC key for the S3 interoperability API of Cloud Storage.
+// An existing Secret not owned by owner is never overwritten, ErrNotOwned is returned instead.
+func HMACSecret(ctx context.Context, client client.Client,
+	owner client.Object, name, namespace, accessID, secret string,
+) (*v1.Secret, error) {
+	hmacSecret := v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	_, err := ctrl.CreateOrUpdate(ctx, client, &hmacSecret, func() error {
+		if err := checkOwned(&hmacSecret, owner); err != nil {
+			return err
+		}
+		hmacSecret.Data = map[string][]byte{
+			HMACAccessIDKey: []byte(accessID),
+			HMACSecretKey:   []byte(secret),
+			HMACEndpointKey: []byte(interoperabilityEndpoint),
+		}
+		setManagedBy(&hmacSecret)
+		return ctrl.SetControllerReference(owner, &hmacSecret, client.Scheme())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &hmacSecret, nil
+}
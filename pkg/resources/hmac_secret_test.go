@@ -0,0 +1,69 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+)
+
+func TestHMACSecret(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = csfov1alpha1.AddToScheme(scheme)
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "s3-credentials", Namespace: "team-a"},
+			Data:       map[string][]byte{HMACAccessIDKey: []byte("user-key")},
+		},
+	).Build()
+	folder := &csfov1alpha1.Folder{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "team-a", UID: "data-uid"},
+	}
+	ctx := context.Background()
+
+	_, err := HMACSecret(ctx, c, folder, "s3-credentials", "team-a", "access-id", "secret")
+	if !errors.Is(err, ErrNotOwned) {
+		t.Errorf("expected ErrNotOwned, got %v", err)
+	}
+	userSecret := types.NamespacedName{Name: "s3-credentials", Namespace: "team-a"}
+	if err := CheckOwned(ctx, c, userSecret, &v1.Secret{}, folder); !errors.Is(err, ErrNotOwned) {
+		t.Errorf("expected ErrNotOwned, got %v", err)
+	}
+	secret := &v1.Secret{}
+	if err := c.Get(ctx, userSecret, secret); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(secret.Data[HMACAccessIDKey]); got != "user-key" {
+		t.Errorf("expected the Secret of the user to be kept, got access ID %q", got)
+	}
+
+	// The Secret of the Folder is updated with the rotated key
+	folderSecret := types.NamespacedName{Name: "data-hmac", Namespace: "team-a"}
+	if err := CheckOwned(ctx, c, folderSecret, &v1.Secret{}, folder); err != nil {
+		t.Errorf("expected a missing Secret to pass, got %v", err)
+	}
+	for _, accessID := range []string{"key-1", "key-2"} {
+		if _, err := HMACSecret(ctx, c, folder, "data-hmac", "team-a", accessID, "secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := CheckOwned(ctx, c, folderSecret, &v1.Secret{}, folder); err != nil {
+		t.Errorf("expected the Secret of the Folder to pass, got %v", err)
+	}
+	if err := c.Get(ctx, folderSecret, secret); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(secret.Data[HMACAccessIDKey]); got != "key-2" {
+		t.Errorf("expected access ID key-2, got %q", got)
+	}
+}
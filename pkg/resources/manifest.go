@@ -0,0 +1,57 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// ReserveManifestConfigMap creates the empty manifest ConfigMap of a transfer before its worker Job starts.
+// The worker is only allowed to update this ConfigMap, as creates can not be restricted by name.
+func ReserveManifestConfigMap(ctx context.Context, client client.Client, owner client.Object, name string) error {
+	configMap := v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: owner.GetNamespace(),
+		},
+	}
+	_, err := ctrl.CreateOrUpdate(ctx, client, &configMap, func() error {
+		if err := checkOwned(&configMap, owner); err != nil {
+			return err
+		}
+		return controllerutil.SetOwnerReference(owner, &configMap, client.Scheme())
+	})
+	if err != nil {
+		return fmt.Errorf("ReserveManifestConfigMap: %w", err)
+	}
+	return nil
+}
+
+// ManifestConfigMap stores the manifest of a transfer in a ConfigMap owned by the transfer
+func ManifestConfigMap(ctx context.Context, client client.Client,
+	owner client.Object, name, fileName string, manifest []byte,
+) (*v1.ConfigMap, error) {
+	configMap := v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: owner.GetNamespace(),
+		},
+	}
+	_, err := ctrl.CreateOrUpdate(ctx, client, &configMap, func() error {
+		if err := checkOwned(&configMap, owner); err != nil {
+			return err
+		}
+		configMap.Data = map[string]string{fileName: string(manifest)}
+		// Not a controller reference, the worker Job is not allowed to block the deletion of its owner
+		return controllerutil.SetOwnerReference(owner, &configMap, client.Scheme())
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ManifestConfigMap: %w", err)
+	}
+	return &configMap, nil
+}
@@ -0,0 +1,65 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+)
+
+func TestManifestConfigMap(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = csfov1alpha1.AddToScheme(scheme)
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "team-a"},
+			Data:       map[string]string{"app.yaml": "debug: true"},
+		},
+	).Build()
+	ft := &csfov1alpha1.FileTransfer{
+		ObjectMeta: metav1.ObjectMeta{Name: "copy", Namespace: "team-a", UID: "copy-uid"},
+	}
+	ctx := context.Background()
+
+	if err := ReserveManifestConfigMap(ctx, c, ft, "manifest"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ManifestConfigMap(ctx, c, ft, "manifest", "manifest.jsonl", []byte("{}\n")); err != nil {
+		t.Fatal(err)
+	}
+	// Reserving again keeps the manifest written by the worker
+	if err := ReserveManifestConfigMap(ctx, c, ft, "manifest"); err != nil {
+		t.Fatal(err)
+	}
+	configMap := new(v1.ConfigMap)
+	if err := c.Get(ctx, types.NamespacedName{Name: "manifest", Namespace: "team-a"}, configMap); err != nil {
+		t.Fatal(err)
+	}
+	if configMap.Data["manifest.jsonl"] != "{}\n" || len(configMap.OwnerReferences) != 1 {
+		t.Errorf("unexpected manifest ConfigMap %+v", configMap)
+	}
+
+	// ConfigMaps of the user are never overwritten
+	if err := ReserveManifestConfigMap(ctx, c, ft, "settings"); !errors.Is(err, ErrNotOwned) {
+		t.Errorf("expected ErrNotOwned, got %v", err)
+	}
+	if _, err := ManifestConfigMap(ctx, c, ft, "settings", "manifest.jsonl", nil); !errors.Is(err, ErrNotOwned) {
+		t.Errorf("expected ErrNotOwned, got %v", err)
+	}
+	if err := c.Get(ctx, types.NamespacedName{Name: "settings", Namespace: "team-a"}, configMap); err != nil {
+		t.Fatal(err)
+	}
+	if configMap.Data["app.yaml"] != "debug: true" || len(configMap.OwnerReferences) != 0 {
+		t.Errorf("user ConfigMap was modified %+v", configMap)
+	}
+}
@@ -0,0 +1,63 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ManagedByLabel marks the Secrets written by the operator, only those are cached by the manager
+const (
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedBy      = "cloud-storage-file-operator"
+)
+
+// ErrNotOwned is returned when an object of the same name exists which was not created for the owner,
+// e.g. a ConfigMap or Secret of the user. It is left alone instead of being overwritten.
+var ErrNotOwned = errors.New("object exists and is not owned by the resource")
+
+// checkOwned fails with ErrNotOwned for an existing object without an owner reference to owner.
+// It is called from the mutate function of CreateOrUpdate, which fetched the object before.
+func checkOwned(object, owner client.Object) error {
+	if object.GetResourceVersion() == "" {
+		return nil
+	}
+	for _, ref := range object.GetOwnerReferences() {
+		if ref.UID == owner.GetUID() {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s/%s", ErrNotOwned, object.GetNamespace(), object.GetName())
+}
+
+// CheckOwned fails with ErrNotOwned if the object of the key exists and is not owned by owner
+func CheckOwned(ctx context.Context, c client.Client, key client.ObjectKey, object, owner client.Object) error {
+	if err := c.Get(ctx, key, object); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return checkOwned(object, owner)
+}
+
+// DeleteOwned deletes the object of the key if it is owned by owner, others are left alone.
+// An object which no longer exists is considered deleted.
+func DeleteOwned(ctx context.Context, c client.Client, key client.ObjectKey, object, owner client.Object) error {
+	if err := c.Get(ctx, key, object); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if err := checkOwned(object, owner); err != nil {
+		return nil
+	}
+	return client.IgnoreNotFound(c.Delete(ctx, object))
+}
+
+// setManagedBy labels an object written by the operator
+func setManagedBy(object client.Object) {
+	labels := object.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[ManagedByLabel] = ManagedBy
+	object.SetLabels(labels)
+}
@@ -10,11 +10,13 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// gcpServiceAccountAnnotation links a Kubernetes ServiceAccount to the service account it uses through workload identity
+const gcpServiceAccountAnnotation = "iam.gke.io/gcp-service-account"
+
 // ServiceAccountWIFEnabled creates a service account to be used with workload identity federation
 func ServiceAccountWIFEnabled(ctx context.Context, client client.Client,
 	owner client.Object, kubernetesSAName, namespace, gcpSAName, gcpProjectID string,
 ) (*v1.ServiceAccount, error) {
-	annotationK8sSAKey := "iam.gke.io/gcp-service-account"
 	annotationK8sSAValue := fmt.Sprintf("%s@%s.iam.gserviceaccount.com", gcpSAName, gcpProjectID)
 
 	sa := v1.ServiceAccount{
@@ -27,7 +29,7 @@ func ServiceAccountWIFEnabled(ctx context.Context, client client.Client,
 		if sa.Annotations == nil {
 			sa.Annotations = map[string]string{}
 		}
-		sa.Annotations[annotationK8sSAKey] = annotationK8sSAValue
+		sa.Annotations[gcpServiceAccountAnnotation] = annotationK8sSAValue
 
 		err := ctrl.SetControllerReference(owner, &sa, client.Scheme())
 		if err != nil {
@@ -40,3 +42,25 @@ func ServiceAccountWIFEnabled(ctx context.Context, client client.Client,
 	}
 	return &sa, nil
 }
+
+// ServiceAccountDirect creates a service account whose principal is granted IAM roles directly,
+// without a linked GCP service account
+func ServiceAccountDirect(ctx context.Context, client client.Client,
+	owner client.Object, kubernetesSAName, namespace string,
+) (*v1.ServiceAccount, error) {
+	sa := v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      kubernetesSAName,
+			Namespace: namespace,
+		},
+	}
+	_, err := ctrl.CreateOrUpdate(ctx, client, &sa, func() error {
+		// A Folder switching its identity mode no longer uses the service account
+		delete(sa.Annotations, gcpServiceAccountAnnotation)
+		return ctrl.SetControllerReference(owner, &sa, client.Scheme())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sa, nil
+}
@@ -0,0 +1,64 @@
+package resources
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+)
+
+// Keys of the signed URL targets
+const (
+	// SignedURLsKey maps the object names to their URLs as JSON object
+	SignedURLsKey = "urls.json"
+	// SignedURLKey is the URL of a single object
+	SignedURLKey = "url"
+	// SignedURLExpiresKey is when the URLs expire, RFC 3339 formatted
+	SignedURLExpiresKey = "expires"
+)
+
+// SignedURLTarget writes the signed URLs to the Secret or the ConfigMap of the target, replacing the previous URLs.
+// An existing Secret or ConfigMap not owned by owner is never overwritten, ErrNotOwned is returned instead.
+func SignedURLTarget(ctx context.Context, client client.Client,
+	owner client.Object, target csfov1alpha1.SignedURLTarget, namespace string, data map[string]string,
+) error {
+	if target.SecretName != "" {
+		secret := v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      target.SecretName,
+				Namespace: namespace,
+			},
+		}
+		_, err := ctrl.CreateOrUpdate(ctx, client, &secret, func() error {
+			if err := checkOwned(&secret, owner); err != nil {
+				return err
+			}
+			secret.Data = make(map[string][]byte, len(data))
+			for key, value := range data {
+				secret.Data[key] = []byte(value)
+			}
+			setManagedBy(&secret)
+			return ctrl.SetControllerReference(owner, &secret, client.Scheme())
+		})
+		return err
+	}
+
+	configMap := v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      target.ConfigMapName,
+			Namespace: namespace,
+		},
+	}
+	_, err := ctrl.CreateOrUpdate(ctx, client, &configMap, func() error {
+		if err := checkOwned(&configMap, owner); err != nil {
+			return err
+		}
+		configMap.Data = data
+		return ctrl.SetControllerReference(owner, &configMap, client.Scheme())
+	})
+	return err
+}
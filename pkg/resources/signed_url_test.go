@@ -0,0 +1,67 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+)
+
+func TestSignedURLTarget(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = csfov1alpha1.AddToScheme(scheme)
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "token", Namespace: "team-a"},
+			Data:       map[string][]byte{"token": []byte("user")},
+		},
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "team-a"},
+			Data:       map[string]string{"app.yaml": "debug: true"},
+		},
+	).Build()
+	signedURL := &csfov1alpha1.SignedURL{
+		ObjectMeta: metav1.ObjectMeta{Name: "report", Namespace: "team-a", UID: "report-uid"},
+	}
+	ctx := context.Background()
+	data := map[string]string{SignedURLKey: "https://storage.googleapis.com/bucket/report.csv"}
+
+	for _, target := range []csfov1alpha1.SignedURLTarget{{SecretName: "token"}, {ConfigMapName: "settings"}} {
+		if err := SignedURLTarget(ctx, c, signedURL, target, "team-a", data); !errors.Is(err, ErrNotOwned) {
+			t.Errorf("expected ErrNotOwned for %+v, got %v", target, err)
+		}
+	}
+	configMap := &v1.ConfigMap{}
+	if err := c.Get(ctx, types.NamespacedName{Name: "settings", Namespace: "team-a"}, configMap); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := configMap.Data[SignedURLKey]; ok {
+		t.Errorf("expected the ConfigMap of the user to be kept, got %v", configMap.Data)
+	}
+
+	// The target of the SignedURL is replaced on every renewal
+	for _, target := range []csfov1alpha1.SignedURLTarget{{SecretName: "report-url"}, {ConfigMapName: "report-url"}} {
+		for range 2 {
+			if err := SignedURLTarget(ctx, c, signedURL, target, "team-a", data); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	secret := &v1.Secret{}
+	if err := c.Get(ctx, types.NamespacedName{Name: "report-url", Namespace: "team-a"}, secret); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(secret.Data[SignedURLKey]); got != data[SignedURLKey] {
+		t.Errorf("expected the url in the Secret, got %q", got)
+	}
+}
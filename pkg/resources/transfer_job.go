@@ -0,0 +1,211 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+	"github.com/sijoma/cloud-storage-file-operator/pkg/retrievers"
+)
+
+const (
+	// TransferCommand is the subcommand of the manager binary running a single FileTransfer
+	TransferCommand = "transfer"
+	// PermanentFailureExitCode is returned by the worker for errors retrying does not fix, it fails the Job right away
+	PermanentFailureExitCode = 2
+
+	transferCredentialsPath = "/var/run/secrets/csfo"
+)
+
+// TransferJobName is the name of the worker Job (and its RBAC) for the FileTransfer
+func TransferJobName(owner *csfov1alpha1.FileTransfer) string {
+	return owner.Name + "-transfer"
+}
+
+// TransferWorkerRBAC allows the ServiceAccount of the worker Job to read its FileTransfer, report progress
+// and write its manifest ConfigMap
+func TransferWorkerRBAC(ctx context.Context, client client.Client, owner *csfov1alpha1.FileTransfer, serviceAccount string) error {
+	name := TransferJobName(owner)
+	role := rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: owner.Namespace,
+		},
+	}
+	_, err := ctrl.CreateOrUpdate(ctx, client, &role, func() error {
+		role.Rules = []rbacv1.PolicyRule{
+			{
+				APIGroups:     []string{csfov1alpha1.GroupVersion.Group},
+				Resources:     []string{"filetransfers"},
+				ResourceNames: []string{owner.Name},
+				Verbs:         []string{"get"},
+			},
+			{
+				APIGroups:     []string{csfov1alpha1.GroupVersion.Group},
+				Resources:     []string{"filetransfers/status"},
+				ResourceNames: []string{owner.Name},
+				Verbs:         []string{"get", "update", "patch"},
+			},
+		}
+		// The manifest ConfigMap is reserved by the controller, the worker only fills it
+		if manifest := owner.Spec.Manifest; manifest != nil && manifest.ConfigMap != "" {
+			role.Rules = append(role.Rules, rbacv1.PolicyRule{
+				APIGroups:     []string{""},
+				Resources:     []string{"configmaps"},
+				ResourceNames: []string{manifest.ConfigMap},
+				Verbs:         []string{"get", "update"},
+			})
+		}
+		return ctrl.SetControllerReference(owner, &role, client.Scheme())
+	})
+	if err != nil {
+		return fmt.Errorf("TransferWorkerRBAC: %w", err)
+	}
+
+	binding := rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: owner.Namespace,
+		},
+	}
+	_, err = ctrl.CreateOrUpdate(ctx, client, &binding, func() error {
+		binding.RoleRef = rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     role.Name,
+		}
+		binding.Subjects = []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      serviceAccount,
+			Namespace: owner.Namespace,
+		}}
+		return ctrl.SetControllerReference(owner, &binding, client.Scheme())
+	})
+	if err != nil {
+		return fmt.Errorf("TransferWorkerRBAC: %w", err)
+	}
+	return nil
+}
+
+// TransferJob creates the worker Job copying the files of the FileTransfer, an existing Job is returned as is.
+// folder is the Folder the transfer runs as, nil without one.
+func TransferJob(ctx context.Context, client client.Client, owner *csfov1alpha1.FileTransfer, folder *csfov1alpha1.Folder,
+	image, serviceAccount string,
+) (*batchv1.Job, error) {
+	job := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      TransferJobName(owner),
+			Namespace: owner.Namespace,
+		},
+	}
+	err := client.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, &job)
+	if err == nil {
+		return &job, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("TransferJob: %w", err)
+	}
+
+	container := v1.Container{
+		Name:    "transfer",
+		Image:   image,
+		Command: []string{"/manager"},
+		Args:    []string{TransferCommand, "--name", owner.Name, "--namespace", owner.Namespace},
+		SecurityContext: &v1.SecurityContext{
+			AllowPrivilegeEscalation: ptr.To(false),
+			Capabilities: &v1.Capabilities{
+				Drop: []v1.Capability{"ALL"},
+			},
+		},
+	}
+	podSpec := v1.PodSpec{
+		RestartPolicy:      v1.RestartPolicyNever,
+		ServiceAccountName: serviceAccount,
+		SecurityContext: &v1.PodSecurityContext{
+			RunAsNonRoot: ptr.To(true),
+		},
+	}
+
+	// A static key is mounted and picked up as application default credentials
+	if secret := owner.Spec.BucketSecret; secret != nil {
+		secretKey := retrievers.ServiceAccountKey
+		if owner.Spec.Credentials != nil && owner.Spec.Credentials.SecretKey != "" {
+			secretKey = owner.Spec.Credentials.SecretKey
+		}
+		if secret.Namespace != "" && secret.Namespace != owner.Namespace {
+			return nil, fmt.Errorf("TransferJob: bucketSecret from namespace %s can not be mounted into %s",
+				secret.Namespace, owner.Namespace)
+		}
+		podSpec.Volumes = append(podSpec.Volumes, v1.Volume{
+			Name: "credentials",
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{SecretName: secret.Name},
+			},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{
+			Name:      "credentials",
+			MountPath: transferCredentialsPath,
+			ReadOnly:  true,
+		})
+		container.Env = append(container.Env, v1.EnvVar{
+			Name:  "GOOGLE_APPLICATION_CREDENTIALS",
+			Value: transferCredentialsPath + "/" + secretKey,
+		})
+		// The key replaces the workload identity of the pod, the Folder is impersonated like inline transfers do
+		if folder != nil {
+			if folder.Status.Email == "" {
+				return nil, fmt.Errorf("TransferJob: folder %s uses the Direct identity mode, "+
+					"it can not be impersonated with bucketSecret", folder.Name)
+			}
+			container.Args = append(container.Args, "--impersonate", folder.Status.Email)
+		}
+	}
+	podSpec.Containers = []v1.Container{container}
+
+	job.Spec = batchv1.JobSpec{
+		BackoffLimit: ptr.To[int32](3),
+		PodFailurePolicy: &batchv1.PodFailurePolicy{
+			Rules: []batchv1.PodFailurePolicyRule{{
+				Action: batchv1.PodFailurePolicyActionFailJob,
+				OnExitCodes: &batchv1.PodFailurePolicyOnExitCodesRequirement{
+					Operator: batchv1.PodFailurePolicyOnExitCodesOpIn,
+					Values:   []int32{PermanentFailureExitCode},
+				},
+			}},
+		},
+		Template: v1.PodTemplateSpec{
+			Spec: podSpec,
+		},
+	}
+	err = ctrl.SetControllerReference(owner, &job, client.Scheme())
+	if err != nil {
+		return nil, fmt.Errorf("TransferJob: %w", err)
+	}
+	err = client.Create(ctx, &job)
+	if err != nil {
+		return nil, fmt.Errorf("TransferJob: %w", err)
+	}
+	return &job, nil
+}
+
+// TransferServiceAccount is the ServiceAccount the worker Job runs as: the one of the spec,
+// the one of the referenced Folder or the namespace default
+func TransferServiceAccount(owner *csfov1alpha1.FileTransfer, folder *csfov1alpha1.Folder) string {
+	switch {
+	case owner.Spec.ServiceAccountName != "":
+		return owner.Spec.ServiceAccountName
+	case folder != nil:
+		return folder.Status.ServiceAccountName
+	}
+	return "default"
+}
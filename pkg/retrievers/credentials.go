@@ -2,17 +2,45 @@ package retrievers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"google.golang.org/api/option"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/sijoma/cloud-storage-file-operator/pkg/tracing"
 )
 
-func Credentials(client client.Reader, ctx context.Context, key types.NamespacedName) (option.ClientOption, error) {
+// ServiceAccountKey is the default key inside the secret holding the credentials JSON
+const ServiceAccountKey = "service_account_private_key"
+
+// supportedCredentialTypes are the credential files understood by the google client libraries
+var supportedCredentialTypes = map[string]bool{
+	"service_account":              true,
+	"external_account":             true,
+	"impersonated_service_account": true,
+	"authorized_user":              true,
+}
+
+// Credentials reads the credentials JSON under dataKey (ServiceAccountKey if empty) of the secret.
+// Service account keys and external account configurations (workload identity federation) are supported.
+// The returned version identifies the content of the secret (UID and resourceVersion),
+// it changes whenever the secret is rotated.
+// Secrets of other namespaces have to allow the namespace using them, see AllowedNamespacesAnnotation.
+func Credentials(client client.Reader, ctx context.Context, namespace string, key types.NamespacedName, dataKey string) (option.ClientOption, string, error) {
 	logger := log.FromContext(ctx)
+	ctx, span := tracing.Tracer().Start(ctx, "retrievers.Credentials")
+	defer span.End()
+	span.SetAttributes(attribute.String("secret", key.String()))
+
+	if dataKey == "" {
+		dataKey = ServiceAccountKey
+	}
 
 	var jsonKey []byte
 	var bucketSecret v1.Secret
@@ -22,12 +50,31 @@ func Credentials(client client.Reader, ctx context.Context, key types.Namespaced
 		&bucketSecret,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("unable to extract bucket secret: %w", err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, "", fmt.Errorf("unable to extract bucket secret: %w", err)
 	}
-	jsonKey = bucketSecret.Data["service_account_private_key"]
-	if jsonKey != nil {
-		logger.Info("adding json to gcs")
-		return option.WithCredentialsJSON(jsonKey), nil
+	if err := CheckSecretAccess(&bucketSecret, namespace); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, "", err
 	}
-	return nil, fmt.Errorf("unable to extract json key from secret")
+	jsonKey = bucketSecret.Data[dataKey]
+	if jsonKey == nil {
+		span.SetStatus(codes.Error, "no json key in secret")
+		return nil, "", fmt.Errorf("unable to extract json key %s from secret", dataKey)
+	}
+
+	var credentialFile struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(jsonKey, &credentialFile); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, "", fmt.Errorf("invalid credentials json in secret: %w", err)
+	}
+	if !supportedCredentialTypes[credentialFile.Type] {
+		span.SetStatus(codes.Error, "unsupported credentials type")
+		return nil, "", fmt.Errorf("unsupported credentials type %q in secret", credentialFile.Type)
+	}
+
+	logger.Info("adding json to gcs", "type", credentialFile.Type)
+	return option.WithCredentialsJSON(jsonKey), string(bucketSecret.UID) + "/" + bucketSecret.ResourceVersion, nil
 }
@@ -0,0 +1,54 @@
+package retrievers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+)
+
+// AllowedNamespacesAnnotation on a Secret lists the namespaces, comma separated, whose FileTransfers may use it.
+// "*" allows all namespaces. Secrets are always usable from their own namespace.
+const AllowedNamespacesAnnotation = "csfo.sijoma.dev/allowed-namespaces"
+
+// ErrNamespaceNotAllowed is returned for secrets referenced from a namespace they do not allow
+var ErrNamespaceNotAllowed = errors.New("namespace not allowed to use the secret")
+
+// CheckSecretAccess returns ErrNamespaceNotAllowed unless the secret may be used from the namespace
+func CheckSecretAccess(secret *v1.Secret, namespace string) error {
+	if secret.Namespace == namespace {
+		return nil
+	}
+	for _, allowed := range strings.Split(secret.Annotations[AllowedNamespacesAnnotation], ",") {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "*" || allowed == namespace {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s/%s is not shared with namespace %s (annotation %s)",
+		ErrNamespaceNotAllowed, secret.Namespace, secret.Name, namespace, AllowedNamespacesAnnotation)
+}
+
+// AuthorizeSecret checks that the bucket secret of the transfer may be used from its namespace.
+// Transfers without secret pass. A secret of another namespace which does not exist (yet) shares nothing,
+// such transfers are authorized again once it is created.
+func AuthorizeSecret(ctx context.Context, reader client.Reader, ft *csfov1alpha1.FileTransfer) error {
+	key := BucketSecret(ft)
+	if key == nil || key.Namespace == ft.Namespace {
+		return nil
+	}
+	var secret v1.Secret
+	if err := reader.Get(ctx, *key, &secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("%w: %s does not exist", ErrNamespaceNotAllowed, key)
+		}
+		return fmt.Errorf("AuthorizeSecret: %w", err)
+	}
+	return CheckSecretAccess(&secret, ft.Namespace)
+}
@@ -0,0 +1,163 @@
+package retrievers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/api/option"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+)
+
+// AmbientCredentials is the source of the credentials of the environment, e.g. the operator's workload identity
+const AmbientCredentials = "ambient"
+
+// ErrImpersonationNotAllowed is returned for transfers which would impersonate a service account
+// with the credentials of the operator
+var ErrImpersonationNotAllowed = errors.New("impersonation not allowed")
+
+// TransferCredentials are the client options of a FileTransfer
+type TransferCredentials struct {
+	Options []option.ClientOption
+	// Source identifies where the credentials come from, Version changes whenever they are rotated
+	Source  string
+	Version string
+}
+
+// BucketSecret is the secret referenced by the transfer, nil without one
+func BucketSecret(ft *csfov1alpha1.FileTransfer) *types.NamespacedName {
+	if ft.Spec.BucketSecret == nil {
+		return nil
+	}
+	namespace := ft.Namespace
+	if ft.Spec.BucketSecret.Namespace != "" {
+		namespace = ft.Spec.BucketSecret.Namespace
+	}
+	return &types.NamespacedName{Name: ft.Spec.BucketSecret.Name, Namespace: namespace}
+}
+
+// Folder returns the Folder referenced by the credentials of the transfer, nil without one.
+// A Folder without Kubernetes ServiceAccount is not ready yet and returned as error.
+func Folder(ctx context.Context, reader client.Reader, ft *csfov1alpha1.FileTransfer) (*csfov1alpha1.Folder, error) {
+	if ft.Spec.Credentials == nil || ft.Spec.Credentials.FolderRef == nil {
+		return nil, nil
+	}
+	folder := new(csfov1alpha1.Folder)
+	key := types.NamespacedName{Name: ft.Spec.Credentials.FolderRef.Name, Namespace: ft.Namespace}
+	if err := reader.Get(ctx, key, folder); err != nil {
+		return nil, fmt.Errorf("Folder: %w", err)
+	}
+	if folder.Status.ServiceAccountName == "" {
+		return nil, fmt.Errorf("Folder: folder %s has no service account yet", key.Name)
+	}
+	return folder, nil
+}
+
+// AuthorizeImpersonation rejects a service account to impersonate which the credentials of the operator would
+// impersonate: the operator may impersonate the service accounts of all Folders. The base credentials have to come
+// from the bucket secret or, for worker Jobs, the workload identity of the namespace.
+func AuthorizeImpersonation(ft *csfov1alpha1.FileTransfer) error {
+	if ft.Spec.Credentials == nil || ft.Spec.Credentials.ImpersonateServiceAccount == "" {
+		return nil
+	}
+	if ft.Spec.BucketSecret != nil || ft.Spec.Executor == csfov1alpha1.ExecutorJob {
+		return nil
+	}
+	return fmt.Errorf("%w: impersonating %s needs a bucketSecret with the base credentials or the Job executor",
+		ErrImpersonationNotAllowed, ft.Spec.Credentials.ImpersonateServiceAccount)
+}
+
+// ForTransfer resolves the credentials of a transfer running inside the operator: the bucket secret
+// (or the ambient credentials), impersonating the service account of the credentials or the referenced Folder.
+func ForTransfer(ctx context.Context, reader client.Reader, ft *csfov1alpha1.FileTransfer) (*TransferCredentials, error) {
+	if err := AuthorizeImpersonation(ft); err != nil {
+		return nil, err
+	}
+	credentials := &TransferCredentials{Source: AmbientCredentials}
+
+	if secretKey := BucketSecret(ft); secretKey != nil {
+		dataKey := ServiceAccountKey
+		if ft.Spec.Credentials != nil && ft.Spec.Credentials.SecretKey != "" {
+			dataKey = ft.Spec.Credentials.SecretKey
+		}
+		secretCredentials, version, err := Credentials(reader, ctx, ft.Namespace, *secretKey, dataKey)
+		if err != nil {
+			return nil, err
+		}
+		credentials.Options = append(credentials.Options, secretCredentials)
+		// Keys of the same secret hold different credentials
+		credentials.Source, credentials.Version = "secret:"+secretKey.String()+"#"+dataKey, version
+	}
+
+	var target string
+	if ft.Spec.Credentials != nil {
+		target = ft.Spec.Credentials.ImpersonateServiceAccount
+	}
+	folder, err := Folder(ctx, reader, ft)
+	if err != nil {
+		return nil, err
+	}
+	if folder != nil {
+		if err := AuthorizeFolder(folder, ft.Namespace); err != nil {
+			return nil, fmt.Errorf("ForTransfer: %w", err)
+		}
+		target = folder.Status.Email
+	}
+	if target != "" {
+		credentials.Options = append(credentials.Options, impersonate(target))
+		credentials.Source += "|impersonate:" + target
+	}
+	return credentials, nil
+}
+
+// AuthorizeFolder returns ErrImpersonationNotAllowed unless the service account of the Folder may be impersonated
+// on behalf of the namespace: the operator may impersonate the service accounts of all Folders, a namespace only
+// the ones of its own Folders.
+func AuthorizeFolder(folder *csfov1alpha1.Folder, namespace string) error {
+	if folder.Namespace != namespace {
+		return fmt.Errorf("%w: folder %s/%s belongs to another namespace than %s",
+			ErrImpersonationNotAllowed, folder.Namespace, folder.Name, namespace)
+	}
+	// Without service account the permissions are granted to the Kubernetes ServiceAccount only
+	if folder.Status.Email == "" {
+		return fmt.Errorf("%w: folder %s uses the Direct identity mode, it needs the Job executor",
+			ErrImpersonationNotAllowed, folder.Name)
+	}
+	return nil
+}
+
+// ForFolder are the ambient credentials impersonating the service account of a Folder of the namespace
+func ForFolder(folder *csfov1alpha1.Folder, namespace string) (*TransferCredentials, error) {
+	if err := AuthorizeFolder(folder, namespace); err != nil {
+		return nil, fmt.Errorf("ForFolder: %w", err)
+	}
+	return &TransferCredentials{
+		Options: []option.ClientOption{impersonate(folder.Status.Email)},
+		Source:  AmbientCredentials + "|impersonate:" + folder.Status.Email,
+	}, nil
+}
+
+// WorkerOptions are the client options of a worker Job. The base credentials come from the environment,
+// a worker of a Folder runs as its service account. Mounting a bucket secret replaces the identity of the pod,
+// such workers impersonate the service account of the Folder (folderServiceAccount) like inline transfers do.
+func WorkerOptions(ft *csfov1alpha1.FileTransfer, folderServiceAccount string) []option.ClientOption {
+	var target string
+	if ft.Spec.Credentials != nil {
+		target = ft.Spec.Credentials.ImpersonateServiceAccount
+	}
+	if folderServiceAccount != "" {
+		target = folderServiceAccount
+	}
+	if target == "" {
+		return nil
+	}
+	return []option.ClientOption{impersonate(target)}
+}
+
+func impersonate(target string) option.ClientOption {
+	//nolint:staticcheck // the impersonate package needs the scopes which the storage client sets itself
+	return option.ImpersonateCredentials(target)
+}
@@ -0,0 +1,238 @@
+package retrievers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	csfov1alpha1 "github.com/sijoma/cloud-storage-file-operator/api/v1alpha1"
+)
+
+func TestForTransfer(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = csfov1alpha1.AddToScheme(scheme)
+
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "team-a"},
+			Data: map[string][]byte{
+				"wif.json":   []byte(`{"type": "external_account"}`),
+				"other.json": []byte(`{"type": "gdch_service_account"}`),
+				"sa.json":    []byte(`{"type": "service_account"}`),
+			},
+		},
+		&csfov1alpha1.Folder{
+			ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "team-a"},
+			Status:     csfov1alpha1.FolderStatus{Email: "data-team-a@project.iam.gserviceaccount.com", ServiceAccountName: "data-owner"},
+		},
+	).Build()
+
+	ft := &csfov1alpha1.FileTransfer{
+		ObjectMeta: metav1.ObjectMeta{Name: "copy", Namespace: "team-a"},
+		Spec: csfov1alpha1.FileTransferSpec{
+			BucketSecret: &v1.SecretReference{Name: "creds"},
+			Credentials: &csfov1alpha1.Credentials{
+				SecretKey: "wif.json",
+				FolderRef: &v1.LocalObjectReference{Name: "data"},
+			},
+		},
+	}
+	credentials, err := ForTransfer(context.Background(), reader, ft)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "secret:team-a/creds#wif.json|impersonate:data-team-a@project.iam.gserviceaccount.com"
+	if credentials.Source != want || len(credentials.Options) != 2 || credentials.Version == "" {
+		t.Errorf("unexpected credentials %+v", credentials)
+	}
+
+	// Another key of the same secret must not share the cached client
+	ft.Spec.Credentials.SecretKey = "sa.json"
+	other, err := ForTransfer(context.Background(), reader, ft)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Source == credentials.Source {
+		t.Errorf("expected the data key to be part of the source, got %s", other.Source)
+	}
+
+	ft.Spec.Credentials.SecretKey = "other.json"
+	if _, err := ForTransfer(context.Background(), reader, ft); err == nil {
+		t.Error("expected unsupported credential types to be rejected")
+	}
+
+	ft.Spec.Credentials.SecretKey = "missing"
+	if _, err := ForTransfer(context.Background(), reader, ft); err == nil {
+		t.Error("expected a missing secret key to be rejected")
+	}
+}
+
+func TestForTransferCrossNamespace(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = csfov1alpha1.AddToScheme(scheme)
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "shared"},
+		Data:       map[string][]byte{ServiceAccountKey: []byte(`{"type": "service_account"}`)},
+	}
+	ft := &csfov1alpha1.FileTransfer{
+		ObjectMeta: metav1.ObjectMeta{Name: "copy", Namespace: "team-a"},
+		Spec: csfov1alpha1.FileTransferSpec{
+			BucketSecret: &v1.SecretReference{Name: "creds", Namespace: "shared"},
+		},
+	}
+
+	for annotation, allowed := range map[string]bool{
+		"":               false,
+		"team-b":         false,
+		"team-b, team-a": true,
+		"*":              true,
+	} {
+		secret.Annotations = map[string]string{AllowedNamespacesAnnotation: annotation}
+		reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(secret.DeepCopy()).Build()
+
+		_, err := ForTransfer(context.Background(), reader, ft)
+		if allowed && err != nil {
+			t.Errorf("%q: unexpected error %v", annotation, err)
+		}
+		if !allowed && !errors.Is(err, ErrNamespaceNotAllowed) {
+			t.Errorf("%q: expected ErrNamespaceNotAllowed, got %v", annotation, err)
+		}
+		if err := AuthorizeSecret(context.Background(), reader, ft); allowed != (err == nil) {
+			t.Errorf("%q: unexpected authorization %v", annotation, err)
+		}
+	}
+}
+
+func TestAuthorizeMissingSecret(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = csfov1alpha1.AddToScheme(scheme)
+
+	reader := fake.NewClientBuilder().WithScheme(scheme).Build()
+	ft := &csfov1alpha1.FileTransfer{
+		ObjectMeta: metav1.ObjectMeta{Name: "copy", Namespace: "team-a"},
+		Spec: csfov1alpha1.FileTransferSpec{
+			BucketSecret: &v1.SecretReference{Name: "creds", Namespace: "shared"},
+		},
+	}
+	if err := AuthorizeSecret(context.Background(), reader, ft); !errors.Is(err, ErrNamespaceNotAllowed) {
+		t.Errorf("expected a missing secret of another namespace to be rejected, got %v", err)
+	}
+	ft.Spec.BucketSecret.Namespace = ""
+	if err := AuthorizeSecret(context.Background(), reader, ft); err != nil {
+		t.Errorf("expected a missing secret of the own namespace to pass, got %v", err)
+	}
+}
+
+func TestForTransferDirectFolder(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = csfov1alpha1.AddToScheme(scheme)
+
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&csfov1alpha1.Folder{
+			ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "team-a"},
+			Spec:       csfov1alpha1.FolderSpec{IdentityMode: csfov1alpha1.IdentityModeDirect},
+			Status:     csfov1alpha1.FolderStatus{ServiceAccountName: "data-owner"},
+		},
+	).Build()
+	ft := &csfov1alpha1.FileTransfer{
+		ObjectMeta: metav1.ObjectMeta{Name: "copy", Namespace: "team-a"},
+		Spec: csfov1alpha1.FileTransferSpec{
+			Credentials: &csfov1alpha1.Credentials{FolderRef: &v1.LocalObjectReference{Name: "data"}},
+		},
+	}
+
+	folder, err := Folder(context.Background(), reader, ft)
+	if err != nil || folder.Status.ServiceAccountName != "data-owner" {
+		t.Errorf("expected the Direct folder to be usable by worker Jobs, got %v", err)
+	}
+	if _, err := ForTransfer(context.Background(), reader, ft); err == nil {
+		t.Error("expected inline transfers of a Direct folder to be rejected")
+	}
+}
+
+func TestAuthorizeImpersonation(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = csfov1alpha1.AddToScheme(scheme)
+
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "team-a"},
+			Data:       map[string][]byte{ServiceAccountKey: []byte(`{"type": "service_account"}`)},
+		},
+	).Build()
+	transfer := func(bucketSecret *v1.SecretReference, executor csfov1alpha1.Executor) *csfov1alpha1.FileTransfer {
+		return &csfov1alpha1.FileTransfer{
+			ObjectMeta: metav1.ObjectMeta{Name: "copy", Namespace: "team-a"},
+			Spec: csfov1alpha1.FileTransferSpec{
+				BucketSecret: bucketSecret,
+				Executor:     executor,
+				Credentials:  &csfov1alpha1.Credentials{ImpersonateServiceAccount: "other@project.iam.gserviceaccount.com"},
+			},
+		}
+	}
+
+	ambient := transfer(nil, "")
+	if err := AuthorizeImpersonation(ambient); !errors.Is(err, ErrImpersonationNotAllowed) {
+		t.Errorf("expected ErrImpersonationNotAllowed, got %v", err)
+	}
+	if _, err := ForTransfer(context.Background(), reader, ambient); !errors.Is(err, ErrImpersonationNotAllowed) {
+		t.Errorf("expected the operator credentials not to impersonate, got %v", err)
+	}
+	if err := AuthorizeImpersonation(transfer(nil, csfov1alpha1.ExecutorJob)); err != nil {
+		t.Errorf("expected worker Jobs to impersonate with their own identity: %v", err)
+	}
+	withSecret := transfer(&v1.SecretReference{Name: "creds"}, "")
+	credentials, err := ForTransfer(context.Background(), reader, withSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "secret:team-a/creds#" + ServiceAccountKey + "|impersonate:other@project.iam.gserviceaccount.com"; credentials.Source != want {
+		t.Errorf("expected %s, got %s", want, credentials.Source)
+	}
+}
+
+func TestWorkerOptions(t *testing.T) {
+	ft := &csfov1alpha1.FileTransfer{}
+	if options := WorkerOptions(ft, ""); len(options) != 0 {
+		t.Errorf("expected workers without Folder to use their own identity, got %d options", len(options))
+	}
+	if options := WorkerOptions(ft, "data-team-a@project.iam.gserviceaccount.com"); len(options) != 1 {
+		t.Errorf("expected workers of a Folder to impersonate it, got %d options", len(options))
+	}
+	ft.Spec.Credentials = &csfov1alpha1.Credentials{ImpersonateServiceAccount: "other@project.iam.gserviceaccount.com"}
+	if options := WorkerOptions(ft, ""); len(options) != 1 {
+		t.Errorf("expected workers to impersonate the service account, got %d options", len(options))
+	}
+}
+
+func TestForFolder(t *testing.T) {
+	folder := &csfov1alpha1.Folder{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "team-a"},
+		Status:     csfov1alpha1.FolderStatus{Email: "data-team-a@project.iam.gserviceaccount.com"},
+	}
+	credentials, err := ForFolder(folder, "team-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ambient|impersonate:data-team-a@project.iam.gserviceaccount.com"; credentials.Source != want {
+		t.Errorf("expected %s, got %s", want, credentials.Source)
+	}
+	if _, err := ForFolder(folder, "team-b"); !errors.Is(err, ErrImpersonationNotAllowed) {
+		t.Errorf("expected folders of other namespaces to be rejected, got %v", err)
+	}
+	folder.Status.Email = ""
+	if _, err := ForFolder(folder, "team-a"); !errors.Is(err, ErrImpersonationNotAllowed) {
+		t.Errorf("expected Direct folders to be rejected, got %v", err)
+	}
+}